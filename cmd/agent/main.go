@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/takakrypt/transparent-encryption/internal/agent"
 	"github.com/takakrypt/transparent-encryption/internal/config"
@@ -17,6 +18,16 @@ var (
 	configDir = flag.String("config", "./", "Configuration directory path")
 	logLevel  = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 	daemon    = flag.Bool("daemon", false, "Run as daemon")
+
+	statusSocket  = flag.String("status-socket", "", "Unix socket path for the JSON status API (disabled if empty)")
+	watchInterval = flag.Duration("watch-interval", 5*time.Second, "How often to check configuration files for changes (0 disables)")
+
+	decisionCacheSize = flag.Int("decision-cache-size", 4096, "Maximum number of cached policy decisions (0 disables the cache)")
+	pinDecisions      = flag.Bool("pin-decisions", false, "Reuse the decision made at open for reads and writes through the same file handle")
+
+	ldapCache = flag.String("ldap-cache", "/var/lib/takakrypt/ldap-cache.json", "File caching the members of LDAP user sets for use while the directory is unreachable")
+
+	containerRuntimeSocket = flag.String("container-runtime-socket", "", "Docker Engine API socket used to resolve container images, e.g. /var/run/docker.sock (disabled if empty)")
 )
 
 func main() {
@@ -31,6 +42,11 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create agent: %v", err)
 	}
+	agentService.ConfigureDecisions(*decisionCacheSize, *pinDecisions)
+	agentService.SetLDAPCache(*ldapCache)
+	if *containerRuntimeSocket != "" {
+		agentService.SetContainerRuntime(*containerRuntimeSocket)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -44,6 +60,31 @@ func main() {
 		cancel()
 	}()
 
+	// SIGHUP reloads policies and guard points without unmounting
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	go func() {
+		for range hupCh {
+			log.Printf("Received SIGHUP, reloading configuration")
+			if err := agentService.Reload(); err != nil {
+				log.Printf("Reload failed: %v", err)
+			}
+		}
+	}()
+
+	if *watchInterval > 0 {
+		go agentService.WatchConfig(ctx, *watchInterval)
+	}
+
+	if *statusSocket != "" {
+		go func() {
+			if err := agentService.ServeStatus(ctx, *statusSocket); err != nil {
+				log.Printf("Status API unavailable: %v", err)
+			}
+		}()
+	}
+
 	if err := agentService.Start(ctx); err != nil {
 		log.Fatalf("Agent failed: %v", err)
 	}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/directory"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// explain implements "takakrypt policy explain" and returns the exit code.
+func explain(args []string) int {
+	fs := flag.NewFlagSet("policy explain", flag.ExitOnError)
+	configDir := fs.String("config", "./", "Configuration directory path")
+	path := fs.String("path", "", "Path to check (required)")
+	action := fs.String("action", "read", "Action: read, write, browse, ...")
+	uid := fs.Int("uid", -1, "Caller UID (defaults to the current user, or the process's with -pid)")
+	userName := fs.String("user", "", "Caller user name, instead of -uid")
+	gid := fs.Int("gid", -1, "Caller GID (defaults to the user's primary group)")
+	groups := fs.String("groups", "", "Comma-separated supplementary GIDs")
+	binary := fs.String("binary", "", "Executable path of the caller")
+	pid := fs.Int("pid", 0, "Take binary, uid, gid and groups from a running process")
+	at := fs.String("at", "", "Evaluate at this RFC 3339 time instead of now")
+	ldapCache := fs.String("ldap-cache", "", "Take LDAP user set members from this agent cache file")
+	jsonOut := fs.Bool("json", false, "Print the explanation as JSON")
+	verbose := fs.Bool("v", false, "Show engine debug logging")
+	fs.Parse(args)
+
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "explain: -path is required")
+		fs.Usage()
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	req := &policy.AccessRequest{
+		Path:   *path,
+		Action: *action,
+		UID:    *uid,
+		GID:    *gid,
+		Binary: *binary,
+	}
+
+	if *pid > 0 {
+		if err := introspect(*pid, req); err != nil {
+			fmt.Fprintf(os.Stderr, "explain: %v\n", err)
+			return 1
+		}
+	}
+
+	if *userName != "" {
+		u, err := user.Lookup(*userName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "explain: %v\n", err)
+			return 1
+		}
+		req.UID, _ = strconv.Atoi(u.Uid)
+		if req.GID < 0 {
+			req.GID, _ = strconv.Atoi(u.Gid)
+		}
+	}
+	if req.UID < 0 {
+		req.UID = os.Getuid()
+	}
+	if req.GID < 0 {
+		req.GID = primaryGID(req.UID)
+	}
+
+	if *groups != "" {
+		req.Groups = nil
+		for _, field := range strings.Split(*groups, ",") {
+			g, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "explain: invalid group %q\n", field)
+				return 2
+			}
+			req.Groups = append(req.Groups, g)
+		}
+	}
+
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "explain: invalid -at: %v\n", err)
+			return 2
+		}
+		req.Time = t
+	}
+
+	cfg, err := config.Load(*configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "explain: failed to load configuration: %v\n", err)
+		return 1
+	}
+	if *ldapCache != "" {
+		cfg = directory.New(*ldapCache).Expand(cfg)
+	}
+
+	exp, err := policy.NewEngine(cfg).Explain(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "explain: %v\n", err)
+		return 1
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(exp)
+		return 0
+	}
+	printExplanation(exp)
+	return 0
+}
+
+// introspect fills the request from /proc: the executable and the
+// filesystem uid and gid the kernel checks access with.
+func introspect(pid int, req *policy.AccessRequest) error {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return fmt.Errorf("failed to read executable of pid %d: %w", pid, err)
+	}
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return fmt.Errorf("failed to read status of pid %d: %w", pid, err)
+	}
+	defer f.Close()
+
+	req.ProcessID = pid
+	if req.Binary == "" {
+		req.Binary = exe
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		// Uid: real effective saved filesystem
+		switch fields[0] {
+		case "Uid:":
+			if req.UID < 0 {
+				req.UID, _ = strconv.Atoi(fields[4])
+			}
+		case "Gid:":
+			if req.GID < 0 {
+				req.GID, _ = strconv.Atoi(fields[4])
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func primaryGID(uid int) int {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return uid
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return uid
+	}
+	return gid
+}
+
+func printExplanation(exp *policy.Explanation) {
+	fmt.Printf("Request:     %s %s\n", exp.Action, exp.Path)
+	fmt.Printf("Caller:      uid=%d gid=%d", exp.UID, exp.GID)
+	if len(exp.Groups) > 0 {
+		fmt.Printf(" groups=%v", exp.Groups)
+	}
+	if exp.ProcessID > 0 {
+		fmt.Printf(" pid=%d", exp.ProcessID)
+	}
+	fmt.Println()
+	if exp.Binary != "" {
+		fmt.Printf("Binary:      %s\n", exp.Binary)
+	}
+	if exp.Container != "" {
+		fmt.Printf("Container:   %s\n", exp.Container)
+	}
+	fmt.Printf("Time:        %s\n", exp.Time.Format(time.RFC3339))
+	if f := exp.File; f != nil {
+		if f.Exists {
+			fmt.Printf("File:        size=%d owner=%d", f.Size, f.OwnerUID)
+			for _, name := range sortedKeys(f.Xattrs) {
+				fmt.Printf(" %s=%q", name, f.Xattrs[name])
+			}
+			fmt.Println()
+		} else {
+			fmt.Printf("File:        not stored yet\n")
+		}
+	}
+
+	if exp.GuardPoint == "" {
+		fmt.Printf("Guard point: none (not protected)\n")
+	} else {
+		fmt.Printf("Guard point: %s (%s)\n", exp.GuardPoint, exp.ProtectedDir)
+		if len(exp.Policies) > 1 {
+			fmt.Printf("Policies:    %s (%s)\n", strings.Join(exp.Policies, ", "), exp.Algorithm)
+		} else {
+			fmt.Printf("Policy:      %s\n", strings.Join(exp.Policies, ""))
+		}
+		fmt.Printf("Relative:    %s\n", exp.RelativePath)
+	}
+
+	if len(exp.Rules) > 0 {
+		fmt.Printf("\nRules:\n")
+		policy := ""
+		for _, rule := range exp.Rules {
+			if len(exp.Policies) > 1 && rule.Policy != policy {
+				policy = rule.Policy
+				fmt.Printf("  policy %s:\n", policy)
+			}
+			if rule.Matched {
+				fmt.Printf("  %3d %-24s MATCH\n", rule.Order, rule.ID)
+				continue
+			}
+			fmt.Printf("  %3d %-24s no match: %s (%s)\n", rule.Order, rule.ID, rule.FailedCondition, rule.Detail)
+		}
+	}
+
+	d := exp.Decision
+	fmt.Printf("\nDecision:    %s", strings.ToUpper(d.Permission))
+	if d.RuleID != "" && len(exp.Policies) > 1 && d.Policy != "" {
+		fmt.Printf(" (rule %s of policy %s)", d.RuleID, d.Policy)
+	} else if d.RuleID != "" {
+		fmt.Printf(" (rule %s)", d.RuleID)
+	}
+	if d.Learned {
+		fmt.Printf(" - learn mode, rule %s would DENY", d.LearnedRule)
+	}
+	fmt.Println()
+	fmt.Printf("Reason:      %s\n", d.Reason)
+	fmt.Printf("Apply key:   %t\n", d.ApplyKey)
+	if d.View != "" {
+		fmt.Printf("View:        %s\n", d.View)
+	}
+	fmt.Printf("Audit:       %t\n", d.Audit)
+	if d.RawAccess {
+		fmt.Printf("Raw access:  exempted by resource set %s\n", d.Exemption)
+	}
+}
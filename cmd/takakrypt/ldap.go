@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/directory"
+)
+
+// ldapMembers implements "takakrypt ldap members": it reads the members of
+// the LDAP user sets from the directory, as the agent would on refresh.
+func ldapMembers(args []string) int {
+	fs := flag.NewFlagSet("ldap members", flag.ExitOnError)
+	configDir := fs.String("config", "./", "Configuration directory path")
+	userSet := fs.String("user-set", "", "Only read this user set")
+	jsonOut := fs.Bool("json", false, "Print members as JSON")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ldap: failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	members := make(map[string][]config.User)
+	failed, found := false, false
+	for _, us := range cfg.UserSets {
+		if us.LDAP == nil || (*userSet != "" && us.Code != *userSet) {
+			continue
+		}
+		found = true
+		users, err := directory.Fetch(us.LDAP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ldap: user set %s: %v\n", us.Code, err)
+			failed = true
+			continue
+		}
+		members[us.Code] = users
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "ldap: no matching LDAP user sets\n")
+		return 1
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(members)
+	} else {
+		for _, code := range sortedKeys(members) {
+			fmt.Printf("%s: %d members\n", code, len(members[code]))
+			for _, u := range members[code] {
+				fmt.Printf("  %-10d %-20s %s\n", u.UID, u.UName, u.ID)
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
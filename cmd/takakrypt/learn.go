@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/takakrypt/transparent-encryption/internal/audit"
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// suggestion is a configuration fragment in the same shape as the config
+// files, to be reviewed and merged by hand.
+type suggestion struct {
+	UserSets    []config.UserSet    `json:"user_sets"`
+	ProcessSets []config.ProcessSet `json:"process_sets"`
+	Policies    []config.Policy     `json:"policies"`
+}
+
+// observed collects what one binary did under one policy.
+type observed struct {
+	uids    map[int]bool
+	actions map[string]bool
+}
+
+// learn implements "takakrypt policy learn": it reads learn mode events
+// from the audit log and suggests rules that would have permitted them.
+func learn(args []string) int {
+	fs := flag.NewFlagSet("policy learn", flag.ExitOnError)
+	configDir := fs.String("config", "./", "Configuration directory path")
+	auditLog := fs.String("audit-log", "/var/log/takakrypt-audit.log", "Audit log to read learn mode events from (- for stdin)")
+	fs.Parse(args)
+
+	log.SetOutput(io.Discard)
+
+	cfg, err := config.Load(*configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "learn: failed to load configuration: %v\n", err)
+		return 1
+	}
+	engine := policy.NewEngine(cfg)
+
+	in := os.Stdin
+	if *auditLog != "-" {
+		f, err := os.Open(*auditLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "learn: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	seen, events, err := collectLearned(engine, in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "learn: failed to read audit log: %v\n", err)
+		return 1
+	}
+
+	out := suggest(seen)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+
+	fmt.Fprintf(os.Stderr, "learn: %d learn mode events, %d rules suggested\n", events, countRules(out))
+	if events > 0 {
+		fmt.Fprintf(os.Stderr, "learn: suggested rules are numbered from 1; renumber them to precede the rules that denied the access\n")
+	}
+	return 0
+}
+
+// collectLearned reads the learn mode events of an audit log and groups
+// them by policy code and binary. Events outside the engine's guard points
+// are skipped.
+func collectLearned(engine *policy.Engine, in io.Reader) (map[string]map[string]*observed, int, error) {
+	// policy code -> binary -> what it did
+	seen := make(map[string]map[string]*observed)
+	events := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry audit.AuditLog
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || !entry.Learned {
+			continue
+		}
+		gp := engine.FindGuardPoint(entry.Path)
+		if gp == nil {
+			continue
+		}
+		events++
+
+		// Events name the policy in learn mode; older ones do not
+		policyCode := entry.Policy
+		if policyCode == "" {
+			policyCode = gp.PolicyCodes()[0]
+		}
+		byBinary := seen[policyCode]
+		if byBinary == nil {
+			byBinary = make(map[string]*observed)
+			seen[policyCode] = byBinary
+		}
+		obs := byBinary[entry.Process]
+		if obs == nil {
+			obs = &observed{uids: make(map[int]bool), actions: make(map[string]bool)}
+			byBinary[entry.Process] = obs
+		}
+		obs.uids[entry.User] = true
+		obs.actions[entry.Action] = true
+	}
+	return seen, events, scanner.Err()
+}
+
+func suggest(seen map[string]map[string]*observed) *suggestion {
+	out := &suggestion{
+		UserSets:    []config.UserSet{},
+		ProcessSets: []config.ProcessSet{},
+		Policies:    []config.Policy{},
+	}
+
+	used := make(map[string]bool)
+	for _, policyCode := range sortedKeys(seen) {
+		byBinary := seen[policyCode]
+		pol := config.Policy{Code: policyCode}
+
+		for _, binary := range sortedKeys(byBinary) {
+			obs := byBinary[binary]
+			name := "learned-" + policyCode
+			if binary != "" {
+				name += "-" + filepath.Base(binary)
+			}
+			// Binaries with the same name in different directories
+			for base, n := name, 2; used[name]; n++ {
+				name = fmt.Sprintf("%s-%d", base, n)
+			}
+			used[name] = true
+
+			rule := config.SecurityRule{
+				ID:          name,
+				Order:       len(pol.SecurityRules) + 1,
+				ResourceSet: []string{},
+				Action:      []string{},
+				Effect: config.RuleEffect{
+					Permission: "permit",
+					Option:     config.EffectOption{ApplyKey: true},
+				},
+			}
+			for _, action := range sortedKeys(obs.actions) {
+				if action == "browse" {
+					rule.Browsing = true
+					continue
+				}
+				rule.Action = append(rule.Action, action)
+			}
+
+			users := config.UserSet{Code: name + "-users", Name: "Learned users for " + name}
+			uids := make([]int, 0, len(obs.uids))
+			for uid := range obs.uids {
+				uids = append(uids, uid)
+			}
+			sort.Ints(uids)
+			for n, uid := range uids {
+				entry := config.User{Index: n + 1, UID: uid, MatchBy: config.MatchByUID}
+				if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+					entry.UName = u.Username
+				}
+				users.Users = append(users.Users, entry)
+			}
+			out.UserSets = append(out.UserSets, users)
+			rule.UserSet = []string{users.Code}
+
+			if binary != "" {
+				out.ProcessSets = append(out.ProcessSets, config.ProcessSet{
+					Code: name,
+					Name: "Learned process " + binary,
+					ResourceSetList: []config.ProcessSetResource{{
+						Index:     1,
+						Directory: filepath.Dir(binary) + "/",
+						File:      filepath.Base(binary),
+						Signature: []string{},
+					}},
+				})
+				rule.ProcessSet = []string{name}
+			} else {
+				rule.ProcessSet = []string{}
+			}
+
+			pol.SecurityRules = append(pol.SecurityRules, rule)
+		}
+		out.Policies = append(out.Policies, pol)
+	}
+	return out
+}
+
+func countRules(s *suggestion) int {
+	n := 0
+	for _, p := range s.Policies {
+		n += len(p.SecurityRules)
+	}
+	return n
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
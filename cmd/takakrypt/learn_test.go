@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/audit"
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// TestLearnFromAuditLog runs learn mode events through the interceptor and
+// the audit logger and checks the rules suggested from the log.
+func TestLearnFromAuditLog(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	protected, storage := filepath.Join(dir, "data"), filepath.Join(dir, "storage")
+	// Empty backing files read as empty without a key
+	if err := os.MkdirAll(storage, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(storage, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	engine := policy.NewEngine(&config.Config{
+		GuardPoints: []config.GuardPoint{{
+			Code:              "gp",
+			ProtectedPath:     protected,
+			SecureStoragePath: storage,
+			Policies:          []string{"baseline", "new"},
+			Enabled:           true,
+		}},
+		Policies: []config.Policy{
+			{Code: "baseline", SecurityRules: []config.SecurityRule{{
+				ID: "admins", Order: 1, Action: []string{"all_ops"}, Browsing: true,
+				UserSet: []string{"admins"}, Effect: config.RuleEffect{Permission: "permit"},
+			}}},
+			{Code: "new", Mode: config.PolicyModeLearn},
+		},
+		UserSets: []config.UserSet{{Code: "admins", Users: []config.User{{UID: 0}}}},
+	})
+
+	logPath := filepath.Join(dir, "audit.log")
+	logger, err := audit.NewLogger(logPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := filesystem.NewInterceptor(engine, nil)
+	interceptor.SetAuditHandler(logger.LogEvent)
+
+	ops := []*filesystem.FileOperation{
+		{Type: "open", Path: filepath.Join(protected, "a"), UID: 1000, GID: 1000, Binary: "/usr/bin/cat"},
+		{Type: "open", Path: filepath.Join(protected, "b"), UID: 1001, GID: 1001, Binary: "/usr/bin/cat"},
+		{Type: "list", Path: protected, UID: 1000, GID: 1000, Binary: "/usr/bin/ls"},
+		{Type: "write", Path: filepath.Join(protected, "a"), UID: 1000, GID: 1000, Binary: "/usr/bin/cat"},
+		// Permitted by the enforced policy; not learned
+		{Type: "open", Path: filepath.Join(protected, "a"), UID: 0, GID: 0, Binary: "/usr/bin/vi"},
+	}
+	for _, op := range ops {
+		var result *filesystem.OperationResult
+		switch op.Type {
+		case "open":
+			result, err = interceptor.InterceptOpen(context.Background(), op)
+		case "list":
+			result, err = interceptor.InterceptList(context.Background(), op)
+		case "write":
+			result, err = interceptor.AuthorizeWrite(op)
+		}
+		if err != nil || !result.Allowed {
+			t.Fatalf("%s %s by %d: allowed=%v, err=%v", op.Type, op.Path, op.UID, result.Allowed, err)
+		}
+	}
+	logger.Close()
+
+	// Lines that are not learn mode events are skipped
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.WriteString(`{"path":"/elsewhere/x","user":5,"process":"/usr/bin/cat","action":"read","learned":true}` + "\n")
+	f.Close()
+
+	f, err = os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	seen, events, err := collectLearned(engine, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 4 {
+		t.Errorf("got %d learn mode events, want 4", events)
+	}
+	if _, ok := seen["baseline"]; ok || len(seen) != 1 {
+		t.Fatalf("events attributed to %v, want only policy new", sortedKeys(seen))
+	}
+
+	out := suggest(seen)
+	if len(out.Policies) != 1 || out.Policies[0].Code != "new" {
+		t.Fatalf("suggested policies = %+v, want new", out.Policies)
+	}
+	rules := out.Policies[0].SecurityRules
+	if len(rules) != 2 {
+		t.Fatalf("got %d suggested rules, want 2: %+v", len(rules), rules)
+	}
+	cat, ls := rules[0], rules[1]
+	if cat.ID != "learned-new-cat" || !reflect.DeepEqual(cat.Action, []string{"read", "write"}) || cat.Browsing {
+		t.Errorf("cat rule = %+v, want read and write without browsing", cat)
+	}
+	if ls.ID != "learned-new-ls" || len(ls.Action) != 0 || !ls.Browsing {
+		t.Errorf("ls rule = %+v, want browsing only", ls)
+	}
+	for _, rule := range rules {
+		if rule.Effect.Permission != "permit" || !rule.Effect.Option.ApplyKey {
+			t.Errorf("rule %s effect = %+v, want permit with the key applied", rule.ID, rule.Effect)
+		}
+	}
+
+	var catUIDs []int
+	for _, us := range out.UserSets {
+		if us.Code == "learned-new-cat-users" {
+			for _, u := range us.Users {
+				catUIDs = append(catUIDs, u.UID)
+			}
+		}
+	}
+	if !reflect.DeepEqual(catUIDs, []int{1000, 1001}) {
+		t.Errorf("cat users = %v, want [1000 1001]", catUIDs)
+	}
+	if len(out.ProcessSets) != 2 || out.ProcessSets[0].ResourceSetList[0].Directory != "/usr/bin/" || out.ProcessSets[0].ResourceSetList[0].File != "cat" {
+		t.Errorf("process sets = %+v, want /usr/bin/cat and /usr/bin/ls", out.ProcessSets)
+	}
+}
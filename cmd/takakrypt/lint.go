@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// lint implements "takakrypt policy lint". It exits 1 if there are errors,
+// or warnings with -strict.
+func lint(args []string) int {
+	fs := flag.NewFlagSet("policy lint", flag.ExitOnError)
+	configDir := fs.String("config", "./", "Configuration directory path")
+	jsonOut := fs.Bool("json", false, "Print findings as JSON")
+	strict := fs.Bool("strict", false, "Treat warnings as errors")
+	fs.Parse(args)
+
+	cfg, err := config.Read(*configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lint: failed to read configuration: %v\n", err)
+		return 1
+	}
+
+	findings := config.Lint(cfg)
+	errors, warnings := 0, 0
+	for _, f := range findings {
+		if f.Severity == config.SeverityError {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+
+	if *jsonOut {
+		if findings == nil {
+			findings = []config.Finding{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(findings)
+	} else {
+		for _, f := range findings {
+			fmt.Printf("%-7s  %s\n", f.Severity, f.Error())
+		}
+		fmt.Printf("%d errors, %d warnings\n", errors, warnings)
+	}
+
+	if errors > 0 || (*strict && warnings > 0) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: takakrypt <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  policy explain   Show how a request would be decided\n")
+	fmt.Fprintf(os.Stderr, "  policy learn     Suggest rules from learn mode audit events\n")
+	fmt.Fprintf(os.Stderr, "  policy lint      Check the configuration for errors and likely mistakes\n")
+	fmt.Fprintf(os.Stderr, "  policy test      Run policy test cases, optionally writing JUnit XML\n")
+	fmt.Fprintf(os.Stderr, "  ldap members     Read the members of LDAP user sets from the directory\n")
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] + " " + os.Args[2] {
+	case "policy explain":
+		os.Exit(explain(os.Args[3:]))
+	case "policy learn":
+		os.Exit(learn(os.Args[3:]))
+	case "policy lint":
+		os.Exit(lint(os.Args[3:]))
+	case "policy test":
+		os.Exit(policyTest(os.Args[3:]))
+	case "ldap members":
+		os.Exit(ldapMembers(os.Args[3:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
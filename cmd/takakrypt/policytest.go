@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/container"
+	"github.com/takakrypt/transparent-encryption/internal/directory"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// testSuite is a file of policy test cases.
+type testSuite struct {
+	Name  string     `json:"name"`
+	Tests []testCase `json:"tests"`
+}
+
+type testCase struct {
+	Name    string       `json:"name"`
+	Request testRequest  `json:"request"`
+	Expect  testExpected `json:"expect"`
+}
+
+type testRequest struct {
+	Path   string    `json:"path"`
+	Action string    `json:"action"`
+	UID    int       `json:"uid"`
+	GID    int       `json:"gid"`
+	Groups []int     `json:"groups,omitempty"`
+	Binary string    `json:"binary,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+	// Container, if set, places the caller in a container
+	Container *testContainer `json:"container,omitempty"`
+	// File, if set, stands in for the stored file's metadata
+	File *testFile `json:"file,omitempty"`
+}
+
+type testFile struct {
+	Size     int64             `json:"size"`
+	OwnerUID int               `json:"owner_uid"`
+	Xattrs   map[string]string `json:"xattrs,omitempty"`
+}
+
+type testContainer struct {
+	ID     string `json:"id"`
+	Image  string `json:"image,omitempty"`
+	Cgroup string `json:"cgroup,omitempty"`
+	// IDs inside the container's user namespace; default to the request's
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+}
+
+// testExpected lists the outcome to check. Permission is required; the
+// other fields are only checked if set.
+type testExpected struct {
+	Permission string `json:"permission"`
+	ApplyKey   *bool  `json:"apply_key,omitempty"`
+	RuleID     string `json:"rule_id,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	View       string `json:"view,omitempty"`
+}
+
+// JUnit XML report, in the subset CI systems read.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// policyTest implements "takakrypt policy test": it evaluates the cases in
+// each file against the configuration with the policy engine alone.
+func policyTest(args []string) int {
+	fs := flag.NewFlagSet("policy test", flag.ExitOnError)
+	configDir := fs.String("config", "./", "Configuration directory path")
+	junit := fs.String("junit", "", "Write a JUnit XML report to this file")
+	verbose := fs.Bool("v", false, "List passing cases too")
+	ldapCache := fs.String("ldap-cache", "", "Take LDAP user set members from this agent cache file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: takakrypt policy test [flags] cases.json...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	log.SetOutput(io.Discard)
+
+	cfg, err := config.Load(*configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test: failed to load configuration: %v\n", err)
+		return 1
+	}
+	if *ldapCache != "" {
+		cfg = directory.New(*ldapCache).Expand(cfg)
+	}
+	engine := policy.NewEngine(cfg)
+
+	report := junitSuites{}
+	for _, file := range fs.Args() {
+		suite := runSuite(engine, file, *verbose)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Suites = append(report.Suites, suite)
+	}
+
+	fmt.Printf("%d tests, %d failures, %d errors\n", report.Tests, report.Failures, report.Errors)
+
+	if *junit != "" {
+		data, err := xml.MarshalIndent(report, "", "  ")
+		if err == nil {
+			data = append([]byte(xml.Header), append(data, '\n')...)
+			err = os.WriteFile(*junit, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "test: failed to write JUnit report: %v\n", err)
+			return 1
+		}
+	}
+
+	if report.Failures > 0 || report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runSuite(engine *policy.Engine, file string, verbose bool) junitSuite {
+	suite := junitSuite{Name: file}
+	start := time.Now()
+
+	var ts testSuite
+	data, err := os.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(data, &ts)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s: %v\n", file, err)
+		suite.Tests, suite.Errors = 1, 1
+		suite.Cases = []junitCase{{
+			Name:      file,
+			ClassName: file,
+			Time:      "0",
+			Error:     &junitMessage{Message: "failed to read test cases", Body: err.Error()},
+		}}
+		suite.Time = "0"
+		return suite
+	}
+	if ts.Name != "" {
+		suite.Name = ts.Name
+	}
+
+	for i, tc := range ts.Tests {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i+1)
+		}
+		caseStart := time.Now()
+		jc := junitCase{Name: name, ClassName: suite.Name}
+
+		exp, err := engine.Explain(tc.Request.accessRequest())
+		switch {
+		case tc.Expect.Permission == "":
+			suite.Errors++
+			jc.Error = &junitMessage{Message: "expect.permission is required"}
+			fmt.Printf("ERROR %s: %s: expect.permission is required\n", suite.Name, name)
+		case err != nil:
+			suite.Errors++
+			jc.Error = &junitMessage{Message: "policy evaluation failed", Body: err.Error()}
+			fmt.Printf("ERROR %s: %s: %v\n", suite.Name, name, err)
+		default:
+			if problems := tc.Expect.check(exp.Decision); len(problems) > 0 {
+				suite.Failures++
+				msg := strings.Join(problems, "; ")
+				jc.Failure = &junitMessage{Message: msg, Body: traceText(exp)}
+				fmt.Printf("FAIL  %s: %s: %s\n", suite.Name, name, msg)
+			} else if verbose {
+				fmt.Printf("ok    %s: %s\n", suite.Name, name)
+			}
+		}
+
+		jc.Time = seconds(time.Since(caseStart))
+		suite.Cases = append(suite.Cases, jc)
+		suite.Tests++
+	}
+	suite.Time = seconds(time.Since(start))
+	return suite
+}
+
+func (r *testRequest) accessRequest() *policy.AccessRequest {
+	req := &policy.AccessRequest{
+		Path:   r.Path,
+		Action: r.Action,
+		UID:    r.UID,
+		GID:    r.GID,
+		Groups: r.Groups,
+		Binary: r.Binary,
+		Time:   r.Time,
+	}
+	if req.Action == "" {
+		req.Action = "read"
+	}
+	if c := r.Container; c != nil {
+		id := &container.Identity{
+			ID:     c.ID,
+			Image:  c.Image,
+			Cgroup: c.Cgroup,
+			UID:    r.UID,
+			GID:    r.GID,
+			Groups: req.Groups,
+		}
+		if c.UID != nil {
+			id.UID = *c.UID
+		}
+		if c.GID != nil {
+			id.GID = *c.GID
+		}
+		req.Container = id
+	}
+	if f := r.File; f != nil {
+		req.File = &policy.FileAttributes{Exists: true, Size: f.Size, OwnerUID: f.OwnerUID, Xattrs: f.Xattrs}
+	}
+	return req
+}
+
+func (e *testExpected) check(d policy.Decision) []string {
+	var problems []string
+	if e.Permission != d.Permission {
+		problems = append(problems, fmt.Sprintf("permission is %s, want %s", d.Permission, e.Permission))
+	}
+	if e.ApplyKey != nil && *e.ApplyKey != d.ApplyKey {
+		problems = append(problems, fmt.Sprintf("apply_key is %t, want %t", d.ApplyKey, *e.ApplyKey))
+	}
+	if e.RuleID != "" && e.RuleID != d.RuleID {
+		problems = append(problems, fmt.Sprintf("rule is %s, want %s", d.RuleID, e.RuleID))
+	}
+	if e.View != "" && e.View != d.View {
+		problems = append(problems, fmt.Sprintf("view is %s, want %s", d.View, e.View))
+	}
+	if e.Policy != "" && e.Policy != d.Policy {
+		problems = append(problems, fmt.Sprintf("policy is %s, want %s", d.Policy, e.Policy))
+	}
+	if e.Reason != "" && e.Reason != d.Reason {
+		problems = append(problems, fmt.Sprintf("reason is %s, want %s", d.Reason, e.Reason))
+	}
+	return problems
+}
+
+// traceText summarises how a failing case was decided.
+func traceText(exp *policy.Explanation) string {
+	var b strings.Builder
+	if exp.GuardPoint == "" {
+		fmt.Fprintf(&b, "%s is not under any guard point\n", exp.Path)
+	} else {
+		fmt.Fprintf(&b, "guard point %s, policies %s (%s)\n", exp.GuardPoint, strings.Join(exp.Policies, ", "), exp.Algorithm)
+	}
+	for _, rule := range exp.Rules {
+		if rule.Matched {
+			fmt.Fprintf(&b, "rule %d %s/%s: matched\n", rule.Order, rule.Policy, rule.ID)
+		} else {
+			fmt.Fprintf(&b, "rule %d %s/%s: %s (%s)\n", rule.Order, rule.Policy, rule.ID, rule.FailedCondition, rule.Detail)
+		}
+	}
+	fmt.Fprintf(&b, "decision: %s by %s (%s), apply_key %t\n", exp.Decision.Permission, exp.Decision.RuleID, exp.Decision.Reason, exp.Decision.ApplyKey)
+	return b.String()
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
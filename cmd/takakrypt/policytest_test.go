@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// TestDeployedSuites runs the policy test suites shipped under deploy.
+func TestDeployedSuites(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dirs, err := filepath.Glob("../../deploy/policy-tests/*")
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("no policy test suites found: %v", err)
+	}
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			cfg, err := config.Load(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			suite := runSuite(policy.NewEngine(cfg), filepath.Join(dir, "tests.json"), false)
+			if suite.Tests == 0 || suite.Failures > 0 || suite.Errors > 0 {
+				t.Errorf("%d tests, %d failures, %d errors", suite.Tests, suite.Failures, suite.Errors)
+			}
+		})
+	}
+}
+
+// TestDeployedSuitesExplain checks that the decision policy-test and explain
+// report for every deployed case is the one the agent enforces.
+func TestDeployedSuitesExplain(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dirs, err := filepath.Glob("../../deploy/policy-tests/*")
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("no policy test suites found: %v", err)
+	}
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			cfg, err := config.Load(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "tests.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ts testSuite
+			if err := json.Unmarshal(data, &ts); err != nil {
+				t.Fatal(err)
+			}
+			engine := policy.NewEngine(cfg)
+			for i, tc := range ts.Tests {
+				exp, err := engine.Explain(tc.Request.accessRequest())
+				if err != nil {
+					t.Fatalf("case %d: %v", i+1, err)
+				}
+				result, err := engine.EvaluateAccess(tc.Request.accessRequest())
+				if err != nil {
+					t.Fatalf("case %d: %v", i+1, err)
+				}
+				d := exp.Decision
+				if d.Permission != result.Permission || d.RuleID != result.RuleID || d.Policy != result.Policy || d.Reason != result.Reason ||
+					d.ApplyKey != result.ApplyKey || d.View != result.View || d.Exemption != result.Exemption || d.Learned != result.Learned {
+					t.Errorf("case %d %q: explain reports %+v, EvaluateAccess decided %+v", i+1, tc.Name, d, *result)
+				}
+			}
+		})
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/takakrypt/transparent-encryption/internal/audit"
 	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/container"
 	"github.com/takakrypt/transparent-encryption/internal/crypto"
+	"github.com/takakrypt/transparent-encryption/internal/directory"
 	"github.com/takakrypt/transparent-encryption/internal/filesystem"
 	"github.com/takakrypt/transparent-encryption/internal/fuse"
 	"github.com/takakrypt/transparent-encryption/internal/policy"
@@ -22,6 +26,10 @@ type Agent struct {
 	interceptor   *filesystem.Interceptor
 	mountManager  *fuse.MountManager
 	auditLogger   *audit.Logger
+	directory     *directory.Directory
+
+	ctx      context.Context
+	reloadMu sync.Mutex
 }
 
 func New(cfg *config.Config, configDir string) (*Agent, error) {
@@ -41,14 +49,15 @@ func New(cfg *config.Config, configDir string) (*Agent, error) {
 	}
 	cryptoSvc := crypto.NewService(keyProvider)
 
-	interceptor := filesystem.NewInterceptor(policyEngine, cryptoSvc, cfg)
-	mountManager := fuse.NewMountManager(interceptor)
+	interceptor := filesystem.NewInterceptor(policyEngine, cryptoSvc)
+	mountManager := fuse.NewMountManager(interceptor, cfg)
 
 	auditLogger, err := audit.NewLogger("/var/log/takakrypt-audit.log", true)
 	if err != nil {
 		log.Printf("Warning: Failed to initialize audit logger: %v", err)
 		auditLogger, _ = audit.NewLogger("", false)
 	}
+	interceptor.SetAuditHandler(auditLogger.LogEvent)
 
 	return &Agent{
 		config:        cfg,
@@ -58,10 +67,44 @@ func New(cfg *config.Config, configDir string) (*Agent, error) {
 		interceptor:   interceptor,
 		mountManager:  mountManager,
 		auditLogger:   auditLogger,
+		directory:     directory.New(""),
 	}, nil
 }
 
+// ConfigureDecisions sets up decision caching: an LRU of cacheSize entries
+// (0 disables it) and, if pin is set, decisions pinned to open file
+// handles. It must be called before Start.
+func (a *Agent) ConfigureDecisions(cacheSize int, pin bool) {
+	if cacheSize > 0 {
+		a.policyEngine.SetDecisionCache(policy.NewDecisionCache(cacheSize))
+		log.Printf("[AGENT] Decision cache enabled with %d entries", cacheSize)
+	}
+	a.interceptor.SetPinDecisions(pin)
+}
+
+// SetContainerRuntime makes container image conditions resolvable by
+// querying the Docker Engine API (or a compatible one) on socketPath. It
+// must be called before Start.
+func (a *Agent) SetContainerRuntime(socketPath string) {
+	a.policyEngine.SetContainerResolver(container.NewResolver(socketPath))
+	log.Printf("[AGENT] Resolving container images through %s", socketPath)
+}
+
+// SetLDAPCache keeps the members of LDAP user sets in the file at
+// cachePath, so that they are available when the directory is not. It
+// must be called before Start.
+func (a *Agent) SetLDAPCache(cachePath string) {
+	a.directory = directory.New(cachePath)
+	log.Printf("[AGENT] Caching LDAP user set members in %s", cachePath)
+}
+
 func (a *Agent) Start(ctx context.Context) error {
+	a.reloadMu.Lock()
+	a.ctx = ctx
+	a.applyUserSets()
+	a.directory.Run(ctx, a.config, a.refreshUserSets)
+	a.reloadMu.Unlock()
+
 	log.Printf("Starting Takakrypt Transparent Encryption Agent")
 	log.Printf("Loaded %d guard points", len(a.config.GuardPoints))
 	log.Printf("Loaded %d policies", len(a.config.Policies))
@@ -72,8 +115,8 @@ func (a *Agent) Start(ctx context.Context) error {
 
 	for _, gp := range a.config.GuardPoints {
 		if gp.Enabled {
-			log.Printf("Guard Point: %s -> %s (Policy: %s)", 
-				gp.ProtectedPath, gp.SecureStoragePath, gp.Policy)
+			log.Printf("Guard Point: %s -> %s (Policies: %s)", 
+				gp.ProtectedPath, gp.SecureStoragePath, strings.Join(gp.PolicyCodes(), ", "))
 		}
 	}
 
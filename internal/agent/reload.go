@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+)
+
+// Reload re-reads the configuration directory and applies it without
+// restarting: the new configuration is validated first, then the policy
+// engine is swapped atomically and guard point mounts are reconciled. If
+// loading or validation fails the running configuration stays in place.
+func (a *Agent) Reload() error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	if a.ctx == nil {
+		return fmt.Errorf("agent not started")
+	}
+
+	log.Printf("[AGENT] Reloading configuration from %s", a.configDir)
+	cfg, err := config.Load(a.configDir)
+	if err != nil {
+		a.auditReload(false, fmt.Sprintf("configuration rejected, keeping current: %v", err))
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	effective := a.directory.Expand(cfg)
+	a.policyEngine.Update(effective)
+	a.config = cfg
+	a.directory.Run(a.ctx, cfg, a.refreshUserSets)
+
+	if err := a.mountManager.Reconcile(a.ctx, effective); err != nil {
+		a.auditReload(false, fmt.Sprintf("policies applied, mounts partially reconciled: %v", err))
+		return err
+	}
+
+	a.auditReload(true, fmt.Sprintf("configuration reloaded: %d guard points, %d policies", len(cfg.GuardPoints), len(cfg.Policies)))
+	return nil
+}
+
+// applyUserSets applies the configuration with the current members of its
+// LDAP user sets. a.reloadMu must be held.
+func (a *Agent) applyUserSets() {
+	effective := a.directory.Expand(a.config)
+	a.policyEngine.Update(effective)
+	a.mountManager.SetConfig(effective)
+}
+
+// refreshUserSets is called when the members of an LDAP user set change.
+func (a *Agent) refreshUserSets() {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	a.applyUserSets()
+}
+
+// WatchConfig polls the configuration files every interval and reloads
+// when any of them changes, until ctx is cancelled.
+func (a *Agent) WatchConfig(ctx context.Context, interval time.Duration) {
+	last := a.configFingerprint()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := a.configFingerprint()
+		if current == last {
+			continue
+		}
+		last = current
+
+		log.Printf("[AGENT] Configuration files changed, reloading")
+		if err := a.Reload(); err != nil {
+			log.Printf("[AGENT] Reload failed: %v", err)
+		}
+	}
+}
+
+// configFingerprint summarises the size and modification time of every
+// configuration file.
+func (a *Agent) configFingerprint() string {
+	fingerprint := ""
+	for _, name := range config.Files {
+		info, err := os.Stat(filepath.Join(a.configDir, name))
+		if err != nil {
+			fingerprint += name + ":missing;"
+			continue
+		}
+		fingerprint += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return fingerprint
+}
+
+func (a *Agent) auditReload(success bool, message string) {
+	log.Printf("[AGENT] %s", message)
+	a.interceptor.Audit(&filesystem.AuditEvent{
+		Operation: "config_reload",
+		Path:      a.configDir,
+		User:      os.Getuid(),
+		Process:   "takakrypt-agent",
+		RuleID:    "config-reload",
+		Success:   success,
+	}, message)
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/directory"
+	"github.com/takakrypt/transparent-encryption/internal/fuse"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// Status is the document served at /status on the status socket.
+type Status struct {
+	Time   time.Time          `json:"time"`
+	Mounts []fuse.MountStatus `json:"mounts"`
+	// DecisionCache is nil when the decision cache is disabled
+	DecisionCache *policy.CacheStats `json:"decision_cache,omitempty"`
+	// LDAPUserSets lists the members known for each LDAP user set
+	LDAPUserSets []directory.Status `json:"ldap_user_sets,omitempty"`
+}
+
+func (a *Agent) Status() *Status {
+	status := &Status{
+		Time:   time.Now(),
+		Mounts: a.mountManager.Status(),
+	}
+	if stats, ok := a.policyEngine.CacheStats(); ok {
+		status.DecisionCache = &stats
+	}
+	a.reloadMu.Lock()
+	status.LDAPUserSets = a.directory.Status(a.config)
+	a.reloadMu.Unlock()
+	return status
+}
+
+// ServeStatus serves the agent status as JSON over HTTP on a Unix socket
+// until ctx is cancelled, e.g.
+//
+//	curl --unix-socket /run/takakrypt/agent.sock http://agent/status
+func (a *Agent) ServeStatus(ctx context.Context, socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale status socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on status socket: %w", err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict status socket permissions: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.Status()); err != nil {
+			log.Printf("[AGENT] Failed to write status: %v", err)
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("[AGENT] Serving status on %s", socketPath)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("status server failed: %w", err)
+	}
+	return nil
+}
@@ -25,6 +25,12 @@ type AuditLog struct {
 	RuleID     string    `json:"rule_id"`
 	Success    bool      `json:"success"`
 	Message    string    `json:"message,omitempty"`
+
+	Reason  string `json:"reason,omitempty"`
+	Policy  string `json:"policy,omitempty"`
+	Group   int    `json:"group,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Learned bool   `json:"learned,omitempty"`
 }
 
 func NewLogger(logPath string, enabled bool) (*Logger, error) {
@@ -58,6 +64,11 @@ func (l *Logger) LogEvent(event *filesystem.AuditEvent, message string) {
 		RuleID:     event.RuleID,
 		Success:    event.Success,
 		Message:    message,
+		Reason:     event.Reason,
+		Policy:     event.Policy,
+		Group:      event.Group,
+		Action:     event.Action,
+		Learned:    event.Learned,
 	}
 
 	data, err := json.Marshal(auditLog)
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Severity string
+
+// Errors make Load fail; warnings are legal configurations that probably
+// do not do what was intended.
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is one problem reported by Lint.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	// Object names what the finding is about, e.g. "policy p1 rule r2"
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f Finding) Error() string {
+	if f.Object == "" {
+		return f.Message
+	}
+	return f.Object + " " + f.Message
+}
+
+// Lint reports every problem found in cfg, errors first. Unlike Load it
+// does not stop at the first error.
+func Lint(cfg *Config) []Finding {
+	findings := validateEntries(cfg)
+	findings = append(findings, analyze(cfg)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity == SeverityError && findings[j].Severity != SeverityError
+	})
+	return findings
+}
+
+// analyze checks the configuration as a whole: references between
+// objects, rule ordering and guard point layout.
+func analyze(cfg *Config) []Finding {
+	var findings []Finding
+	findings = append(findings, lintRules(cfg)...)
+	findings = append(findings, lintGuardPoints(cfg)...)
+	findings = append(findings, lintResourceSets(cfg)...)
+	return findings
+}
+
+func lintRules(cfg *Config) []Finding {
+	var findings []Finding
+
+	userSets := make(map[string]bool)
+	for _, us := range cfg.UserSets {
+		userSets[us.Code] = true
+	}
+	processSets := make(map[string]bool)
+	for _, ps := range cfg.ProcessSets {
+		processSets[ps.Code] = true
+	}
+	resourceSets := make(map[string]bool)
+	for _, rs := range cfg.ResourceSets {
+		resourceSets[rs.Code] = true
+	}
+
+	for _, p := range cfg.Policies {
+		orders := make(map[int]string)
+		// Rules in evaluation order, without dangling references
+		var checked []*SecurityRule
+
+		rules := make([]*SecurityRule, len(p.SecurityRules))
+		for i := range p.SecurityRules {
+			rules[i] = &p.SecurityRules[i]
+		}
+		sort.SliceStable(rules, func(i, j int) bool {
+			return rules[i].Order < rules[j].Order
+		})
+
+		for _, rule := range rules {
+			object := fmt.Sprintf("policy %s rule %s", p.Code, rule.ID)
+
+			dangling := false
+			for _, ref := range []struct {
+				kind  string
+				codes []string
+				known map[string]bool
+			}{
+				{"user set", rule.UserSet, userSets},
+				{"process set", rule.ProcessSet, processSets},
+				{"resource set", rule.ResourceSet, resourceSets},
+			} {
+				for _, code := range ref.codes {
+					if !ref.known[code] {
+						findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("references non-existent %s %s", ref.kind, code)})
+						dangling = true
+					}
+				}
+			}
+
+			if opt := rule.Effect.Option; opt.View != "" && opt.ApplyKey != (opt.View == ViewPlaintext || opt.View == ViewMasked) {
+				findings = append(findings, Finding{SeverityWarning, object, fmt.Sprintf("sets apply_key %t but view %s, which decides whether the key is applied", opt.ApplyKey, opt.View)})
+			}
+
+			if other, ok := orders[rule.Order]; ok {
+				findings = append(findings, Finding{SeverityWarning, object, fmt.Sprintf("has the same order %d as rule %s; they are evaluated in file order", rule.Order, other)})
+			} else {
+				orders[rule.Order] = rule.ID
+			}
+
+			if dangling {
+				continue
+			}
+			for _, earlier := range checked {
+				if covers(earlier, rule) {
+					findings = append(findings, Finding{SeverityWarning, object, fmt.Sprintf("can never match: earlier rule %s matches every request it does", earlier.ID)})
+					break
+				}
+			}
+			checked = append(checked, rule)
+		}
+	}
+	return findings
+}
+
+// covers reports whether rule a matches every request rule b matches, so
+// that b placed after a never applies. It is conservative: sets are
+// compared by code, not by their members.
+func covers(a, b *SecurityRule) bool {
+	if a.Schedule != nil {
+		return false
+	}
+	if b.Browsing && !a.Browsing {
+		return false
+	}
+	if !coversActions(a.Action, b.Action) {
+		return false
+	}
+	return coversSets(a.UserSet, b.UserSet) &&
+		coversSets(a.ProcessSet, b.ProcessSet) &&
+		coversSets(a.ResourceSet, b.ResourceSet)
+}
+
+func coversActions(a, b []string) bool {
+	have := make(map[string]bool)
+	for _, action := range a {
+		if action == "all_ops" {
+			return true
+		}
+		have[action] = true
+	}
+	for _, action := range b {
+		if !have[action] {
+			return false
+		}
+	}
+	return true
+}
+
+// coversSets reports whether the set condition a is at least as broad as
+// b. An empty list matches everything.
+func coversSets(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	have := make(map[string]bool)
+	for _, code := range a {
+		have[code] = true
+	}
+	for _, code := range b {
+		if !have[code] {
+			return false
+		}
+	}
+	return true
+}
+
+func lintResourceSets(cfg *Config) []Finding {
+	var findings []Finding
+	for _, rs := range cfg.ResourceSets {
+		for _, resource := range rs.ResourceList {
+			if resource.HSDFS {
+				object := fmt.Sprintf("resource set %s entry %d", rs.Code, resource.Index)
+				findings = append(findings, Finding{SeverityWarning, object, "is an HDFS path and never matches files under a local guard point"})
+			}
+		}
+	}
+	return findings
+}
+
+func lintGuardPoints(cfg *Config) []Finding {
+	var findings []Finding
+
+	type paths struct {
+		code              string
+		protected, secure string
+	}
+	var gps []paths
+	for _, gp := range cfg.GuardPoints {
+		gps = append(gps, paths{gp.Code, cleanPath(gp.ProtectedPath), cleanPath(gp.SecureStoragePath)})
+	}
+
+	for _, gp := range cfg.GuardPoints {
+		listed := make(map[string]bool)
+		for _, code := range gp.PolicyCodes() {
+			if listed[code] {
+				findings = append(findings, Finding{SeverityWarning, "guard point " + gp.Code, fmt.Sprintf("lists policy %s more than once", code)})
+			}
+			listed[code] = true
+		}
+	}
+
+	for i, a := range gps {
+		object := "guard point " + a.code
+
+		if a.protected == a.secure {
+			findings = append(findings, Finding{SeverityError, object, "uses its protected path as secure storage"})
+		} else if within(a.secure, a.protected) {
+			findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("has secure storage %s inside its protected path %s", a.secure, a.protected)})
+		} else if within(a.protected, a.secure) {
+			findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("has protected path %s inside its secure storage %s", a.protected, a.secure)})
+		}
+
+		for _, b := range gps[i+1:] {
+			switch {
+			case a.protected == b.protected:
+				findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("has the same protected path %s as guard point %s", a.protected, b.code)})
+			case within(a.protected, b.protected) || within(b.protected, a.protected):
+				findings = append(findings, Finding{SeverityWarning, object, fmt.Sprintf("protected path %s overlaps guard point %s at %s; the innermost guard point applies", a.protected, b.code, b.protected)})
+			}
+
+			switch {
+			case a.secure == b.secure:
+				findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("shares secure storage %s with guard point %s", a.secure, b.code)})
+			case within(a.secure, b.secure) || within(b.secure, a.secure):
+				findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("secure storage %s overlaps guard point %s at %s", a.secure, b.code, b.secure)})
+			}
+
+			if a.secure == b.protected || within(a.secure, b.protected) {
+				findings = append(findings, Finding{SeverityError, object, fmt.Sprintf("has secure storage %s inside the protected path of guard point %s", a.secure, b.code)})
+			}
+			if b.secure == a.protected || within(b.secure, a.protected) {
+				findings = append(findings, Finding{SeverityError, "guard point " + b.code, fmt.Sprintf("has secure storage %s inside the protected path of guard point %s", b.secure, a.code)})
+			}
+		}
+	}
+	return findings
+}
+
+func cleanPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return filepath.Clean(p)
+}
+
+// within reports whether child lies strictly below parent.
+func within(child, parent string) bool {
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, "../")
+}
@@ -3,13 +3,49 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
+// Files lists the configuration files Load reads from the config directory.
+var Files = []string{
+	"config.json",
+	"user_set.json",
+	"process_set.json",
+	"resource_set.json",
+	"guard-point.json",
+	"policy.json",
+}
+
 func Load(configDir string) (*Config, error) {
+	config, err := Read(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return config, nil
+}
+
+// Read loads the configuration files without validating them, for tools
+// such as Lint that report problems rather than reject the configuration.
+func Read(configDir string) (*Config, error) {
 	config := &Config{}
 
+	version, err := loadVersion(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config.json: %w", err)
+	}
+	config.Version = version
+
 	userSets, err := loadUserSets(filepath.Join(configDir, "user_set.json"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to load user sets: %w", err)
@@ -40,11 +76,27 @@ func Load(configDir string) (*Config, error) {
 	}
 	config.Policies = policies
 
-	if err := validateConfig(config); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	return config, nil
+}
+
+// loadVersion reads config_version from the optional config.json.
+func loadVersion(filename string) (int, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
 	}
 
-	return config, nil
+	var settings struct {
+		Version int `json:"config_version"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return 0, err
+	}
+
+	return settings.Version, nil
 }
 
 func loadUserSets(filename string) ([]UserSet, error) {
@@ -118,16 +170,353 @@ func loadPolicies(filename string) ([]Policy, error) {
 }
 
 func validateConfig(config *Config) error {
+	// Load stops at the first error; Lint reports them all
+	if findings := validateEntries(config); len(findings) > 0 {
+		return findings[0]
+	}
+	for _, finding := range analyze(config) {
+		if finding.Severity == SeverityError {
+			return finding
+		}
+	}
+	return nil
+}
+
+// validateEntries checks each object on its own and that guard points,
+// quotas and process sets reference existing objects. Every finding is an
+// error; each object reports its first problem.
+func validateEntries(config *Config) []Finding {
+	var findings []Finding
+	fail := func(err error) {
+		findings = append(findings, Finding{Severity: SeverityError, Message: err.Error()})
+	}
+
+	switch config.Version {
+	case 0, Version1, Version2:
+	default:
+		fail(fmt.Errorf("unsupported config_version %d", config.Version))
+	}
+
 	policyMap := make(map[string]bool)
 	for _, policy := range config.Policies {
 		policyMap[policy.Code] = true
+		if err := validatePolicyMode(policy.Mode); err != nil {
+			fail(fmt.Errorf("policy %s: %w", policy.Code, err))
+		}
+		for _, rule := range policy.SecurityRules {
+			switch rule.Effect.Option.View {
+			case "", ViewPlaintext, ViewCiphertext, ViewMasked, ViewZeros:
+			default:
+				fail(fmt.Errorf("policy %s rule %s has unknown view %s", policy.Code, rule.ID, rule.Effect.Option.View))
+			}
+			if rule.Schedule == nil {
+				continue
+			}
+			if _, err := ParseSchedule(rule.Schedule); err != nil {
+				fail(fmt.Errorf("policy %s rule %s has an invalid schedule: %w", policy.Code, rule.ID, err))
+			}
+		}
+	}
+
+	userSetMap := make(map[string]bool)
+	for _, userSet := range config.UserSets {
+		userSetMap[userSet.Code] = true
+		if err := validateUserSet(&userSet); err != nil {
+			fail(err)
+		}
+	}
+
+	processSetMap := make(map[string]bool)
+	for _, processSet := range config.ProcessSets {
+		processSetMap[processSet.Code] = true
+	}
+	resourceSetMap := make(map[string]bool)
+	for _, resourceSet := range config.ResourceSets {
+		resourceSetMap[resourceSet.Code] = true
+		if err := validateResourceSet(&resourceSet, config.ComponentPaths()); err != nil {
+			fail(err)
+		}
+	}
+	for _, processSet := range config.ProcessSets {
+		if err := validateProcessSet(&processSet, processSetMap, resourceSetMap); err != nil {
+			fail(err)
+		}
 	}
 
 	for _, gp := range config.GuardPoints {
-		if !policyMap[gp.Policy] {
-			return fmt.Errorf("guard point %s references non-existent policy %s", gp.Code, gp.Policy)
+		if gp.Policy != "" && len(gp.Policies) > 0 {
+			fail(fmt.Errorf("guard point %s sets both policy and policies", gp.Code))
+		}
+		if len(gp.PolicyCodes()) == 0 {
+			fail(fmt.Errorf("guard point %s has no policy", gp.Code))
+		}
+		for _, code := range gp.PolicyCodes() {
+			if !policyMap[code] {
+				fail(fmt.Errorf("guard point %s references non-existent policy %s", gp.Code, code))
+			}
+		}
+		switch gp.CombiningAlgorithm {
+		case "", CombineFirstApplicable, CombineDenyOverrides, CombinePermitOverrides:
+		default:
+			fail(fmt.Errorf("guard point %s has unknown combining_algorithm %s", gp.Code, gp.CombiningAlgorithm))
+		}
+		if err := validateQuota(&gp, userSetMap); err != nil {
+			fail(err)
+		}
+		if err := validateMountOptions(&gp); err != nil {
+			fail(err)
+		}
+		if err := validateAccessMode(&gp); err != nil {
+			fail(err)
+		}
+		if err := validatePolicyMode(gp.Mode); err != nil {
+			fail(fmt.Errorf("guard point %s: %w", gp.Code, err))
+		}
+		switch gp.ErrnoMode {
+		case "", ErrnoModeEACCES, ErrnoModeReason:
+		default:
+			fail(fmt.Errorf("guard point %s has unknown errno_mode %s", gp.Code, gp.ErrnoMode))
+		}
+	}
+
+	return findings
+}
+
+// Limits imposed by the kernel FUSE protocol on request sizes.
+const (
+	maxFUSEWrite     = 1024 * 1024
+	maxFUSEReadahead = 128 * 1024
+	defaultFUSEWrite = 64 * 1024
+)
+
+func validateMountOptions(gp *GuardPoint) error {
+	mo := gp.MountOptions
+	if mo == nil {
+		return nil
+	}
+
+	if mo.MaxWrite < 0 || mo.MaxWrite > maxFUSEWrite {
+		return fmt.Errorf("guard point %s mount option max_write %d must be between 0 and %d", gp.Code, mo.MaxWrite, maxFUSEWrite)
+	}
+	if mo.MaxReadahead < 0 || mo.MaxReadahead > maxFUSEReadahead {
+		return fmt.Errorf("guard point %s mount option max_readahead %d must be between 0 and %d", gp.Code, mo.MaxReadahead, maxFUSEReadahead)
+	}
+
+	maxWrite := mo.MaxWrite
+	if maxWrite == 0 {
+		maxWrite = defaultFUSEWrite
+	}
+	if mo.MaxReadahead > maxWrite {
+		return fmt.Errorf("guard point %s mount option max_readahead %d exceeds max_write %d", gp.Code, mo.MaxReadahead, maxWrite)
+	}
+
+	// Every write re-encrypts the whole file through the interceptor, so the
+	// kernel must not coalesce or reorder writes behind our back.
+	if mo.WritebackCache {
+		return fmt.Errorf("guard point %s mount option writeback_cache is not supported", gp.Code)
+	}
+
+	return nil
+}
+
+func validateUserSet(us *UserSet) error {
+	for i, u := range us.Users {
+		switch u.MatchMode() {
+		case MatchByUID, MatchByGID:
+		case MatchByUName:
+			if u.UName == "" {
+				return fmt.Errorf("user set %s entry %d matches by uname but has no uname", us.Code, i)
+			}
+		case MatchByGName:
+			if u.GName == "" {
+				return fmt.Errorf("user set %s entry %d matches by gname but has no gname", us.Code, i)
+			}
+		case MatchByUIDRange:
+			if u.UIDMin == nil && u.UIDMax == nil {
+				return fmt.Errorf("user set %s entry %d matches by uid_range but has neither uid_min nor uid_max", us.Code, i)
+			}
+			if u.UIDMin != nil && u.UIDMax != nil && *u.UIDMin > *u.UIDMax {
+				return fmt.Errorf("user set %s entry %d has uid_min %d above uid_max %d", us.Code, i, *u.UIDMin, *u.UIDMax)
+			}
+		case MatchByGroupMember:
+			// A gid of 0 cannot be told from an unset one and would
+			// silently mean root, so the group is named
+			if u.GName == "" {
+				return fmt.Errorf("user set %s entry %d matches by group_member but has no gname", us.Code, i)
+			}
+		case MatchByContainer:
+			if u.Container == nil {
+				return fmt.Errorf("user set %s entry %d matches by container but has no container", us.Code, i)
+			}
+		default:
+			return fmt.Errorf("user set %s entry %d has unknown match_by %s", us.Code, i, u.MatchBy)
+		}
+		if err := validateContainerMatch(u.Container); err != nil {
+			return fmt.Errorf("user set %s entry %d: %w", us.Code, i, err)
 		}
 	}
+	if us.LDAP != nil {
+		if err := validateLDAPSource(us.LDAP); err != nil {
+			return fmt.Errorf("user set %s ldap: %w", us.Code, err)
+		}
+	}
+	return nil
+}
 
+func validateLDAPSource(src *LDAPSource) error {
+	u, err := url.Parse(src.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("url %q is not ldap://host or ldaps://host", src.URL)
+	}
+	if src.StartTLS && u.Scheme == "ldaps" {
+		return fmt.Errorf("start_tls cannot be used with ldaps")
+	}
+	if len(src.Groups) == 0 {
+		return fmt.Errorf("no groups")
+	}
+	if src.BindDN != "" && src.BindPasswordFile == "" {
+		return fmt.Errorf("bind_dn %s needs bind_password_file", src.BindDN)
+	}
+	if src.BindDN != "" && u.Scheme == "ldap" && !src.StartTLS && !src.AllowInsecureBind {
+		return fmt.Errorf("bind_dn %s would send its password in the clear; use ldaps://, start_tls or allow_insecure_bind", src.BindDN)
+	}
+	switch src.IDMapping {
+	case "", IDMappingAttribute:
+	case IDMappingSSSD:
+		min, max, size := src.IDRangeMin, src.IDRangeMax, src.IDRangeSize
+		if min < 0 || max < 0 || size < 0 {
+			return fmt.Errorf("negative id range setting")
+		}
+		if min != 0 && max != 0 && size != 0 && max-min < size {
+			return fmt.Errorf("id range %d-%d is smaller than id_range_size %d", min, max, size)
+		}
+	default:
+		return fmt.Errorf("unknown id_mapping %s", src.IDMapping)
+	}
+	if src.RefreshInterval != "" {
+		if d, err := time.ParseDuration(src.RefreshInterval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid refresh_interval %q", src.RefreshInterval)
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func validateProcessSet(ps *ProcessSet, processSetMap, resourceSetMap map[string]bool) error {
+	for _, resource := range ps.ResourceSetList {
+		for _, code := range resource.RWPExemptedResources {
+			if !resourceSetMap[code] {
+				return fmt.Errorf("process set %s entry %d exempts non-existent resource set %s", ps.Code, resource.Index, code)
+			}
+		}
+		if resource.AncestorProcessSet != "" && !processSetMap[resource.AncestorProcessSet] {
+			return fmt.Errorf("process set %s entry %d references non-existent ancestor process set %s", ps.Code, resource.Index, resource.AncestorProcessSet)
+		}
+		if err := validateContainerMatch(resource.Container); err != nil {
+			return fmt.Errorf("process set %s entry %d: %w", ps.Code, resource.Index, err)
+		}
+		for _, signature := range resource.Signature {
+			if _, _, err := ParseSignature(signature); err != nil {
+				return fmt.Errorf("process set %s entry %d: %w", ps.Code, resource.Index, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateResourceSet(rs *ResourceSet, componentPaths bool) error {
+	for _, resource := range rs.ResourceList {
+		if componentPaths {
+			for _, component := range strings.Split(resource.Directory, "/") {
+				if _, err := path.Match(component, ""); err != nil {
+					return fmt.Errorf("resource set %s entry %d has an invalid directory pattern %q: %w", rs.Code, resource.Index, resource.Directory, err)
+				}
+				if component != "**" && strings.Contains(component, "**") {
+					return fmt.Errorf("resource set %s entry %d directory %q uses ** inside a path component", rs.Code, resource.Index, resource.Directory)
+				}
+			}
+		}
+		if resource.Regex != "" {
+			if _, err := regexp.Compile(resource.Regex); err != nil {
+				return fmt.Errorf("resource set %s entry %d has an invalid regex: %w", rs.Code, resource.Index, err)
+			}
+		}
+		for _, ext := range resource.Extensions {
+			if strings.TrimPrefix(ext, ".") == "" || strings.Contains(ext, "/") {
+				return fmt.Errorf("resource set %s entry %d has an invalid extension %q", rs.Code, resource.Index, ext)
+			}
+		}
+		if (resource.MinSize != nil && *resource.MinSize < 0) || (resource.MaxSize != nil && *resource.MaxSize < 0) {
+			return fmt.Errorf("resource set %s entry %d has a negative size bound", rs.Code, resource.Index)
+		}
+		if resource.MinSize != nil && resource.MaxSize != nil && *resource.MinSize > *resource.MaxSize {
+			return fmt.Errorf("resource set %s entry %d has min_size %d above max_size %d", rs.Code, resource.Index, *resource.MinSize, *resource.MaxSize)
+		}
+		for name := range resource.Xattrs {
+			if !strings.Contains(name, ".") {
+				return fmt.Errorf("resource set %s entry %d has xattr %q without a namespace such as user.", rs.Code, resource.Index, name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateContainerMatch(cm *ContainerMatch) error {
+	if cm == nil {
+		return nil
+	}
+	for _, pattern := range []string{cm.Image, cm.Cgroup} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid container pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func validateAccessMode(gp *GuardPoint) error {
+	switch gp.AccessMode {
+	case "", AccessModeReadWrite, AccessModeReadOnly, AccessModeAppendOnly:
+		if gp.WORMRetentionDays != 0 {
+			return fmt.Errorf("guard point %s sets worm_retention_days without access_mode %s", gp.Code, AccessModeWORM)
+		}
+	case AccessModeWORM:
+		if gp.WORMRetentionDays < 0 {
+			return fmt.Errorf("guard point %s has negative worm_retention_days %d", gp.Code, gp.WORMRetentionDays)
+		}
+	default:
+		return fmt.Errorf("guard point %s has unknown access_mode %s", gp.Code, gp.AccessMode)
+	}
+	return nil
+}
+
+func validatePolicyMode(mode string) error {
+	switch mode {
+	case "", PolicyModeEnforce, PolicyModeLearn:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %s", mode)
+}
+
+func validateQuota(gp *GuardPoint, userSetMap map[string]bool) error {
+	q := gp.Quota
+	if q == nil {
+		return nil
+	}
+
+	if q.MaxBytes < 0 || q.MaxInodes < 0 {
+		return fmt.Errorf("guard point %s has a negative quota", gp.Code)
+	}
+
+	for i, uq := range q.Users {
+		if (uq.UID == nil) == (uq.UserSet == "") {
+			return fmt.Errorf("guard point %s quota entry %d must set exactly one of uid or user_set", gp.Code, i)
+		}
+		if uq.UserSet != "" && !userSetMap[uq.UserSet] {
+			return fmt.Errorf("guard point %s quota references non-existent user set %s", gp.Code, uq.UserSet)
+		}
+		if uq.MaxBytes < 0 || uq.MaxInodes < 0 {
+			return fmt.Errorf("guard point %s quota entry %d has a negative limit", gp.Code, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package config
+
+import "testing"
+
+func TestValidateLDAPSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     LDAPSource
+		wantErr bool
+	}{
+		{"anonymous ldap", LDAPSource{URL: "ldap://dc1"}, false},
+		{"bind over ldaps", LDAPSource{URL: "ldaps://dc1", BindDN: "cn=svc"}, false},
+		{"bind with start_tls", LDAPSource{URL: "ldap://dc1", StartTLS: true, BindDN: "cn=svc"}, false},
+		{"bind over plain ldap", LDAPSource{URL: "ldap://dc1", BindDN: "cn=svc"}, true},
+		{"insecure bind opted in", LDAPSource{URL: "ldap://dc1", BindDN: "cn=svc", AllowInsecureBind: true}, false},
+		{"start_tls with ldaps", LDAPSource{URL: "ldaps://dc1", StartTLS: true}, true},
+		{"not ldap", LDAPSource{URL: "https://dc1"}, true},
+		{"no groups", LDAPSource{URL: "ldap://dc1", Groups: []string{}}, true},
+		{"sssd range too small", LDAPSource{URL: "ldap://dc1", IDMapping: IDMappingSSSD, IDRangeMin: 1, IDRangeMax: 10, IDRangeSize: 100}, true},
+		{"unknown id mapping", LDAPSource{URL: "ldap://dc1", IDMapping: "rfc2307"}, true},
+		{"bad refresh", LDAPSource{URL: "ldap://dc1", RefreshInterval: "often"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			if src.Groups == nil {
+				src.Groups = []string{"cn=finance,dc=example,dc=com"}
+			}
+			if src.BindDN != "" && src.BindPasswordFile == "" {
+				src.BindPasswordFile = "/etc/takakrypt/ldap-password"
+			}
+			err := validateLDAPSource(&src)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLDAPSource = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMountOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		mo      *MountOptions
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"defaults", &MountOptions{}, false},
+		{"direct_io", &MountOptions{DirectIO: true}, false},
+		{"writeback_cache", &MountOptions{WritebackCache: true}, true},
+		{"writeback_cache with direct_io", &MountOptions{WritebackCache: true, DirectIO: true}, true},
+		{"max_write", &MountOptions{MaxWrite: maxFUSEWrite}, false},
+		{"max_write too large", &MountOptions{MaxWrite: maxFUSEWrite + 1}, true},
+		{"negative max_write", &MountOptions{MaxWrite: -1}, true},
+		{"max_readahead", &MountOptions{MaxReadahead: defaultFUSEWrite}, false},
+		{"max_readahead above default max_write", &MountOptions{MaxReadahead: defaultFUSEWrite + 1}, true},
+		{"max_readahead above max_write", &MountOptions{MaxWrite: 4096, MaxReadahead: 8192}, true},
+		{"max_readahead too large", &MountOptions{MaxWrite: maxFUSEWrite, MaxReadahead: maxFUSEReadahead + 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMountOptions(&GuardPoint{Code: "gp", MountOptions: tt.mo})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMountOptions = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateUserSet(t *testing.T) {
+	min, max := 1000, 1999
+	tests := []struct {
+		name    string
+		user    User
+		wantErr bool
+	}{
+		{"uid", User{UID: 0}, false},
+		{"group member", User{GName: "dba", MatchBy: MatchByGroupMember}, false},
+		{"group member without gname", User{MatchBy: MatchByGroupMember}, true},
+		{"group member by gid only", User{GID: 50, MatchBy: MatchByGroupMember}, true},
+		{"gname without gname", User{MatchBy: MatchByGName}, true},
+		{"uid range", User{UIDMin: &min, UIDMax: &max, MatchBy: MatchByUIDRange}, false},
+		{"uid range without bounds", User{MatchBy: MatchByUIDRange}, true},
+		{"uid range reversed", User{UIDMin: &max, UIDMax: &min, MatchBy: MatchByUIDRange}, true},
+		{"unknown", User{MatchBy: "email"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUserSet(&UserSet{Code: "us", Users: []User{tt.user}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateUserSet = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLintReportsEveryEntryError(t *testing.T) {
+	cfg := &Config{
+		Version: 7,
+		UserSets: []UserSet{
+			{Code: "a", Users: []User{{MatchBy: MatchByUName}}},
+			{Code: "b", Users: []User{{MatchBy: MatchByGroupMember}}},
+		},
+		GuardPoints: []GuardPoint{
+			{Code: "gp1", ProtectedPath: "/data/a", SecureStoragePath: "/secure/a", Policy: "missing"},
+			{Code: "gp2", ProtectedPath: "/data/b", SecureStoragePath: "/secure/b", Policy: "p", ErrnoMode: "loud"},
+		},
+		Policies: []Policy{{Code: "p", SecurityRules: []SecurityRule{{ID: "r", Effect: RuleEffect{Permission: "permit", Option: EffectOption{View: "blurred"}}}}}},
+	}
+
+	var errors []string
+	for _, f := range Lint(cfg) {
+		if f.Severity == SeverityError {
+			errors = append(errors, f.Error())
+		}
+	}
+	want := []string{
+		"unsupported config_version 7",
+		"policy p rule r has unknown view blurred",
+		"user set a entry 0 matches by uname but has no uname",
+		"user set b entry 0 matches by group_member but has no gname",
+		"guard point gp1 references non-existent policy missing",
+		"guard point gp2 has unknown errno_mode loud",
+	}
+	if len(errors) != len(want) {
+		t.Fatalf("Lint errors = %q, want %q", errors, want)
+	}
+	for i := range want {
+		if errors[i] != want[i] {
+			t.Errorf("error %d = %q, want %q", i, errors[i], want[i])
+		}
+	}
+
+	// Load still fails on the first
+	if err := validateConfig(cfg); err == nil || err.Error() != want[0] {
+		t.Errorf("validateConfig = %v, want %q", err, want[0])
+	}
+}
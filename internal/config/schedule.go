@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RuleSchedule limits when a security rule applies. All set conditions
+// must hold; with no windows the rule applies at any time of day.
+type RuleSchedule struct {
+	// Timezone is an IANA zone name such as "Asia/Jakarta"; empty means
+	// the agent's local time
+	Timezone string       `json:"timezone,omitempty"`
+	Windows  []TimeWindow `json:"windows,omitempty"`
+	// NotBefore and NotAfter are RFC 3339 timestamps or YYYY-MM-DD dates
+	// in Timezone. A NotAfter date includes the whole day.
+	NotBefore string `json:"not_before,omitempty"`
+	NotAfter  string `json:"not_after,omitempty"`
+}
+
+// TimeWindow is a daily time range, on the given days or every day. A
+// window whose end is before its start runs past midnight into the next
+// day.
+type TimeWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"` // HH:MM, inclusive
+	End   string   `json:"end"`   // HH:MM, exclusive; 24:00 for end of day
+}
+
+// Schedule is a parsed RuleSchedule.
+type Schedule struct {
+	Location  *time.Location
+	Windows   []Window
+	NotBefore time.Time
+	NotAfter  time.Time // exclusive
+}
+
+// Window is a parsed TimeWindow, in minutes after midnight.
+type Window struct {
+	Days       [7]bool // indexed by time.Weekday
+	Start, End int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+func ParseSchedule(rs *RuleSchedule) (*Schedule, error) {
+	s := &Schedule{Location: time.Local}
+	if rs.Timezone != "" {
+		loc, err := time.LoadLocation(rs.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %s: %w", rs.Timezone, err)
+		}
+		s.Location = loc
+	}
+
+	for i, tw := range rs.Windows {
+		w, err := parseWindow(&tw)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i, err)
+		}
+		s.Windows = append(s.Windows, w)
+	}
+
+	var err error
+	if rs.NotBefore != "" {
+		if s.NotBefore, err = parseBound(rs.NotBefore, s.Location, false); err != nil {
+			return nil, err
+		}
+	}
+	if rs.NotAfter != "" {
+		if s.NotAfter, err = parseBound(rs.NotAfter, s.Location, true); err != nil {
+			return nil, err
+		}
+	}
+	if !s.NotBefore.IsZero() && !s.NotAfter.IsZero() && !s.NotBefore.Before(s.NotAfter) {
+		return nil, fmt.Errorf("not_before %s is not before not_after %s", rs.NotBefore, rs.NotAfter)
+	}
+
+	return s, nil
+}
+
+func parseWindow(tw *TimeWindow) (Window, error) {
+	var w Window
+	if len(tw.Days) == 0 {
+		for d := range w.Days {
+			w.Days[d] = true
+		}
+	}
+	for _, name := range tw.Days {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return Window{}, fmt.Errorf("unknown day %s", name)
+		}
+		w.Days[day] = true
+	}
+
+	var err error
+	if w.Start, err = parseTimeOfDay(tw.Start); err != nil {
+		return Window{}, err
+	}
+	if w.End, err = parseTimeOfDay(tw.End); err != nil {
+		return Window{}, err
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("start %s equals end %s", tw.Start, tw.End)
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil || len(value) != 5 {
+		return 0, fmt.Errorf("time %q must be HH:MM", value)
+	}
+	if hour == 24 && minute == 0 {
+		return 24 * 60, nil
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("time %q is out of range", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// parseBound parses a not_before or not_after value. A date means the
+// start of that day, or for an end bound the start of the following day.
+func parseBound(value string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 timestamp nor a YYYY-MM-DD date", value)
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// Active reports whether the schedule allows access at t.
+func (s *Schedule) Active(t time.Time) bool {
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) {
+		return false
+	}
+	if !s.NotAfter.IsZero() && !t.Before(s.NotAfter) {
+		return false
+	}
+	if len(s.Windows) == 0 {
+		return true
+	}
+
+	local := t.In(s.Location)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+	previous := (day + 6) % 7
+
+	for _, w := range s.Windows {
+		if w.Start < w.End {
+			if w.Days[day] && minute >= w.Start && minute < w.End {
+				return true
+			}
+			continue
+		}
+		// Past midnight: the evening belongs to today's window, the early
+		// morning to yesterday's
+		if (w.Days[day] && minute >= w.Start) || (w.Days[previous] && minute < w.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the earliest instant after t at which Active may
+// change, or the zero time if it never will. Windows have minute
+// granularity, so with windows this is at most a minute away.
+func (s *Schedule) NextChange(t time.Time) time.Time {
+	var next time.Time
+	consider := func(c time.Time) {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+	consider(s.NotBefore)
+	consider(s.NotAfter)
+	if len(s.Windows) > 0 {
+		consider(t.Truncate(time.Minute).Add(time.Minute))
+	}
+	return next
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // zones for the DST cases, wherever the tests run
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule RuleSchedule
+		wantErr  bool
+	}{
+		{"empty", RuleSchedule{}, false},
+		{"window", RuleSchedule{Windows: []TimeWindow{{Days: []string{"Mon", "friday"}, Start: "09:00", End: "17:00"}}}, false},
+		{"past midnight", RuleSchedule{Windows: []TimeWindow{{Start: "22:00", End: "06:00"}}}, false},
+		{"end of day", RuleSchedule{Windows: []TimeWindow{{Start: "18:00", End: "24:00"}}}, false},
+		{"dates", RuleSchedule{NotBefore: "2026-01-01", NotAfter: "2026-01-01"}, false},
+		{"timestamps", RuleSchedule{NotBefore: "2026-01-01T00:00:00Z", NotAfter: "2026-06-30T12:00:00+07:00"}, false},
+		{"timezone", RuleSchedule{Timezone: "Asia/Jakarta"}, false},
+		{"unknown timezone", RuleSchedule{Timezone: "Mars/Olympus"}, true},
+		{"unknown day", RuleSchedule{Windows: []TimeWindow{{Days: []string{"funday"}, Start: "09:00", End: "17:00"}}}, true},
+		{"single digit hour", RuleSchedule{Windows: []TimeWindow{{Start: "9:00", End: "17:00"}}}, true},
+		{"hour out of range", RuleSchedule{Windows: []TimeWindow{{Start: "09:00", End: "25:00"}}}, true},
+		{"minute out of range", RuleSchedule{Windows: []TimeWindow{{Start: "09:60", End: "17:00"}}}, true},
+		{"past end of day", RuleSchedule{Windows: []TimeWindow{{Start: "09:00", End: "24:01"}}}, true},
+		{"empty window", RuleSchedule{Windows: []TimeWindow{{Start: "09:00", End: "09:00"}}}, true},
+		{"bad date", RuleSchedule{NotBefore: "01/02/2026"}, true},
+		{"reversed bounds", RuleSchedule{NotBefore: "2026-02-01", NotAfter: "2026-01-31"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchedule(&tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	utc := time.UTC
+	newYork := mustLoad(t, "America/New_York")
+	// 2026-01-05 is a Monday
+	at := func(loc *time.Location, day, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, loc)
+	}
+
+	weekdayNights := RuleSchedule{Timezone: "UTC", Windows: []TimeWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "22:00", End: "06:00"}}}
+	tests := []struct {
+		name     string
+		schedule RuleSchedule
+		t        time.Time
+		want     bool
+	}{
+		{"no conditions", RuleSchedule{}, at(utc, 5, 3, 0), true},
+		{"office hours, inside", RuleSchedule{Timezone: "UTC", Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, at(utc, 5, 9, 0), true},
+		{"office hours, end is exclusive", RuleSchedule{Timezone: "UTC", Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, at(utc, 5, 17, 0), false},
+		{"office hours, last minute", RuleSchedule{Timezone: "UTC", Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, at(utc, 5, 16, 59), true},
+		{"other day", RuleSchedule{Timezone: "UTC", Windows: []TimeWindow{{Days: []string{"tue"}, Start: "09:00", End: "17:00"}}}, at(utc, 5, 10, 0), false},
+		{"end of day", RuleSchedule{Timezone: "UTC", Windows: []TimeWindow{{Start: "18:00", End: "24:00"}}}, at(utc, 5, 23, 59), true},
+
+		// Windows past midnight belong to the day they start on
+		{"night, Monday evening", weekdayNights, at(utc, 5, 23, 0), true},
+		{"night, Tuesday early morning", weekdayNights, at(utc, 6, 5, 59), true},
+		{"night, Tuesday at end", weekdayNights, at(utc, 6, 6, 0), false},
+		{"night, Monday early morning", weekdayNights, at(utc, 5, 3, 0), false},
+		{"night, Saturday early morning", weekdayNights, at(utc, 10, 3, 0), true},
+		{"night, Saturday evening", weekdayNights, at(utc, 10, 23, 0), false},
+		{"night, Sunday early morning", weekdayNights, at(utc, 11, 3, 0), false},
+		{"night, at midnight", weekdayNights, at(utc, 6, 0, 0), true},
+
+		// Windows are in the schedule's zone, not the instant's
+		{"zone", RuleSchedule{Timezone: "Asia/Jakarta", Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, at(utc, 5, 2, 0), true},
+		{"zone, outside", RuleSchedule{Timezone: "Asia/Jakarta", Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, at(utc, 5, 10, 0), false},
+
+		// Date bounds are whole days in the schedule's zone
+		{"before not_before", RuleSchedule{Timezone: "UTC", NotBefore: "2026-01-05"}, at(utc, 4, 23, 59), false},
+		{"at not_before", RuleSchedule{Timezone: "UTC", NotBefore: "2026-01-05"}, at(utc, 5, 0, 0), true},
+		{"last day of not_after", RuleSchedule{Timezone: "UTC", NotAfter: "2026-01-05"}, at(utc, 5, 23, 59), true},
+		{"after not_after", RuleSchedule{Timezone: "UTC", NotAfter: "2026-01-05"}, at(utc, 6, 0, 0), false},
+		{"not_after in zone", RuleSchedule{Timezone: "America/New_York", NotAfter: "2026-01-05"}, at(utc, 6, 3, 0), true},
+		{"timestamp bound", RuleSchedule{NotAfter: "2026-01-05T12:00:00+07:00"}, at(utc, 5, 5, 0), false},
+	}
+
+	// Daylight saving time in New York: clocks go from 02:00 to 03:00 on
+	// 2026-03-08 and from 02:00 back to 01:00 on 2026-11-01
+	dst := RuleSchedule{Timezone: "America/New_York", Windows: []TimeWindow{{Start: "01:00", End: "02:30"}}}
+	spring := time.Date(2026, 3, 8, 0, 0, 0, 0, newYork)
+	fall := time.Date(2026, 11, 1, 0, 0, 0, 0, newYork)
+	tests = append(tests, []struct {
+		name     string
+		schedule RuleSchedule
+		t        time.Time
+		want     bool
+	}{
+		{"spring forward, before the gap", dst, spring.Add(119 * time.Minute), true},
+		// 02:00 EST does not exist; the minute after 01:59 is 03:00 EDT
+		{"spring forward, after the gap", dst, spring.Add(120 * time.Minute), false},
+		{"fall back, first 01:30", dst, fall.Add(90 * time.Minute), true},
+		{"fall back, second 01:30", dst, fall.Add(150 * time.Minute), true},
+		{"fall back, second 02:29", dst, fall.Add(209 * time.Minute), true},
+		{"fall back, second 02:30", dst, fall.Add(210 * time.Minute), false},
+		{"summer time zone offset", RuleSchedule{Timezone: "America/New_York", Windows: []TimeWindow{{Start: "09:00", End: "10:00"}}}, time.Date(2026, 7, 1, 13, 30, 0, 0, utc), true},
+		{"winter time zone offset", RuleSchedule{Timezone: "America/New_York", Windows: []TimeWindow{{Start: "09:00", End: "10:00"}}}, time.Date(2026, 1, 7, 13, 30, 0, 0, utc), false},
+	}...)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(&tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Active(tt.t); got != tt.want {
+				t.Errorf("Active(%s) = %v, want %v", tt.t.In(s.Location).Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	base := time.Date(2026, 1, 5, 9, 30, 15, 0, time.UTC)
+	tests := []struct {
+		name     string
+		schedule RuleSchedule
+		t        time.Time
+		want     time.Time
+	}{
+		{"no conditions", RuleSchedule{}, base, time.Time{}},
+		{"windows, next minute", RuleSchedule{Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, base, time.Date(2026, 1, 5, 9, 31, 0, 0, time.UTC)},
+		{"windows, on the minute", RuleSchedule{Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, base.Truncate(time.Minute), time.Date(2026, 1, 5, 9, 31, 0, 0, time.UTC)},
+		{"not_before ahead", RuleSchedule{Timezone: "UTC", NotBefore: "2026-01-06"}, base, time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"not_after ahead", RuleSchedule{Timezone: "UTC", NotAfter: "2026-01-05"}, base, time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"bounds passed", RuleSchedule{Timezone: "UTC", NotBefore: "2026-01-01", NotAfter: "2026-01-02"}, base, time.Time{}},
+		{"nearest bound", RuleSchedule{NotBefore: "2026-01-05T09:30:20Z", NotAfter: "2026-01-07"}, base, time.Date(2026, 1, 5, 9, 30, 20, 0, time.UTC)},
+		{"window minute before a bound", RuleSchedule{NotAfter: "2026-01-05T09:30:40Z", Windows: []TimeWindow{{Start: "09:00", End: "17:00"}}}, base, time.Date(2026, 1, 5, 9, 30, 40, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(&tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.NextChange(tt.t); !got.Equal(tt.want) {
+				t.Errorf("NextChange = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
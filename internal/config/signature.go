@@ -0,0 +1,51 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Process set signature kinds, written as "<kind>:<value>".
+const (
+	// SHA-256 of the executable, hex encoded
+	SignatureSHA256 = "sha256"
+	// Digest recorded in the executable's security.ima xattr, hex encoded
+	SignatureIMA = "ima"
+	// Ed25519 public key, base64 encoded, verifying the detached signature
+	// in "<binary>.sig"
+	SignatureEd25519 = "ed25519"
+)
+
+// ParseSignature splits a process set signature entry into its kind and
+// decoded value: a digest for sha256 and ima, a public key for ed25519.
+func ParseSignature(entry string) (kind string, value []byte, err error) {
+	kind, encoded, ok := strings.Cut(entry, ":")
+	if !ok {
+		return "", nil, fmt.Errorf("signature %q must have the form <kind>:<value>", entry)
+	}
+
+	switch kind {
+	case SignatureSHA256:
+		value, err = hex.DecodeString(encoded)
+		if err != nil || len(value) != sha256.Size {
+			return "", nil, fmt.Errorf("signature %q is not a hex SHA-256 digest", entry)
+		}
+	case SignatureIMA:
+		value, err = hex.DecodeString(encoded)
+		if err != nil || len(value) == 0 {
+			return "", nil, fmt.Errorf("signature %q is not a hex digest", entry)
+		}
+	case SignatureEd25519:
+		value, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(value) != ed25519.PublicKeySize {
+			return "", nil, fmt.Errorf("signature %q is not a base64 Ed25519 public key", entry)
+		}
+	default:
+		return "", nil, fmt.Errorf("signature %q has unknown kind %s", entry, kind)
+	}
+	return kind, value, nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseSignature(t *testing.T) {
+	digest := sha256.Sum256([]byte("binary"))
+	key := bytes.Repeat([]byte{1}, ed25519.PublicKeySize)
+	tests := []struct {
+		entry string
+		kind  string
+		value []byte
+		err   string
+	}{
+		{"sha256:" + hex.EncodeToString(digest[:]), SignatureSHA256, digest[:], ""},
+		{"sha256:" + hex.EncodeToString(digest[:8]), "", nil, "not a hex SHA-256 digest"},
+		{"sha256:zz", "", nil, "not a hex SHA-256 digest"},
+		{"ima:" + hex.EncodeToString(digest[:20]), SignatureIMA, digest[:20], ""},
+		{"ima:", "", nil, "not a hex digest"},
+		{"ed25519:" + base64.StdEncoding.EncodeToString(key), SignatureEd25519, key, ""},
+		{"ed25519:" + base64.StdEncoding.EncodeToString(key[:16]), "", nil, "not a base64 Ed25519 public key"},
+		{"ed25519:!!", "", nil, "not a base64 Ed25519 public key"},
+		{"md5:abcd", "", nil, "unknown kind md5"},
+		{hex.EncodeToString(digest[:]), "", nil, "must have the form"},
+	}
+	for _, tt := range tests {
+		kind, value, err := ParseSignature(tt.entry)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseSignature(%q) error = %v, want %q", tt.entry, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || kind != tt.kind || !bytes.Equal(value, tt.value) {
+			t.Errorf("ParseSignature(%q) = %s, %x, %v; want %s, %x", tt.entry, kind, value, err, tt.kind, tt.value)
+		}
+	}
+}
@@ -1,6 +1,14 @@
 package config
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Config struct {
+	// Version is the config_version from config.json, 0 if the file is
+	// absent, which behaves as Version1
+	Version      int           `json:"config_version,omitempty"`
 	UserSets     []UserSet     `json:"user_sets"`
 	ProcessSets  []ProcessSet  `json:"process_sets"`
 	ResourceSets []ResourceSet `json:"resource_sets"`
@@ -8,6 +16,35 @@ type Config struct {
 	Policies     []Policy      `json:"policies"`
 }
 
+// Configuration versions. Version2 matches resource directories by path
+// component, with * and ** globs, and covers the guard point root only
+// through entries for the root.
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
+// ComponentPaths reports whether resource directories are matched by
+// path component rather than by string prefix.
+func (c *Config) ComponentPaths() bool {
+	return c.Version >= Version2
+}
+
+// Copy returns a deep copy of c, sharing nothing with it.
+func (c *Config) Copy() *Config {
+	// Everything in a configuration round-trips through JSON, which it
+	// is loaded from
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic("config: cannot copy configuration: " + err.Error())
+	}
+	copied := &Config{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		panic("config: cannot copy configuration: " + err.Error())
+	}
+	return copied
+}
+
 type UserSet struct {
 	ID          string    `json:"id"`
 	Code        string    `json:"code"`
@@ -16,6 +53,66 @@ type UserSet struct {
 	ModifiedAt  int64     `json:"modified_at"`
 	Description string    `json:"description"`
 	Users       []User    `json:"users"`
+
+	// LDAP adds the members of directory groups to Users; the agent
+	// refreshes them periodically
+	LDAP *LDAPSource `json:"ldap,omitempty"`
+}
+
+// LDAPSource reads the members of LDAP or Active Directory groups.
+type LDAPSource struct {
+	// URL is ldap://host[:port] or ldaps://host[:port]
+	URL      string `json:"url"`
+	StartTLS bool   `json:"start_tls,omitempty"`
+	// CACert is a PEM file of CAs to trust instead of the system roots
+	CACert string `json:"ca_cert,omitempty"`
+	// BindDN is empty for an anonymous bind. The password is read from
+	// BindPasswordFile so it stays out of the configuration.
+	BindDN           string `json:"bind_dn,omitempty"`
+	BindPasswordFile string `json:"bind_password_file,omitempty"`
+	// AllowInsecureBind permits binding over ldap:// without StartTLS,
+	// which sends the password in the clear
+	AllowInsecureBind bool `json:"allow_insecure_bind,omitempty"`
+
+	// Groups are the DNs of the groups whose members, nested groups
+	// included, belong to the set
+	Groups []string `json:"groups"`
+	// UserBaseDN is searched for the members that groups list by name
+	// (memberUid) rather than by DN
+	UserBaseDN string `json:"user_base_dn,omitempty"`
+	// UserNameAttribute defaults to uid; sAMAccountName for Active
+	// Directory
+	UserNameAttribute string `json:"user_name_attribute,omitempty"`
+	// UIDAttribute defaults to uidNumber. It is not used with IDMapping
+	// "sssd".
+	UIDAttribute string `json:"uid_attribute,omitempty"`
+	// IDMapping "sssd" derives UIDs from objectSid like SSSD with
+	// ldap_id_mapping; the ID range settings default to SSSD's
+	IDMapping   string `json:"id_mapping,omitempty"`
+	IDRangeMin  int64  `json:"id_range_min,omitempty"`
+	IDRangeMax  int64  `json:"id_range_max,omitempty"`
+	IDRangeSize int64  `json:"id_range_size,omitempty"`
+
+	// RefreshInterval is a duration such as "15m" (the default)
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+}
+
+// LDAP ID mappings.
+const (
+	IDMappingAttribute = "attribute"
+	IDMappingSSSD      = "sssd"
+)
+
+// DefaultLDAPRefresh is how often LDAP user sets are refreshed unless
+// they set refresh_interval.
+const DefaultLDAPRefresh = 15 * time.Minute
+
+// Refresh returns how often the source is read.
+func (s *LDAPSource) Refresh() time.Duration {
+	if d, err := time.ParseDuration(s.RefreshInterval); err == nil && d > 0 {
+		return d
+	}
+	return DefaultLDAPRefresh
 }
 
 type User struct {
@@ -33,6 +130,53 @@ type User struct {
 	OSUser     string `json:"os_user"`
 	CreatedAt  int64  `json:"created_at"`
 	ModifiedAt int64  `json:"modified_at"`
+
+	// MatchBy selects which identity field the entry matches on; empty
+	// means uid.
+	MatchBy string `json:"match_by,omitempty"`
+	// UIDMin and UIDMax bound the UIDs of a uid_range entry; either may
+	// be left out for an open range
+	UIDMin *int `json:"uid_min,omitempty"`
+	UIDMax *int `json:"uid_max,omitempty"`
+	// Container restricts the entry to callers in matching containers or
+	// cgroups. IDs are then compared as seen inside the container's user
+	// namespace.
+	Container *ContainerMatch `json:"container,omitempty"`
+}
+
+// ContainerMatch selects processes by where they run. Set fields must all
+// match; an empty object matches any container.
+type ContainerMatch struct {
+	// ID is a container ID or prefix of one
+	ID string `json:"id,omitempty"`
+	// Image is a glob against the image reference, e.g. "mysql:8.*"
+	Image string `json:"image,omitempty"`
+	// Cgroup is a glob against the cgroup path; a plain path also matches
+	// every cgroup below it
+	Cgroup string `json:"cgroup,omitempty"`
+}
+
+// User set entry match modes.
+const (
+	MatchByUID   = "uid"
+	MatchByUName = "uname"
+	MatchByGID   = "gid"
+	MatchByGName = "gname"
+	// Any UID between uid_min and uid_max inclusive
+	MatchByUIDRange = "uid_range"
+	// Any user the account database lists as a member of gname, or of
+	// gid if gname is empty, whatever groups the calling process has
+	MatchByGroupMember = "group_member"
+	// Any caller in the entry's container
+	MatchByContainer = "container"
+)
+
+// MatchMode returns the entry's match mode, defaulting to uid.
+func (u *User) MatchMode() string {
+	if u.MatchBy == "" {
+		return MatchByUID
+	}
+	return u.MatchBy
 }
 
 type ProcessSet struct {
@@ -54,6 +198,16 @@ type ProcessSetResource struct {
 	RWPExemptedResources   []string `json:"rwp_exempted_resources"`
 	CreatedAt              int64    `json:"created_at"`
 	ModifiedAt             int64    `json:"modified_at"`
+
+	// AncestorProcessSet requires some ancestor of the process to match
+	// the named process set
+	AncestorProcessSet string `json:"ancestor_process_set,omitempty"`
+	// DenyInteractiveShell rejects processes with an interactive shell
+	// (a shell with a controlling terminal) among their ancestors
+	DenyInteractiveShell bool `json:"deny_interactive_shell,omitempty"`
+	// Container restricts the entry to processes in matching containers
+	// or cgroups
+	Container *ContainerMatch `json:"container,omitempty"`
 }
 
 type ResourceSet struct {
@@ -69,14 +223,37 @@ type ResourceSet struct {
 }
 
 type Resource struct {
-	Index      int    `json:"index"`
-	ID         string `json:"id"`
-	Directory  string `json:"directory"`
-	File       string `json:"file"`
-	Subfolder  bool   `json:"subfolder"`
-	HSDFS      bool   `json:"hsdfs"`
-	CreatedAt  int64  `json:"created_at"`
-	ModifiedAt int64  `json:"modified_at"`
+	Index     int    `json:"index"`
+	ID        string `json:"id"`
+	Directory string `json:"directory"`
+	File      string `json:"file"`
+	Subfolder bool   `json:"subfolder"`
+	// HSDFS marks Directory as a path in an HDFS namespace. The agent
+	// only protects local directories, so such entries never match.
+	HSDFS      bool  `json:"hsdfs"`
+	CreatedAt  int64 `json:"created_at"`
+	ModifiedAt int64 `json:"modified_at"`
+
+	// Conditions on the file below; all that are set must hold.
+
+	// Regex is matched against the path relative to the guard point
+	Regex string `json:"regex,omitempty"`
+	// Extensions are file name suffixes such as "csv" or ".tar.gz",
+	// compared case-insensitively
+	Extensions []string `json:"extensions,omitempty"`
+	// MinSize and MaxSize bound the plaintext size in bytes, inclusive
+	MinSize  *int64 `json:"min_size,omitempty"`
+	MaxSize  *int64 `json:"max_size,omitempty"`
+	OwnerUID *int   `json:"owner_uid,omitempty"`
+	// Xattrs maps extended attribute names to the value the file must
+	// have; "*" only requires the attribute to be set
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+}
+
+// InspectsFile reports whether the entry has conditions on the metadata
+// of the stored file, which must then be read for every request.
+func (r *Resource) InspectsFile() bool {
+	return r.MinSize != nil || r.MaxSize != nil || r.OwnerUID != nil || len(r.Xattrs) > 0
 }
 
 type GuardPoint struct {
@@ -93,6 +270,132 @@ type GuardPoint struct {
 	Enabled           bool   `json:"enabled"`
 	CreatedAt         int64  `json:"created_at"`
 	UpdatedAt         int64  `json:"updated_at"`
+
+	Quota        *GuardPointQuota `json:"quota,omitempty"`
+	MountOptions *MountOptions    `json:"mount_options,omitempty"`
+
+	// AccessMode restricts mutations regardless of what policy permits.
+	// WORMRetentionDays only applies to AccessModeWORM.
+	AccessMode        string `json:"access_mode,omitempty"`
+	WORMRetentionDays int    `json:"worm_retention_days,omitempty"`
+
+	// Mode is the enforcement mode of the guard point's policy; see
+	// PolicyModeLearn.
+	Mode string `json:"mode,omitempty"`
+
+	// ErrnoMode selects the errno returned for refused operations.
+	ErrnoMode string `json:"errno_mode,omitempty"`
+
+	// Policies attaches several policies, evaluated in order and combined
+	// by CombiningAlgorithm, in place of Policy.
+	Policies           []string `json:"policies,omitempty"`
+	CombiningAlgorithm string   `json:"combining_algorithm,omitempty"`
+}
+
+// PolicyCodes returns the guard point's policies in evaluation order.
+func (gp *GuardPoint) PolicyCodes() []string {
+	if len(gp.Policies) > 0 {
+		return gp.Policies
+	}
+	if gp.Policy != "" {
+		return []string{gp.Policy}
+	}
+	return nil
+}
+
+// Copy returns a deep copy of gp, sharing nothing with it. Unlike
+// Config.Copy it copies field by field, as it is called for every
+// decision.
+func (gp *GuardPoint) Copy() *GuardPoint {
+	copied := *gp
+	if gp.Quota != nil {
+		q := *gp.Quota
+		if gp.Quota.Users != nil {
+			q.Users = make([]UserQuota, len(gp.Quota.Users))
+			for i, uq := range gp.Quota.Users {
+				if uq.UID != nil {
+					uid := *uq.UID
+					uq.UID = &uid
+				}
+				q.Users[i] = uq
+			}
+		}
+		copied.Quota = &q
+	}
+	if gp.MountOptions != nil {
+		mo := *gp.MountOptions
+		if mo.AllowOther != nil {
+			v := *mo.AllowOther
+			mo.AllowOther = &v
+		}
+		if mo.AsyncRead != nil {
+			v := *mo.AsyncRead
+			mo.AsyncRead = &v
+		}
+		copied.MountOptions = &mo
+	}
+	if gp.Policies != nil {
+		copied.Policies = append([]string(nil), gp.Policies...)
+	}
+	return &copied
+}
+
+// Policy combining algorithms. Each policy's decision comes from its first
+// matching rule. With CombineFirstApplicable, the default, the first policy
+// with a matching rule decides; with CombineDenyOverrides any deny wins,
+// and with CombinePermitOverrides any permit wins.
+const (
+	CombineFirstApplicable = "first-applicable"
+	CombineDenyOverrides   = "deny-overrides"
+	CombinePermitOverrides = "permit-overrides"
+)
+
+// Guard point errno modes. With ErrnoModeEACCES, the default, every
+// refusal by policy is EACCES; with ErrnoModeReason the errno depends on
+// the reason: EPERM for a deny rule, EKEYREJECTED when the key is
+// unavailable and EACCES otherwise.
+const (
+	ErrnoModeEACCES = "eacces"
+	ErrnoModeReason = "reason"
+)
+
+// Guard point access modes.
+const (
+	AccessModeReadWrite  = "read_write"
+	AccessModeReadOnly   = "read_only"
+	AccessModeAppendOnly = "append_only"
+	AccessModeWORM       = "worm"
+)
+
+// MountOptions tunes the FUSE mount of a guard point. Unset fields keep the
+// agent defaults: allow_other and async reads on, everything else off.
+type MountOptions struct {
+	AllowOther         *bool `json:"allow_other,omitempty"`
+	DefaultPermissions bool  `json:"default_permissions"`
+	MaxWrite           int   `json:"max_write"`
+	MaxReadahead       int   `json:"max_readahead"`
+	DirectIO           bool  `json:"direct_io"`
+	WritebackCache     bool  `json:"writeback_cache"`
+	AsyncRead          *bool `json:"async_read,omitempty"`
+	ReadOnly           bool  `json:"ro"`
+	Debug              bool  `json:"debug"`
+}
+
+// GuardPointQuota limits how much secure storage a guard point may consume,
+// in total and per user. A zero limit means unlimited.
+type GuardPointQuota struct {
+	MaxBytes  int64       `json:"max_bytes"`
+	MaxInodes int64       `json:"max_inodes"`
+	Users     []UserQuota `json:"users,omitempty"`
+}
+
+// UserQuota limits the files owned by a single UID, or by all members of a
+// user set combined.
+type UserQuota struct {
+	UID       *int   `json:"uid,omitempty"`
+	UserSet   string `json:"user_set,omitempty"`
+	MaxBytes  int64  `json:"max_bytes"`
+	MaxInodes int64  `json:"max_inodes"`
 }
 
 type Policy struct {
@@ -102,8 +405,19 @@ type Policy struct {
 	PolicyType    string         `json:"policy_type"`
 	Description   string         `json:"description"`
 	SecurityRules []SecurityRule `json:"security_rules"`
+
+	// Mode is PolicyModeEnforce (the default) or PolicyModeLearn.
+	Mode string `json:"mode,omitempty"`
 }
 
+// Policy enforcement modes. In learn mode decisions are computed as usual
+// but denials are only audited, never enforced. Learn mode on either the
+// guard point or its policy applies.
+const (
+	PolicyModeEnforce = "enforce"
+	PolicyModeLearn   = "learn"
+)
+
 type SecurityRule struct {
 	ID          string      `json:"id"`
 	Order       int         `json:"order"`
@@ -113,6 +427,9 @@ type SecurityRule struct {
 	Action      []string    `json:"action"`
 	Browsing    bool        `json:"browsing"`
 	Effect      RuleEffect  `json:"effect"`
+
+	// Schedule, if set, limits when the rule applies
+	Schedule *RuleSchedule `json:"schedule,omitempty"`
 }
 
 type RuleEffect struct {
@@ -123,4 +440,34 @@ type RuleEffect struct {
 type EffectOption struct {
 	ApplyKey bool `json:"apply_key"`
 	Audit    bool `json:"audit"`
+
+	// View selects what a permitted read returns. When empty it follows
+	// apply_key: plaintext if set, ciphertext otherwise.
+	View string `json:"view,omitempty"`
+}
+
+// Read views of a permit rule. Every view reports the file size its reads
+// return: the plaintext size, except for ciphertext which is the stored
+// size including the encryption overhead.
+const (
+	// Decrypted content
+	ViewPlaintext = "plaintext"
+	// The stored bytes, nonce and tag included, e.g. for backups
+	ViewCiphertext = "ciphertext"
+	// Decrypted content with letters and digits replaced by '*', keeping
+	// whitespace and punctuation so the layout stays recognisable
+	ViewMasked = "masked"
+	// Zero bytes, as many as the plaintext has
+	ViewZeros = "zeros"
+)
+
+// ReadView returns the view of the effect, defaulting from apply_key.
+func (o *EffectOption) ReadView() string {
+	if o.View != "" {
+		return o.View
+	}
+	if o.ApplyKey {
+		return ViewPlaintext
+	}
+	return ViewCiphertext
 }
\ No newline at end of file
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadView(t *testing.T) {
+	tests := []struct {
+		option EffectOption
+		want   string
+	}{
+		{EffectOption{ApplyKey: true}, ViewPlaintext},
+		{EffectOption{}, ViewCiphertext},
+		{EffectOption{Audit: true}, ViewCiphertext},
+		{EffectOption{ApplyKey: true, View: ViewMasked}, ViewMasked},
+		{EffectOption{View: ViewZeros}, ViewZeros},
+		{EffectOption{ApplyKey: true, View: ViewCiphertext}, ViewCiphertext},
+		{EffectOption{View: ViewPlaintext}, ViewPlaintext},
+	}
+	for _, tt := range tests {
+		if got := tt.option.ReadView(); got != tt.want {
+			t.Errorf("%+v.ReadView() = %q, want %q", tt.option, got, tt.want)
+		}
+	}
+}
+
+func TestConfigCopy(t *testing.T) {
+	min := 1000
+	size := int64(10)
+	original := &Config{
+		Version: Version2,
+		UserSets: []UserSet{{
+			Code:  "us",
+			Users: []User{{UID: 1, UIDMin: &min, Container: &ContainerMatch{Image: "mysql:*"}}},
+			LDAP:  &LDAPSource{URL: "ldaps://dc1", Groups: []string{"cn=g"}},
+		}},
+		ResourceSets: []ResourceSet{{
+			Code:         "rs",
+			ResourceList: []Resource{{Directory: "/hr", MinSize: &size, Xattrs: map[string]string{"user.class": "secret"}}},
+		}},
+		GuardPoints: []GuardPoint{{Code: "gp", Policies: []string{"p"}, Quota: &GuardPointQuota{MaxBytes: 1}}},
+		Policies: []Policy{{Code: "p", SecurityRules: []SecurityRule{{
+			ID:       "r",
+			Schedule: &RuleSchedule{Windows: []TimeWindow{{Days: []string{"mon"}, Start: "09:00", End: "17:00"}}},
+		}}}},
+	}
+	copied := original.Copy()
+
+	copied.Version = Version1
+	*copied.UserSets[0].Users[0].UIDMin = 5
+	copied.UserSets[0].Users[0].Container.Image = "redis"
+	copied.UserSets[0].LDAP.Groups[0] = "cn=other"
+	*copied.ResourceSets[0].ResourceList[0].MinSize = 0
+	copied.ResourceSets[0].ResourceList[0].Xattrs["user.class"] = "public"
+	copied.GuardPoints[0].Policies[0] = "q"
+	copied.GuardPoints[0].Quota.MaxBytes = 2
+	copied.Policies[0].SecurityRules[0].Schedule.Windows[0].Days[0] = "tue"
+
+	if original.Version != Version2 ||
+		min != 1000 ||
+		original.UserSets[0].Users[0].Container.Image != "mysql:*" ||
+		original.UserSets[0].LDAP.Groups[0] != "cn=g" ||
+		size != 10 ||
+		original.ResourceSets[0].ResourceList[0].Xattrs["user.class"] != "secret" ||
+		original.GuardPoints[0].Policies[0] != "p" ||
+		original.GuardPoints[0].Quota.MaxBytes != 1 ||
+		original.Policies[0].SecurityRules[0].Schedule.Windows[0].Days[0] != "mon" {
+		t.Errorf("changing the copy changed the original: %+v", original)
+	}
+}
+
+func TestGuardPointCopy(t *testing.T) {
+	uid, on := 1000, true
+	original := &GuardPoint{
+		Code:         "gp",
+		Policies:     []string{"p", "q"},
+		Quota:        &GuardPointQuota{MaxBytes: 1, Users: []UserQuota{{UID: &uid, MaxBytes: 2}}},
+		MountOptions: &MountOptions{AllowOther: &on, AsyncRead: &on, MaxWrite: 4096},
+	}
+	copied := original.Copy()
+	// It copies as much as the JSON round trip of Config.Copy does
+	if want := (&Config{GuardPoints: []GuardPoint{*original}}).Copy().GuardPoints[0]; !reflect.DeepEqual(*copied, want) {
+		t.Errorf("Copy() = %+v, want %+v", copied, want)
+	}
+
+	copied.Policies[0] = "r"
+	copied.Quota.MaxBytes = 3
+	*copied.Quota.Users[0].UID = 5
+	copied.Quota.Users[0].MaxBytes = 4
+	*copied.MountOptions.AllowOther = false
+	*copied.MountOptions.AsyncRead = false
+	copied.MountOptions.MaxWrite = 0
+
+	if original.Policies[0] != "p" ||
+		original.Quota.MaxBytes != 1 ||
+		uid != 1000 ||
+		original.Quota.Users[0].MaxBytes != 2 ||
+		!on ||
+		original.MountOptions.MaxWrite != 4096 {
+		t.Errorf("changing the copy changed the original: %+v", original)
+	}
+}
@@ -0,0 +1,165 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Identity is where a process runs: its cgroup, namespaces and, if it is
+// in a container, the container and the caller's IDs inside it.
+type Identity struct {
+	Cgroup string
+
+	PIDNamespace   uint64
+	UserNamespace  uint64
+	MountNamespace uint64
+
+	// Container fields are empty for processes outside containers
+	ID      string
+	Runtime string
+	PodUID  string
+	Image   string
+
+	// IDs of the caller translated into its user namespace, -1 if
+	// unmapped
+	UID    int
+	GID    int
+	Groups []int
+}
+
+// InContainer reports whether the process was found to run in a container.
+func (id *Identity) InContainer() bool {
+	return id.ID != ""
+}
+
+// Key renders the identity in a canonical form for cache keys.
+func (id *Identity) Key() string {
+	return fmt.Sprintf("%s|%d|%d|%d|%s|%s|%d|%d|%v", id.Cgroup, id.PIDNamespace, id.UserNamespace, id.MountNamespace, id.ID, id.Image, id.UID, id.GID, id.Groups)
+}
+
+var (
+	containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+	podUIDPattern      = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// readCgroup returns the cgroup path of a process.
+func readCgroup(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", fmt.Errorf("failed to read cgroup: %w", err)
+	}
+	defer f.Close()
+
+	cgroup, err := cgroupPath(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cgroup: %w", err)
+	}
+	return cgroup, nil
+}
+
+// cgroupPath picks the cgroup path out of a /proc/<pid>/cgroup file: the
+// unified (v2) hierarchy if present, otherwise the first v1 hierarchy with
+// a path.
+func cgroupPath(r io.Reader) (string, error) {
+	var fallback string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2], nil
+		}
+		if fallback == "" && parts[2] != "/" {
+			fallback = parts[2]
+		}
+	}
+	return fallback, scanner.Err()
+}
+
+// parseCgroup extracts the container ID, runtime and Kubernetes pod UID
+// from a cgroup path such as
+//
+//	/system.slice/docker-<id>.scope
+//	/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/cri-containerd-<id>.scope
+func parseCgroup(cgroup string) (id, runtime, podUID string) {
+	ids := containerIDPattern.FindAllString(cgroup, -1)
+	if len(ids) == 0 {
+		return "", "", ""
+	}
+	id = ids[len(ids)-1]
+
+	switch {
+	case strings.Contains(cgroup, "cri-containerd"):
+		runtime = "containerd"
+	case strings.Contains(cgroup, "crio"):
+		runtime = "cri-o"
+	case strings.Contains(cgroup, "libpod"):
+		runtime = "podman"
+	case strings.Contains(cgroup, "docker"):
+		runtime = "docker"
+	case strings.Contains(cgroup, "containerd"):
+		runtime = "containerd"
+	}
+
+	if m := podUIDPattern.FindStringSubmatch(cgroup); m != nil {
+		podUID = strings.ReplaceAll(m[1], "_", "-")
+	}
+	return id, runtime, podUID
+}
+
+// namespaceID returns the inode number identifying one of a process's
+// namespaces. proc is a PID or "self".
+func namespaceID(proc, kind string) (uint64, error) {
+	link, err := os.Readlink(fmt.Sprintf("/proc/%s/ns/%s", proc, kind))
+	if err != nil {
+		return 0, err
+	}
+	return parseNamespaceLink(link)
+}
+
+// parseNamespaceLink returns the inode number in a namespace link such as
+// "pid:[4026531836]".
+func parseNamespaceLink(link string) (uint64, error) {
+	start := strings.IndexByte(link, '[')
+	end := strings.IndexByte(link, ']')
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("malformed namespace link %s", link)
+	}
+	return strconv.ParseUint(link[start+1:end], 10, 64)
+}
+
+// translateID maps an ID in the agent's user namespace into the process's
+// user namespace using its uid_map or gid_map, returning -1 if unmapped.
+func translateID(pid int, mapFile string, id int) int {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/%s", pid, mapFile))
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	return mapID(f, id)
+}
+
+// mapID maps id through the lines of a uid_map or gid_map, returning -1
+// if no line covers it.
+func mapID(r io.Reader, id int) int {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// ID-inside-ns ID-outside-ns length
+		var inside, outside, length int
+		if _, err := fmt.Sscan(scanner.Text(), &inside, &outside, &length); err != nil {
+			continue
+		}
+		if id >= outside && id < outside+length {
+			return inside + id - outside
+		}
+	}
+	return -1
+}
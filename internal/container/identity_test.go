@@ -0,0 +1,144 @@
+package container
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCgroupPath(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"unified", "0::/system.slice/docker-abc.scope\n", "/system.slice/docker-abc.scope"},
+		{"hybrid", "12:memory:/docker/abc\n1:name=systemd:/docker/abc\n0::/docker/abc\n", "/docker/abc"},
+		{"v1", "12:cpuset:/\n11:memory:/docker/abc\n10:pids:/docker/def\n", "/docker/abc"},
+		{"root only", "12:cpuset:/\n", ""},
+		{"malformed lines", "garbage\n\n0::/init.scope\n", "/init.scope"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cgroupPath(strings.NewReader(tt.file))
+			if err != nil || got != tt.want {
+				t.Errorf("cgroupPath = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	id := strings.Repeat("0123456789abcdef", 4)
+	other := strings.Repeat("fedcba9876543210", 4)
+	tests := []struct {
+		cgroup  string
+		id      string
+		runtime string
+		podUID  string
+	}{
+		{"/system.slice/docker-" + id + ".scope", id, "docker", ""},
+		{"/docker/" + id, id, "docker", ""},
+		{"/machine.slice/libpod-" + id + ".scope", id, "podman", ""},
+		{"/system.slice/containerd.service/" + id, id, "containerd", ""},
+		{
+			"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234abcd_5678_90ab_cdef_1234567890ab.slice/cri-containerd-" + id + ".scope",
+			id, "containerd", "1234abcd-5678-90ab-cdef-1234567890ab",
+		},
+		{
+			"/kubepods/besteffort/pod1234abcd-5678-90ab-cdef-1234567890ab/crio-" + id,
+			id, "cri-o", "1234abcd-5678-90ab-cdef-1234567890ab",
+		},
+		// Nested containers report the innermost
+		{"/docker/" + other + "/docker/" + id, id, "docker", ""},
+		{"/user.slice/user-1000.slice/session-2.scope", "", "", ""},
+		{"/docker/0123456789abcdef", "", "", ""},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		id, runtime, podUID := parseCgroup(tt.cgroup)
+		if id != tt.id || runtime != tt.runtime || podUID != tt.podUID {
+			t.Errorf("parseCgroup(%q) = %q, %q, %q; want %q, %q, %q", tt.cgroup, id, runtime, podUID, tt.id, tt.runtime, tt.podUID)
+		}
+	}
+}
+
+func TestParseNamespaceLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want uint64
+		ok   bool
+	}{
+		{"pid:[4026531836]", 4026531836, true},
+		{"user:[4026531837]", 4026531837, true},
+		{"mnt:[0]", 0, true},
+		{"pid:4026531836", 0, false},
+		{"pid:]4026531836[", 0, false},
+		{"pid:[]", 0, false},
+		{"pid:[-1]", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseNamespaceLink(tt.link)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseNamespaceLink(%q) = %d, %v; want %d, ok %v", tt.link, got, err, tt.want, tt.ok)
+		}
+	}
+
+	// The agent's own namespaces always resolve
+	for _, kind := range []string{"pid", "user", "mnt"} {
+		if id, err := namespaceID("self", kind); err != nil || id == 0 {
+			t.Errorf("namespaceID(self, %s) = %d, %v", kind, id, err)
+		}
+	}
+}
+
+func TestMapID(t *testing.T) {
+	// A rootless container: root inside is 1000 outside, and 1..65536
+	// inside are 100000.. outside
+	const idMap = "         0       1000          1\n         1     100000      65536\n"
+	tests := []struct {
+		id   int
+		want int
+	}{
+		{1000, 0},
+		{100000, 1},
+		{100041, 42},
+		{165535, 65536},
+		{165536, -1},
+		{999, -1},
+		{0, -1},
+	}
+	for _, tt := range tests {
+		if got := mapID(strings.NewReader(idMap), tt.id); got != tt.want {
+			t.Errorf("mapID(%d) = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+	if got := mapID(strings.NewReader("garbage\n"), 0); got != -1 {
+		t.Errorf("mapID over a malformed map = %d, want -1", got)
+	}
+	if got := translateID(-1, "uid_map", 0); got != -1 {
+		t.Errorf("translateID for a missing process = %d, want -1", got)
+	}
+}
+
+func TestResolveOutsideContainer(t *testing.T) {
+	cgroup, err := readCgroup(os.Getpid())
+	if err != nil {
+		t.Skipf("cannot read own cgroup: %v", err)
+	}
+	if id, _, _ := parseCgroup(cgroup); id != "" {
+		t.Skipf("the tests run in container %s", id)
+	}
+	id, err := NewResolver("").Resolve(os.Getpid(), 1000, 1000, []int{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The agent's own user namespace needs no translation
+	if id.InContainer() || id.UID != 1000 || id.GID != 1000 || len(id.Groups) != 1 || id.Groups[0] != 10 {
+		t.Errorf("Resolve = %+v, want no container and untranslated IDs", id)
+	}
+	if id.Cgroup != cgroup || id.PIDNamespace == 0 || id.UserNamespace == 0 || id.MountNamespace == 0 {
+		t.Errorf("Resolve = %+v, want cgroup %s and namespaces", id, cgroup)
+	}
+}
@@ -0,0 +1,149 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	imageLookupTimeout = 2 * time.Second
+	// failed image lookups are retried after this long
+	imageRetryInterval = 30 * time.Second
+	// maxImageCacheEntries bounds the image cache; it is emptied when full
+	maxImageCacheEntries = 4096
+)
+
+// Resolver works out the container identity of processes. Image names
+// come from the container runtime's API, which is only queried if a
+// socket is configured.
+type Resolver struct {
+	socketPath string
+	client     *http.Client
+
+	mu     sync.Mutex
+	images map[string]imageEntry
+}
+
+type imageEntry struct {
+	image    string
+	err      error
+	resolved time.Time
+}
+
+// NewResolver creates a resolver that looks up images through the Docker
+// Engine API on socketPath (also served by Podman), or never looks up
+// images if socketPath is empty.
+func NewResolver(socketPath string) *Resolver {
+	r := &Resolver{
+		socketPath: socketPath,
+		images:     make(map[string]imageEntry),
+	}
+	if socketPath != "" {
+		r.client = &http.Client{
+			Timeout: imageLookupTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}
+	}
+	return r
+}
+
+// Resolve returns the identity of process pid, whose FUSE caller
+// credentials are uid, gid and supplementary groups.
+func (r *Resolver) Resolve(pid, uid, gid int, groups []int) (*Identity, error) {
+	cgroup, err := readCgroup(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{Cgroup: cgroup, UID: uid, GID: gid, Groups: groups}
+	id.ID, id.Runtime, id.PodUID = parseCgroup(cgroup)
+
+	proc := strconv.Itoa(pid)
+	if id.PIDNamespace, err = namespaceID(proc, "pid"); err != nil {
+		return nil, fmt.Errorf("failed to read pid namespace: %w", err)
+	}
+	if id.UserNamespace, err = namespaceID(proc, "user"); err != nil {
+		return nil, fmt.Errorf("failed to read user namespace: %w", err)
+	}
+	if id.MountNamespace, err = namespaceID(proc, "mnt"); err != nil {
+		return nil, fmt.Errorf("failed to read mount namespace: %w", err)
+	}
+
+	// FUSE reports credentials in the agent's user namespace
+	if self, err := namespaceID("self", "user"); err != nil || self != id.UserNamespace {
+		id.UID = translateID(pid, "uid_map", uid)
+		id.GID = translateID(pid, "gid_map", gid)
+		id.Groups = make([]int, len(groups))
+		for i, g := range groups {
+			id.Groups[i] = translateID(pid, "gid_map", g)
+		}
+	}
+
+	if id.InContainer() {
+		id.Image = r.image(id.ID)
+	}
+	return id, nil
+}
+
+// image returns the image of a container, or "" if unknown.
+func (r *Resolver) image(containerID string) string {
+	if r.client == nil {
+		return ""
+	}
+
+	r.mu.Lock()
+	entry, ok := r.images[containerID]
+	r.mu.Unlock()
+	if ok && (entry.err == nil || time.Since(entry.resolved) < imageRetryInterval) {
+		return entry.image
+	}
+
+	image, err := r.inspect(containerID)
+	if err != nil {
+		log.Printf("[CONTAINER] Image lookup for %.12s failed: %v", containerID, err)
+	}
+
+	r.mu.Lock()
+	if len(r.images) >= maxImageCacheEntries {
+		r.images = make(map[string]imageEntry)
+	}
+	r.images[containerID] = imageEntry{image: image, err: err, resolved: time.Now()}
+	r.mu.Unlock()
+
+	return image
+}
+
+// inspect asks the runtime for the image a container was created from.
+func (r *Resolver) inspect(containerID string) (string, error) {
+	resp, err := r.client.Get("http://runtime/containers/" + containerID + "/json")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("runtime returned %s", resp.Status)
+	}
+
+	var info struct {
+		Config struct {
+			Image string `json:"Image"`
+		} `json:"Config"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("invalid inspect response: %w", err)
+	}
+	return info.Config.Image, nil
+}
@@ -4,10 +4,33 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 )
 
+// Overhead is the number of bytes every encrypted file carries on top of its
+// plaintext: a 12-byte GCM nonce followed by a 16-byte authentication tag.
+const Overhead = 28
+
+// ErrKeyUnavailable matches errors caused by the key of a guard point not
+// being available, as opposed to the data failing to decrypt.
+var ErrKeyUnavailable = errors.New("key unavailable")
+
+// KeyError reports that the key of a guard point could not be obtained.
+type KeyError struct {
+	GuardPointID string
+	Err          error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("key for guard point %s unavailable: %v", e.GuardPointID, e.Err)
+}
+
+func (e *KeyError) Unwrap() error { return e.Err }
+
+func (e *KeyError) Is(target error) bool { return target == ErrKeyUnavailable }
+
 type Service struct {
 	keyProvider KeyProvider
 }
@@ -106,7 +129,7 @@ func (s *Service) Decrypt(ciphertext []byte) ([]byte, error) {
 func (s *Service) EncryptForGuardPoint(plaintext []byte, guardPointID string) ([]byte, error) {
 	key, err := s.keyProvider.GetKeyForGuardPoint(guardPointID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get encryption key for guard point %s: %w", guardPointID, err)
+		return nil, &KeyError{GuardPointID: guardPointID, Err: err}
 	}
 
 	block, err := aes.NewCipher(key)
@@ -131,7 +154,7 @@ func (s *Service) EncryptForGuardPoint(plaintext []byte, guardPointID string) ([
 func (s *Service) DecryptForGuardPoint(ciphertext []byte, guardPointID string) ([]byte, error) {
 	key, err := s.keyProvider.GetKeyForGuardPoint(guardPointID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get decryption key for guard point %s: %w", guardPointID, err)
+		return nil, &KeyError{GuardPointID: guardPointID, Err: err}
 	}
 
 	block, err := aes.NewCipher(key)
@@ -0,0 +1,170 @@
+package directory
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestIntegerRoundTrip(t *testing.T) {
+	values := []int64{0, 1, -1, 127, 128, -128, -129, 255, 256, 32767, 32768, 1 << 31, -(1 << 40), math.MaxInt64, math.MinInt64}
+	for _, v := range values {
+		encoded := encodeInteger(tagInteger, v)
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("readPacket(encodeInteger(%d)): %v", v, err)
+		}
+		if p.tag != tagInteger {
+			t.Errorf("tag = %#x, want %#x", p.tag, tagInteger)
+		}
+		got, err := p.integer()
+		if err != nil || got != v {
+			t.Errorf("integer() = %d, %v; want %d", got, err, v)
+		}
+	}
+}
+
+func TestIntegerEncoding(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+	}
+	for _, tt := range tests {
+		if got := encodeInteger(tagInteger, tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeInteger(%d) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 127, 128, 255, 256, 70000} {
+		value := strings.Repeat("x", size)
+		msg := encodeSequence(tagSequence,
+			encodeInteger(tagInteger, 7),
+			encodeSequence(opSearchEntry,
+				encodeString(tagOctetString, "cn=test"),
+				encodeString(tagOctetString, value),
+			),
+			encodeBoolean(true),
+		)
+		r := bufio.NewReader(bytes.NewReader(append(msg, msg...)))
+		for n := 0; n < 2; n++ {
+			p, err := readPacket(r)
+			if err != nil {
+				t.Fatalf("size %d: readPacket: %v", size, err)
+			}
+			if len(p.children) != 3 {
+				t.Fatalf("size %d: %d children, want 3", size, len(p.children))
+			}
+			entry, _ := p.child(1)
+			if entry.tag != opSearchEntry || len(entry.children) != 2 {
+				t.Fatalf("size %d: entry = %#x with %d children", size, entry.tag, len(entry.children))
+			}
+			if got := string(entry.children[1].value); got != value {
+				t.Errorf("size %d: value of %d bytes, want %d", size, len(got), size)
+			}
+			if b := p.children[2].value; len(b) != 1 || b[0] != 0xff {
+				t.Errorf("size %d: boolean = % x", size, b)
+			}
+		}
+	}
+}
+
+func TestReadPacketErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":             {},
+		"multi-byte tag":    {0x1f, 0x01, 0x00},
+		"truncated content": {0x04, 0x05, 'a', 'b'},
+		"indefinite length": {0x30, 0x80, 0x00, 0x00},
+		"oversized length":  {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"truncated child":   {0x30, 0x03, 0x04, 0x05, 'a'},
+	}
+	for name, data := range tests {
+		if _, err := readPacket(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%s: readPacket succeeded", name)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	equality := func(attr, value string) []byte {
+		return encodeSequence(classContext|constructed|3, encodeString(tagOctetString, attr), encodeString(tagOctetString, value))
+	}
+	present := func(attr string) []byte {
+		return encodeString(classContext|7, attr)
+	}
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(objectClass=*)", present("objectClass")},
+		{"(uid=alice)", equality("uid", "alice")},
+		{"  (uid=alice)  ", equality("uid", "alice")},
+		{`(cn=a\2ab\28c\29\5c)`, equality("cn", `a*b(c)\`)},
+		{"(&(objectClass=user)(uid=bob))", encodeSequence(classContext|constructed|0, equality("objectClass", "user"), equality("uid", "bob"))},
+		{"(|(uid=a)(uid=b)(uid=c))", encodeSequence(classContext|constructed|1, equality("uid", "a"), equality("uid", "b"), equality("uid", "c"))},
+		{"(!(uid=a))", encodeSequence(classContext|constructed|2, equality("uid", "a"))},
+		{"(&(|(uid=a)(uid=b))(!(cn=*)))", encodeSequence(classContext|constructed|0,
+			encodeSequence(classContext|constructed|1, equality("uid", "a"), equality("uid", "b")),
+			encodeSequence(classContext|constructed|2, present("cn")),
+		)},
+	}
+	for _, tt := range tests {
+		got, err := compileFilter(tt.filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q): %v", tt.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("compileFilter(%q) = % x, want % x", tt.filter, got, tt.want)
+		}
+		// The encoding parses back into a well-formed packet
+		if _, rest, err := splitPacket(got); err != nil || len(rest) != 0 {
+			t.Errorf("compileFilter(%q) does not parse back: %v, %d bytes left", tt.filter, err, len(rest))
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(=alice)",
+		"(uid)",
+		"(uid~=alice)",
+		"(uid>=5)",
+		"(cn=a*b)",
+		`(cn=a\2)`,
+		`(cn=\zz)`,
+		"(&)",
+		"(!(uid=a)(uid=b))",
+		"(&(uid=a)",
+	} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) succeeded", filter)
+		}
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	for _, value := range []string{"alice", "a*b", "(x)", `back\slash`, "nul\x00"} {
+		escaped := escapeFilterValue(value)
+		if strings.ContainsAny(escaped, "*()\x00") {
+			t.Errorf("escapeFilterValue(%q) = %q", value, escaped)
+		}
+		if got, err := unescapeFilterValue(escaped); err != nil || got != value {
+			t.Errorf("unescapeFilterValue(%q) = %q, %v; want %q", escaped, got, err, value)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// Directory keeps the members of LDAP-backed user sets. Members are
+// refreshed in the background and persisted to a cache file, so the agent
+// starts with the last known members when the directory is unreachable.
+type Directory struct {
+	cachePath string
+	// fetch reads the members of a source; replaceable for tests
+	fetch func(*config.LDAPSource) ([]config.User, error)
+
+	mu     sync.Mutex
+	sets   map[string]*cachedSet // by user set code
+	cancel context.CancelFunc
+}
+
+type cachedSet struct {
+	// Source is the source configuration the members were read with, so
+	// they are dropped when it changes
+	Source    string        `json:"source"`
+	FetchedAt time.Time     `json:"fetched_at"`
+	Users     []config.User `json:"users"`
+}
+
+// New returns a Directory using the cache file at cachePath, which need
+// not exist yet. An empty cachePath keeps members in memory only.
+func New(cachePath string) *Directory {
+	d := &Directory{
+		cachePath: cachePath,
+		fetch:     Fetch,
+		sets:      make(map[string]*cachedSet),
+	}
+	if cachePath == "" {
+		return d
+	}
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[DIRECTORY] Failed to read cache %s: %v", cachePath, err)
+		}
+		return d
+	}
+	if err := json.Unmarshal(data, &d.sets); err != nil {
+		log.Printf("[DIRECTORY] Ignoring unreadable cache %s: %v", cachePath, err)
+		d.sets = make(map[string]*cachedSet)
+	}
+	return d
+}
+
+func sourceKey(src *config.LDAPSource) string {
+	data, _ := json.Marshal(src)
+	return string(data)
+}
+
+// Expand returns cfg with the known members of its LDAP user sets added
+// to their users. cfg itself is not modified.
+func (d *Directory) Expand(cfg *config.Config) *config.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expanded := *cfg
+	expanded.UserSets = make([]config.UserSet, len(cfg.UserSets))
+	for i, us := range cfg.UserSets {
+		expanded.UserSets[i] = us
+		if us.LDAP == nil {
+			continue
+		}
+		set := d.sets[us.Code]
+		if set == nil || set.Source != sourceKey(us.LDAP) {
+			continue
+		}
+		users := make([]config.User, 0, len(us.Users)+len(set.Users))
+		users = append(users, us.Users...)
+		users = append(users, set.Users...)
+		expanded.UserSets[i].Users = users
+	}
+	return &expanded
+}
+
+// Status describes the members known for one LDAP user set.
+type Status struct {
+	UserSet   string    `json:"user_set"`
+	Members   int       `json:"members"`
+	FetchedAt time.Time `json:"fetched_at,omitempty"`
+}
+
+// Status reports the LDAP user sets of cfg and how fresh their members
+// are; FetchedAt is zero for sets never read.
+func (d *Directory) Status(cfg *config.Config) []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var statuses []Status
+	for _, us := range cfg.UserSets {
+		if us.LDAP == nil {
+			continue
+		}
+		status := Status{UserSet: us.Code}
+		if set := d.sets[us.Code]; set != nil && set.Source == sourceKey(us.LDAP) {
+			status.Members = len(set.Users)
+			status.FetchedAt = set.FetchedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Run starts refreshing the LDAP user sets of cfg in the background,
+// replacing the sets of a previous call, until ctx is cancelled. changed
+// is called after members of a set change.
+func (d *Directory) Run(ctx context.Context, cfg *config.Config, changed func()) {
+	d.mu.Lock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	d.cancel = cancel
+	d.mu.Unlock()
+
+	for _, us := range cfg.UserSets {
+		if us.LDAP == nil {
+			continue
+		}
+		go d.refreshLoop(ctx, us.Code, *us.LDAP, changed)
+	}
+}
+
+func (d *Directory) refreshLoop(ctx context.Context, code string, src config.LDAPSource, changed func()) {
+	interval := src.Refresh()
+
+	// Cached members younger than the interval are used as they are
+	wait := time.Duration(0)
+	d.mu.Lock()
+	if set := d.sets[code]; set != nil && set.Source == sourceKey(&src) {
+		if age := time.Since(set.FetchedAt); age >= 0 && age < interval {
+			wait = interval - age
+		}
+	}
+	d.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if d.refresh(ctx, code, &src) {
+			changed()
+		}
+		timer.Reset(interval)
+	}
+}
+
+// refresh reads the members of one user set and reports whether they
+// changed. On failure the known members are kept.
+func (d *Directory) refresh(ctx context.Context, code string, src *config.LDAPSource) bool {
+	users, err := d.fetch(src)
+	if err != nil {
+		log.Printf("[DIRECTORY] Failed to refresh user set %s from %s, keeping known members: %v", code, src.URL, err)
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The configuration was replaced while reading
+	if ctx.Err() != nil {
+		return false
+	}
+
+	key := sourceKey(src)
+	old := d.sets[code]
+	d.sets[code] = &cachedSet{Source: key, FetchedAt: time.Now(), Users: users}
+	if err := d.save(); err != nil {
+		log.Printf("[DIRECTORY] Failed to write cache %s: %v", d.cachePath, err)
+	}
+
+	if old != nil && old.Source == key && sameUsers(old.Users, users) {
+		return false
+	}
+	log.Printf("[DIRECTORY] User set %s now has %d members from %s", code, len(users), src.URL)
+	return true
+}
+
+// save writes the cache atomically. d.mu must be held.
+func (d *Directory) save() error {
+	if d.cachePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(d.sets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.cachePath), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.cachePath), ".ldap-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.cachePath)
+}
+
+func sameUsers(a, b []config.User) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].UID != b[i].UID || a[i].UName != b[i].UName {
+			return false
+		}
+	}
+	return true
+}
+
+// maxNesting bounds how deep nested groups are followed.
+const maxNesting = 8
+
+// Fetch reads the members of the groups of src, sorted by UID.
+func Fetch(src *config.LDAPSource) ([]config.User, error) {
+	c, err := dial(src.URL, src.StartTLS, src.CACert)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if src.BindDN != "" {
+		password, err := os.ReadFile(src.BindPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bind_password_file: %w", err)
+		}
+		if err := c.bind(src.BindDN, strings.TrimRight(string(password), "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &fetcher{
+		conn:     c,
+		src:      src,
+		nameAttr: src.UserNameAttribute,
+		uidAttr:  src.UIDAttribute,
+		visited:  make(map[string]bool),
+		users:    make(map[int]config.User),
+	}
+	if f.nameAttr == "" {
+		f.nameAttr = "uid"
+	}
+	if f.uidAttr == "" {
+		f.uidAttr = "uidNumber"
+	}
+	if src.IDMapping == config.IDMappingSSSD {
+		f.idRange = &idRange{min: src.IDRangeMin, max: src.IDRangeMax, size: src.IDRangeSize}
+		if f.idRange.min == 0 {
+			f.idRange.min = defaultIDRangeMin
+		}
+		if f.idRange.max == 0 {
+			f.idRange.max = defaultIDRangeMax
+		}
+		if f.idRange.size == 0 {
+			f.idRange.size = defaultIDRangeSize
+		}
+		if f.idRange.max-f.idRange.min < f.idRange.size {
+			return nil, fmt.Errorf("id range %d-%d is smaller than id_range_size %d", f.idRange.min, f.idRange.max, f.idRange.size)
+		}
+	}
+
+	for _, group := range src.Groups {
+		if err := f.group(group, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	users := make([]config.User, 0, len(f.users))
+	for _, u := range f.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UID < users[j].UID })
+	for i := range users {
+		users[i].Index = i
+	}
+	return users, nil
+}
+
+type fetcher struct {
+	conn              *conn
+	src               *config.LDAPSource
+	nameAttr, uidAttr string
+	idRange           *idRange
+
+	visited map[string]bool
+	users   map[int]config.User
+}
+
+var memberAttrs = []string{"member", "uniqueMember", "memberUid"}
+
+// group adds the members of the group entry at dn.
+func (f *fetcher) group(dn string, depth int) error {
+	key := strings.ToLower(dn)
+	if f.visited[key] {
+		return nil
+	}
+	f.visited[key] = true
+
+	e, err := f.lookup(dn)
+	if err != nil {
+		return fmt.Errorf("failed to read group %s: %w", dn, err)
+	}
+	if e == nil {
+		return fmt.Errorf("group %s not found", dn)
+	}
+	return f.members(e, depth)
+}
+
+func (f *fetcher) members(group *entry, depth int) error {
+	members, err := f.memberValues(group)
+	if err != nil {
+		return err
+	}
+
+	for _, dn := range members.dns {
+		key := strings.ToLower(dn)
+		if f.visited[key] {
+			continue
+		}
+		f.visited[key] = true
+
+		e, err := f.lookup(dn)
+		if err != nil {
+			return fmt.Errorf("failed to read member %s: %w", dn, err)
+		}
+		if e == nil {
+			log.Printf("[DIRECTORY] Member %s of %s not found, skipping", dn, group.dn)
+			continue
+		}
+		if isGroup(e) {
+			if depth+1 >= maxNesting {
+				log.Printf("[DIRECTORY] Not following group %s nested deeper than %d", dn, maxNesting)
+				continue
+			}
+			if err := f.members(e, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		f.addUser(e)
+	}
+
+	for _, name := range members.names {
+		base := f.src.UserBaseDN
+		if base == "" {
+			base = parentDN(group.dn)
+		}
+		filter := fmt.Sprintf("(%s=%s)", f.nameAttr, escapeFilterValue(name))
+		entries, err := f.conn.search(base, scopeSubtree, filter, f.userAttrs())
+		if err != nil {
+			return fmt.Errorf("failed to look up member %s of %s: %w", name, group.dn, err)
+		}
+		if len(entries) == 0 {
+			log.Printf("[DIRECTORY] Member %s of %s not found under %s, skipping", name, group.dn, base)
+			continue
+		}
+		f.addUser(entries[0])
+	}
+	return nil
+}
+
+type memberList struct {
+	dns   []string
+	names []string
+}
+
+// memberValues collects the members of group, following Active
+// Directory's ranged retrieval for groups with more members than it
+// returns at once.
+func (f *fetcher) memberValues(group *entry) (*memberList, error) {
+	list := &memberList{}
+	for attr, values := range group.attrs {
+		base, rangeEnd, ranged := parseRange(attr)
+		if !isMemberAttr(base) {
+			continue
+		}
+		for _, v := range values {
+			if strings.EqualFold(base, "memberUid") {
+				list.names = append(list.names, string(v))
+			} else {
+				list.dns = append(list.dns, string(v))
+			}
+		}
+		for ranged && rangeEnd != "*" {
+			end, err := strconv.Atoi(rangeEnd)
+			if err != nil {
+				return nil, fmt.Errorf("malformed ranged attribute %s", attr)
+			}
+			next := fmt.Sprintf("%s;range=%d-*", base, end+1)
+			entries, err := f.conn.search(group.dn, scopeBase, "(objectClass=*)", []string{next})
+			if err != nil {
+				return nil, err
+			}
+			ranged = false
+			if len(entries) == 0 {
+				break
+			}
+			for a, vs := range entries[0].attrs {
+				b, e, r := parseRange(a)
+				if !strings.EqualFold(b, base) {
+					continue
+				}
+				for _, v := range vs {
+					list.dns = append(list.dns, string(v))
+				}
+				rangeEnd, ranged = e, r
+			}
+		}
+	}
+	return list, nil
+}
+
+func isMemberAttr(attr string) bool {
+	for _, name := range memberAttrs {
+		if strings.EqualFold(attr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange splits "member;range=0-1499" into member, 1499 and true.
+func parseRange(attr string) (base, end string, ranged bool) {
+	i := strings.Index(strings.ToLower(attr), ";range=")
+	if i < 0 {
+		return attr, "", false
+	}
+	bounds := attr[i+len(";range="):]
+	dash := strings.IndexByte(bounds, '-')
+	if dash < 0 {
+		return attr[:i], "*", true
+	}
+	return attr[:i], bounds[dash+1:], true
+}
+
+func (f *fetcher) userAttrs() []string {
+	return []string{"objectClass", f.nameAttr, f.uidAttr, "objectSid", "member", "uniqueMember", "memberUid"}
+}
+
+// lookup reads the entry at dn, or nil if it does not exist.
+func (f *fetcher) lookup(dn string) (*entry, error) {
+	entries, err := f.conn.search(dn, scopeBase, "(objectClass=*)", f.userAttrs())
+	if err != nil {
+		if le, ok := err.(*ldapError); ok && le.resultCode == 32 { // noSuchObject
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return entries[0], nil
+}
+
+func isGroup(e *entry) bool {
+	for _, class := range e.get("objectClass") {
+		switch strings.ToLower(string(class)) {
+		case "group", "groupofnames", "groupofuniquenames", "posixgroup":
+			return true
+		}
+	}
+	for attr := range e.attrs {
+		base, _, _ := parseRange(attr)
+		if isMemberAttr(base) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fetcher) addUser(e *entry) {
+	name := e.first(f.nameAttr)
+	var uid int64
+	var err error
+	if f.idRange != nil {
+		sid := e.get("objectSid")
+		if len(sid) == 0 {
+			log.Printf("[DIRECTORY] Member %s has no objectSid, skipping", e.dn)
+			return
+		}
+		uid, err = f.idRange.mapSID(sid[0])
+	} else {
+		uid, err = strconv.ParseInt(e.first(f.uidAttr), 10, 32)
+	}
+	if err != nil {
+		log.Printf("[DIRECTORY] Member %s has no usable UID, skipping: %v", e.dn, err)
+		return
+	}
+	f.users[int(uid)] = config.User{
+		ID:    e.dn,
+		UID:   int(uid),
+		UName: name,
+		Type:  "ldap",
+	}
+}
+
+func parentDN(dn string) string {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return strings.TrimSpace(dn[i+1:])
+		}
+	}
+	return ""
+}
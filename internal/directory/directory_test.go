@@ -0,0 +1,176 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// stubFetch serves members from a map by the source's first group,
+// failing for groups not in it.
+type stubFetch struct {
+	members map[string][]config.User
+}
+
+func (s *stubFetch) fetch(src *config.LDAPSource) ([]config.User, error) {
+	users, ok := s.members[src.Groups[0]]
+	if !ok {
+		return nil, errors.New("directory unreachable")
+	}
+	return users, nil
+}
+
+func ldapConfig(group string) *config.Config {
+	return &config.Config{UserSets: []config.UserSet{
+		{Code: "local", Users: []config.User{{UID: 1}}},
+		{
+			Code:  "finance",
+			Users: []config.User{{UID: 500, UName: "static"}},
+			LDAP:  &config.LDAPSource{URL: "ldaps://dc1", Groups: []string{group}},
+		},
+	}}
+}
+
+func memberUIDs(cfg *config.Config, code string) []int {
+	for _, us := range cfg.UserSets {
+		if us.Code == code {
+			var uids []int
+			for _, u := range us.Users {
+				uids = append(uids, u.UID)
+			}
+			return uids
+		}
+	}
+	return nil
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDirectoryRefresh(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "ldap-cache.json")
+	stub := &stubFetch{members: map[string][]config.User{
+		"cn=finance": {{UID: 1001, UName: "alice"}, {UID: 1002, UName: "bob"}},
+	}}
+	d := New(cachePath)
+	d.fetch = stub.fetch
+	cfg := ldapConfig("cn=finance")
+	src := cfg.UserSets[1].LDAP
+	ctx := context.Background()
+
+	// Nothing known yet: only the static users
+	if got := memberUIDs(d.Expand(cfg), "finance"); !equalInts(got, []int{500}) {
+		t.Errorf("before refresh: %v", got)
+	}
+	if !d.refresh(ctx, "finance", src) {
+		t.Error("first refresh reported no change")
+	}
+	expanded := d.Expand(cfg)
+	if got := memberUIDs(expanded, "finance"); !equalInts(got, []int{500, 1001, 1002}) {
+		t.Errorf("after refresh: %v", got)
+	}
+	if got := memberUIDs(expanded, "local"); !equalInts(got, []int{1}) {
+		t.Errorf("set without ldap: %v", got)
+	}
+	if len(cfg.UserSets[1].Users) != 1 {
+		t.Error("Expand modified its argument")
+	}
+
+	if d.refresh(ctx, "finance", src) {
+		t.Error("refresh with the same members reported a change")
+	}
+	stub.members["cn=finance"] = []config.User{{UID: 1001, UName: "alice"}}
+	if !d.refresh(ctx, "finance", src) {
+		t.Error("refresh with fewer members reported no change")
+	}
+
+	// An unreachable directory keeps the known members
+	delete(stub.members, "cn=finance")
+	if d.refresh(ctx, "finance", src) {
+		t.Error("failed refresh reported a change")
+	}
+	if got := memberUIDs(d.Expand(cfg), "finance"); !equalInts(got, []int{500, 1001}) {
+		t.Errorf("after failed refresh: %v", got)
+	}
+
+	status := d.Status(cfg)
+	if len(status) != 1 || status[0].UserSet != "finance" || status[0].Members != 1 || status[0].FetchedAt.IsZero() {
+		t.Errorf("Status = %+v", status)
+	}
+
+	// A cancelled refresh, as when the configuration is replaced while
+	// reading, is discarded
+	stub.members["cn=finance"] = []config.User{{UID: 1003}}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if d.refresh(cancelled, "finance", src) {
+		t.Error("cancelled refresh reported a change")
+	}
+	if got := memberUIDs(d.Expand(cfg), "finance"); !equalInts(got, []int{500, 1001}) {
+		t.Errorf("after cancelled refresh: %v", got)
+	}
+}
+
+func TestDirectoryCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "ldap-cache.json")
+	stub := &stubFetch{members: map[string][]config.User{
+		"cn=finance": {{UID: 1001, UName: "alice"}},
+	}}
+	d := New(cachePath)
+	d.fetch = stub.fetch
+	cfg := ldapConfig("cn=finance")
+	d.refresh(context.Background(), "finance", cfg.UserSets[1].LDAP)
+
+	// A restarted agent starts with the cached members, without reading
+	// the directory
+	restarted := New(cachePath)
+	restarted.fetch = (&stubFetch{}).fetch
+	if got := memberUIDs(restarted.Expand(cfg), "finance"); !equalInts(got, []int{500, 1001}) {
+		t.Errorf("from cache: %v", got)
+	}
+
+	// Members read with a different source do not apply
+	changed := ldapConfig("cn=other")
+	if got := memberUIDs(restarted.Expand(changed), "finance"); !equalInts(got, []int{500}) {
+		t.Errorf("after source change: %v", got)
+	}
+	if status := restarted.Status(changed); len(status) != 1 || status[0].Members != 0 || !status[0].FetchedAt.IsZero() {
+		t.Errorf("Status after source change = %+v", status)
+	}
+
+	// Without a cache file members are kept in memory only
+	memory := New("")
+	memory.fetch = stub.fetch
+	memory.refresh(context.Background(), "finance", cfg.UserSets[1].LDAP)
+	if got := memberUIDs(memory.Expand(cfg), "finance"); !equalInts(got, []int{500, 1001}) {
+		t.Errorf("in memory: %v", got)
+	}
+}
+
+func TestDirectoryRun(t *testing.T) {
+	stub := &stubFetch{members: map[string][]config.User{"cn=finance": {{UID: 1001}}}}
+	d := New("")
+	d.fetch = stub.fetch
+	cfg := ldapConfig("cn=finance")
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.Run(ctx, cfg, func() { changed <- struct{}{} })
+	<-changed
+	if got := memberUIDs(d.Expand(cfg), "finance"); !equalInts(got, []int{500, 1001}) {
+		t.Errorf("after Run: %v", got)
+	}
+}
@@ -0,0 +1,108 @@
+package directory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// SSSD's algorithmic ID mapping (ldap_id_mapping = true): the domain SID
+// is hashed into one of the slices of the ID range, and a user's UID is
+// the start of its domain's slice plus the RID. Collisions between
+// domains hashing to the same slice are not resolved, as SSSD does when
+// it knows all domains, so this only matches SSSD for single-domain setups
+// or domains that do not collide.
+
+// SSSD defaults for ldap_idmap_range_min, _max and _size.
+const (
+	defaultIDRangeMin  = 200000
+	defaultIDRangeMax  = 2000200000
+	defaultIDRangeSize = 200000
+)
+
+type idRange struct {
+	min, max, size int64
+}
+
+// mapSID returns the UID SSSD assigns to the binary objectSid sid.
+func (r idRange) mapSID(sid []byte) (int64, error) {
+	domain, rid, err := parseSID(sid)
+	if err != nil {
+		return 0, err
+	}
+	if int64(rid) >= r.size {
+		return 0, fmt.Errorf("rid %d of %s is outside the first slice of size %d", rid, domain, r.size)
+	}
+	slices := (r.max - r.min) / r.size
+	slice := int64(murmurhash3([]byte(domain), 0xdeadbeef)) % slices
+	return r.min + slice*r.size + int64(rid), nil
+}
+
+// parseSID splits a binary SID into its domain part, as a string such as
+// S-1-5-21-1-2-3, and the RID.
+func parseSID(sid []byte) (string, uint32, error) {
+	if len(sid) < 8 {
+		return "", 0, fmt.Errorf("sid of %d bytes is too short", len(sid))
+	}
+	count := int(sid[1])
+	if count < 2 || len(sid) != 8+4*count {
+		return "", 0, fmt.Errorf("malformed sid of %d bytes with %d sub-authorities", len(sid), count)
+	}
+	var authority uint64
+	for _, b := range sid[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "S-%d-%d", sid[0], authority)
+	for i := 0; i < count-1; i++ {
+		fmt.Fprintf(&b, "-%d", binary.LittleEndian.Uint32(sid[8+4*i:]))
+	}
+	rid := binary.LittleEndian.Uint32(sid[8+4*(count-1):])
+	return b.String(), rid, nil
+}
+
+// murmurhash3 is MurmurHash3_x86_32, the hash SSSD uses for slices.
+func murmurhash3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	h := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint32(data[4*i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[4*n:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,89 @@
+package directory
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestMurmurhash3(t *testing.T) {
+	// Reference vectors of MurmurHash3_x86_32
+	tests := []struct {
+		data string
+		seed uint32
+		want uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"abc", 0, 0xb3dd93fa},
+		{"hello", 0, 0x248bfa47},
+		{"Hello, world!", 1234, 0xfaf6cdb3},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+	}
+	for _, tt := range tests {
+		if got := murmurhash3([]byte(tt.data), tt.seed); got != tt.want {
+			t.Errorf("murmurhash3(%q, %#x) = %#x, want %#x", tt.data, tt.seed, got, tt.want)
+		}
+	}
+}
+
+// encodeSID builds a binary objectSid from its revision, identifier
+// authority and sub-authorities.
+func encodeSID(revision byte, authority uint64, subAuthorities ...uint32) []byte {
+	sid := []byte{revision, byte(len(subAuthorities))}
+	for shift := 40; shift >= 0; shift -= 8 {
+		sid = append(sid, byte(authority>>shift))
+	}
+	for _, sub := range subAuthorities {
+		sid = binary.LittleEndian.AppendUint32(sid, sub)
+	}
+	return sid
+}
+
+func TestParseSID(t *testing.T) {
+	domain, rid, err := parseSID(encodeSID(1, 5, 21, 3623811015, 3361044348, 30300820, 1013))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domain != "S-1-5-21-3623811015-3361044348-30300820" || rid != 1013 {
+		t.Errorf("parseSID = %s, %d", domain, rid)
+	}
+
+	for name, sid := range map[string][]byte{
+		"short":          {1, 1, 0, 0, 0, 0, 0, 5},
+		"one authority":  encodeSID(1, 5, 18),
+		"count mismatch": append(encodeSID(1, 5, 21, 1, 2), 0),
+	} {
+		if _, _, err := parseSID(sid); err == nil {
+			t.Errorf("parseSID of %s sid succeeded", name)
+		}
+	}
+}
+
+func TestMapSID(t *testing.T) {
+	defaults := idRange{min: defaultIDRangeMin, max: defaultIDRangeMax, size: defaultIDRangeSize}
+	// SSSD hashes the domain SID string with seed 0xdeadbeef and takes it
+	// modulo the number of slices, 10000 with the defaults: slice 3430 for
+	// S-1-5-21-1-2-3 and 3369 for the longer domain
+	tests := []struct {
+		name string
+		r    idRange
+		sid  []byte
+		want int64
+	}{
+		{"defaults", defaults, encodeSID(1, 5, 21, 1, 2, 3, 1105), 686201105},
+		{"administrator", defaults, encodeSID(1, 5, 21, 3623811015, 3361044348, 30300820, 500), 674000500},
+		{"same domain, same slice", defaults, encodeSID(1, 5, 21, 3623811015, 3361044348, 30300820, 1013), 674001013},
+		{"single slice", idRange{min: 10000, max: 20000, size: 10000}, encodeSID(1, 5, 21, 1, 2, 3, 42), 10042},
+	}
+	for _, tt := range tests {
+		got, err := tt.r.mapSID(tt.sid)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: mapSID = %d, %v; want %d", tt.name, got, err, tt.want)
+		}
+	}
+
+	// RIDs past the first slice would need SSSD's secondary slices
+	if _, err := defaults.mapSID(encodeSID(1, 5, 21, 1, 2, 3, defaultIDRangeSize)); err == nil {
+		t.Error("mapSID of a RID outside the slice succeeded")
+	}
+}
@@ -0,0 +1,419 @@
+package directory
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// A minimal LDAPv3 client (RFC 4511): simple bind, StartTLS and search,
+// one operation at a time.
+
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+
+	scopeBase    = 0
+	scopeSubtree = 2
+
+	oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+	resultSuccess = 0
+)
+
+const operationTimeout = 30 * time.Second
+
+// ldapError is a non-success result from the server.
+type ldapError struct {
+	op         string
+	resultCode int64
+	message    string
+}
+
+func (e *ldapError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("ldap %s failed: result code %d", e.op, e.resultCode)
+	}
+	return fmt.Sprintf("ldap %s failed: result code %d: %s", e.op, e.resultCode, e.message)
+}
+
+// entry is a search result. Attribute names are as the server returned
+// them; values are raw, so binary attributes such as objectSid survive.
+type entry struct {
+	dn    string
+	attrs map[string][][]byte
+}
+
+// get returns the values of attribute name, compared case-insensitively.
+func (e *entry) get(name string) [][]byte {
+	for attr, values := range e.attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func (e *entry) first(name string) string {
+	if values := e.get(name); len(values) > 0 {
+		return string(values[0])
+	}
+	return ""
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	nextID  int64
+}
+
+// dial connects to rawURL (ldap:// or ldaps://), upgrading with StartTLS
+// if asked. caCert is a PEM file of trusted CAs; the system roots are
+// used if it is empty.
+func dial(rawURL string, startTLS bool, caCert string) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url %s: %w", rawURL, err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in ca_cert %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	host := u.Host
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		nc, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{netConn: nc, reader: bufio.NewReader(nc)}
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(tlsConfig); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *conn) close() error {
+	c.nextID++
+	c.netConn.SetDeadline(time.Now().Add(time.Second))
+	c.netConn.Write(encodeSequence(tagSequence, encodeInteger(tagInteger, c.nextID), encode(opUnbindRequest, nil)))
+	return c.netConn.Close()
+}
+
+// request sends op and returns the responses up to and including the one
+// tagged done.
+func (c *conn) request(op []byte, done byte) ([]*packet, error) {
+	c.nextID++
+	id := c.nextID
+	c.netConn.SetDeadline(time.Now().Add(operationTimeout))
+	if _, err := c.netConn.Write(encodeSequence(tagSequence, encodeInteger(tagInteger, id), op)); err != nil {
+		return nil, err
+	}
+
+	var responses []*packet
+	for {
+		msg, err := readPacket(c.reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ldap response: %w", err)
+		}
+		idPacket, err := msg.child(0)
+		if err != nil {
+			return nil, err
+		}
+		msgID, err := idPacket.integer()
+		if err != nil {
+			return nil, err
+		}
+		response, err := msg.child(1)
+		if err != nil {
+			return nil, err
+		}
+		// Unsolicited notifications (ID 0) such as notice of disconnection
+		if msgID == 0 {
+			return nil, fmt.Errorf("ldap server ended the session")
+		}
+		if msgID != id {
+			continue
+		}
+		responses = append(responses, response)
+		if response.tag == done {
+			return responses, nil
+		}
+	}
+}
+
+// checkResult reads an LDAPResult.
+func checkResult(op string, response *packet) error {
+	code, err := response.child(0)
+	if err != nil {
+		return err
+	}
+	resultCode, err := code.integer()
+	if err != nil {
+		return err
+	}
+	if resultCode == resultSuccess {
+		return nil
+	}
+	message := ""
+	if len(response.children) > 2 {
+		message = string(response.children[2].value)
+	}
+	return &ldapError{op: op, resultCode: resultCode, message: message}
+}
+
+func (c *conn) startTLS(config *tls.Config) error {
+	op := encodeSequence(opExtendedRequest, encodeString(classContext|0, oidStartTLS))
+	responses, err := c.request(op, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := checkResult("starttls", responses[len(responses)-1]); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.netConn, config)
+	tlsConn.SetDeadline(time.Now().Add(operationTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("starttls handshake failed: %w", err)
+	}
+	c.netConn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// bind authenticates with a simple bind; an empty dn binds anonymously.
+func (c *conn) bind(dn, password string) error {
+	op := encodeSequence(opBindRequest,
+		encodeInteger(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(classContext|0, password),
+	)
+	responses, err := c.request(op, opBindResponse)
+	if err != nil {
+		return err
+	}
+	return checkResult("bind", responses[len(responses)-1])
+}
+
+func (c *conn) search(baseDN string, scope int64, filter string, attrs []string) ([]*entry, error) {
+	encodedFilter, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrList [][]byte
+	for _, attr := range attrs {
+		attrList = append(attrList, encodeString(tagOctetString, attr))
+	}
+	op := encodeSequence(opSearchRequest,
+		encodeString(tagOctetString, baseDN),
+		encodeInteger(tagEnumerated, scope),
+		encodeInteger(tagEnumerated, 0), // never dereference aliases
+		encodeInteger(tagInteger, 0),    // no size limit
+		encodeInteger(tagInteger, int64(operationTimeout/time.Second)),
+		encodeBoolean(false),
+		encodedFilter,
+		encodeSequence(tagSequence, attrList...),
+	)
+	responses, err := c.request(op, opSearchDone)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResult("search", responses[len(responses)-1]); err != nil {
+		return nil, err
+	}
+
+	var entries []*entry
+	for _, response := range responses[:len(responses)-1] {
+		if response.tag != opSearchEntry {
+			continue
+		}
+		e, err := parseEntry(response)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseEntry(response *packet) (*entry, error) {
+	dn, err := response.child(0)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := response.child(1)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{dn: string(dn.value), attrs: make(map[string][][]byte)}
+	for _, attr := range attributes.children {
+		name, err := attr.child(0)
+		if err != nil {
+			return nil, err
+		}
+		values, err := attr.child(1)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values.children {
+			e.attrs[string(name.value)] = append(e.attrs[string(name.value)], v.value)
+		}
+	}
+	return e, nil
+}
+
+// compileFilter encodes a string filter (RFC 4515) limited to &, |, !,
+// equality and presence, which is all user set lookups need.
+func compileFilter(filter string) ([]byte, error) {
+	encoded, rest, err := parseFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap filter %q: %w", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid ldap filter %q: trailing %q", filter, rest)
+	}
+	return encoded, nil
+}
+
+func parseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|', '!':
+		tag := byte(classContext | constructed | 0)
+		if s[0] == '|' {
+			tag = classContext | constructed | 1
+		} else if s[0] == '!' {
+			tag = classContext | constructed | 2
+		}
+		op := s[0]
+		s = s[1:]
+		var parts [][]byte
+		for strings.HasPrefix(s, "(") {
+			part, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("expected ) at %q", s)
+		}
+		if op == '!' && len(parts) != 1 {
+			return nil, "", fmt.Errorf("! takes exactly one filter")
+		}
+		if len(parts) == 0 {
+			return nil, "", fmt.Errorf("%c needs at least one filter", op)
+		}
+		return encodeSequence(tag, parts...), s[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("expected attr=value in %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attr, "~<>:") {
+		return nil, "", fmt.Errorf("unsupported filter item %q", item)
+	}
+	if value == "*" {
+		return encodeString(classContext|7, attr), rest, nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("substring filters are not supported: %q", item)
+	}
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return encodeSequence(classContext|constructed|3,
+		encodeString(tagOctetString, attr),
+		encodeString(tagOctetString, unescaped),
+	), rest, nil
+}
+
+func unescapeFilterValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("truncated escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// escapeFilterValue escapes a value for use in a filter.
+func escapeFilterValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,347 @@
+package directory
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeServer is an in-process LDAP responder over a fixed set of entries.
+// It answers simple binds, base searches with (objectClass=*) and subtree
+// searches with one equality filter. Like Active Directory, it returns at
+// most pageSize values of member at a time, as ranged attributes.
+type fakeServer struct {
+	listener net.Listener
+	entries  map[string]map[string][]string // by lower-case DN
+	bindDN   string
+	password string
+	pageSize int
+
+	mu       sync.Mutex
+	searches int
+}
+
+func newFakeServer(t *testing.T, entries map[string]map[string][]string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, entries: make(map[string]map[string][]string)}
+	for dn, attrs := range entries {
+		s.entries[strings.ToLower(dn)] = attrs
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		idPacket, _ := msg.child(0)
+		id, _ := idPacket.integer()
+		op, _ := msg.child(1)
+
+		reply := func(response []byte) {
+			c.Write(encodeSequence(tagSequence, encodeInteger(tagInteger, id), response))
+		}
+		switch op.tag {
+		case opBindRequest:
+			dn, password := string(op.children[1].value), string(op.children[2].value)
+			code := int64(resultSuccess)
+			if dn != s.bindDN || password != s.password {
+				code = 49 // invalidCredentials
+			}
+			reply(result(opBindResponse, code))
+		case opSearchRequest:
+			s.mu.Lock()
+			s.searches++
+			s.mu.Unlock()
+			for _, response := range s.search(op) {
+				reply(response)
+			}
+		case opUnbindRequest:
+			return
+		default:
+			reply(result(opExtendedResponse, 2)) // protocolError
+		}
+	}
+}
+
+func result(tag byte, code int64) []byte {
+	return encodeSequence(tag,
+		encodeInteger(tagEnumerated, code),
+		encodeString(tagOctetString, ""),
+		encodeString(tagOctetString, ""),
+	)
+}
+
+func (s *fakeServer) search(op *packet) [][]byte {
+	base := strings.ToLower(string(op.children[0].value))
+	scope, _ := op.children[1].integer()
+	filter := op.children[6]
+	var requested []string
+	for _, attr := range op.children[7].children {
+		requested = append(requested, string(attr.value))
+	}
+
+	var responses [][]byte
+	if scope == scopeBase {
+		attrs, ok := s.entries[base]
+		if !ok {
+			return [][]byte{result(opSearchDone, 32)} // noSuchObject
+		}
+		responses = append(responses, s.entry(base, attrs, requested))
+	} else {
+		attr, value := string(filter.children[0].value), string(filter.children[1].value)
+		for dn, attrs := range s.entries {
+			if !strings.HasSuffix(dn, ","+base) {
+				continue
+			}
+			for _, v := range lookupAttr(attrs, attr) {
+				if strings.EqualFold(v, value) {
+					responses = append(responses, s.entry(dn, attrs, requested))
+					break
+				}
+			}
+		}
+	}
+	return append(responses, result(opSearchDone, resultSuccess))
+}
+
+func lookupAttr(attrs map[string][]string, name string) []string {
+	for attr, values := range attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// entry encodes the requested attributes of an entry, paging member.
+func (s *fakeServer) entry(dn string, attrs map[string][]string, requested []string) []byte {
+	var encoded [][]byte
+	add := func(name string, values []string) {
+		var vs [][]byte
+		for _, v := range values {
+			vs = append(vs, encodeString(tagOctetString, v))
+		}
+		encoded = append(encoded, encodeSequence(tagSequence,
+			encodeString(tagOctetString, name),
+			encodeSequence(tagSet, vs...),
+		))
+	}
+	for _, name := range requested {
+		base, _, ranged := parseRange(name)
+		values := lookupAttr(attrs, base)
+		if values == nil {
+			continue
+		}
+		if !strings.EqualFold(base, "member") || s.pageSize == 0 || (!ranged && len(values) <= s.pageSize) {
+			add(name, values)
+			continue
+		}
+		start := 0
+		if ranged {
+			bounds := name[strings.Index(name, "=")+1:]
+			start, _ = strconv.Atoi(bounds[:strings.IndexByte(bounds, '-')])
+		}
+		end := start + s.pageSize
+		if end >= len(values) {
+			add(base+";range="+strconv.Itoa(start)+"-*", values[start:])
+		} else {
+			add(base+";range="+strconv.Itoa(start)+"-"+strconv.Itoa(end-1), values[start:end])
+		}
+	}
+	return encodeSequence(opSearchEntry, encodeString(tagOctetString, dn), encodeSequence(tagSequence, encoded...))
+}
+
+func person(uid, uidNumber string) map[string][]string {
+	return map[string][]string{
+		"objectClass": {"top", "posixAccount"},
+		"uid":         {uid},
+		"uidNumber":   {uidNumber},
+	}
+}
+
+func TestFetch(t *testing.T) {
+	people := func(uid string) string { return "uid=" + uid + ",ou=people,dc=example,dc=com" }
+	group := func(cn string) string { return "cn=" + cn + ",ou=groups,dc=example,dc=com" }
+
+	entries := map[string]map[string][]string{
+		people("alice"): person("alice", "1001"),
+		people("bob"):   person("bob", "1002"),
+		people("carol"): person("carol", "1003"),
+		people("dave"):  person("dave", "1004"),
+		people("erin"):  person("erin", "1005"),
+		people("nouid"): {"objectClass": {"posixAccount"}, "uid": {"nouid"}},
+		group("finance"): {
+			"objectClass": {"groupOfNames"},
+			"member":      {people("alice"), group("audit"), people("ghost"), people("nouid")},
+		},
+		// Nested, and back to finance
+		group("audit"): {
+			"objectClass": {"groupOfNames"},
+			"member":      {people("bob"), group("finance")},
+		},
+		group("posix"): {
+			"objectClass": {"posixGroup"},
+			"memberUid":   {"carol", "nobody"},
+		},
+		group("large"): {
+			"objectClass": {"group"},
+			"member":      {people("alice"), people("bob"), people("carol"), people("dave"), people("erin")},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   []int
+	}{
+		{"nested member groups", []string{group("finance")}, []int{1001, 1002}},
+		{"memberUid", []string{group("posix")}, []int{1003}},
+		{"ranged member", []string{group("large")}, []int{1001, 1002, 1003, 1004, 1005}},
+		{"several groups", []string{group("audit"), group("posix")}, []int{1001, 1002, 1003}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, entries)
+			server.pageSize = 2
+			users, err := Fetch(&config.LDAPSource{
+				URL:        server.url(),
+				Groups:     tt.groups,
+				UserBaseDN: "ou=people,dc=example,dc=com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var uids []int
+			for i, u := range users {
+				uids = append(uids, u.UID)
+				if u.Index != i || u.Type != "ldap" || u.UName == "" {
+					t.Errorf("user %d = %+v", i, u)
+				}
+			}
+			if !reflect.DeepEqual(uids, tt.want) {
+				t.Errorf("UIDs = %v, want %v", uids, tt.want)
+			}
+		})
+	}
+
+	t.Run("ranged member searches", func(t *testing.T) {
+		server := newFakeServer(t, entries)
+		server.pageSize = 2
+		if _, err := Fetch(&config.LDAPSource{URL: server.url(), Groups: []string{group("large")}}); err != nil {
+			t.Fatal(err)
+		}
+		// The group, two more pages of members, then the five users
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if server.searches != 8 {
+			t.Errorf("%d searches, want 8", server.searches)
+		}
+	})
+
+	t.Run("memberUid under the group's parent", func(t *testing.T) {
+		server := newFakeServer(t, map[string]map[string][]string{
+			"cn=posix,dc=example,dc=com":           {"objectClass": {"posixGroup"}, "memberUid": {"alice"}},
+			"uid=alice,dc=example,dc=com":          person("alice", "2001"),
+			"uid=alice,ou=other,dc=elsewhere,dc=x": person("alice", "3001"),
+		})
+		users, err := Fetch(&config.LDAPSource{URL: server.url(), Groups: []string{"cn=posix,dc=example,dc=com"}})
+		if err != nil || len(users) != 1 || users[0].UID != 2001 {
+			t.Errorf("Fetch = %+v, %v", users, err)
+		}
+	})
+
+	t.Run("missing group", func(t *testing.T) {
+		server := newFakeServer(t, entries)
+		if _, err := Fetch(&config.LDAPSource{URL: server.url(), Groups: []string{group("nope")}}); err == nil {
+			t.Error("Fetch of a missing group succeeded")
+		}
+	})
+}
+
+func TestFetchBind(t *testing.T) {
+	server := newFakeServer(t, map[string]map[string][]string{
+		"cn=g,dc=example,dc=com":  {"objectClass": {"groupOfNames"}, "member": {"uid=a,dc=example,dc=com"}},
+		"uid=a,dc=example,dc=com": person("a", "1001"),
+	})
+	server.bindDN, server.password = "cn=svc,dc=example,dc=com", "s3cret"
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	src := &config.LDAPSource{
+		URL:              server.url(),
+		BindDN:           server.bindDN,
+		BindPasswordFile: passwordFile,
+		Groups:           []string{"cn=g,dc=example,dc=com"},
+	}
+
+	// A trailing newline, as editors leave it, is not part of the password
+	os.WriteFile(passwordFile, []byte("s3cret\n"), 0600)
+	if users, err := Fetch(src); err != nil || len(users) != 1 {
+		t.Errorf("Fetch = %+v, %v", users, err)
+	}
+
+	os.WriteFile(passwordFile, []byte("wrong"), 0600)
+	_, err := Fetch(src)
+	if le, ok := err.(*ldapError); !ok || le.op != "bind" || le.resultCode != 49 {
+		t.Errorf("Fetch with a wrong password = %v, want an invalidCredentials bind error", err)
+	}
+}
+
+func TestFetchSSSD(t *testing.T) {
+	sid := string(encodeSID(1, 5, 21, 1, 2, 3, 1105))
+	server := newFakeServer(t, map[string]map[string][]string{
+		"cn=finance,dc=corp,dc=com": {"objectClass": {"group"}, "member": {"cn=ann,dc=corp,dc=com", "cn=svc,dc=corp,dc=com"}},
+		"cn=ann,dc=corp,dc=com":     {"objectClass": {"user"}, "sAMAccountName": {"ann"}, "objectSid": {sid}},
+		// No objectSid: skipped
+		"cn=svc,dc=corp,dc=com": {"objectClass": {"user"}, "sAMAccountName": {"svc"}},
+	})
+	users, err := Fetch(&config.LDAPSource{
+		URL:               server.url(),
+		Groups:            []string{"cn=finance,dc=corp,dc=com"},
+		UserNameAttribute: "sAMAccountName",
+		IDMapping:         config.IDMappingSSSD,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].UID != 686201105 || users[0].UName != "ann" {
+		t.Errorf("Fetch = %+v, want ann as 686201105", users)
+	}
+}
@@ -2,10 +2,13 @@ package filesystem
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/takakrypt/transparent-encryption/internal/config"
@@ -16,19 +19,34 @@ import (
 type Interceptor struct {
 	policyEngine *policy.Engine
 	cryptoSvc    *crypto.Service
-	config       *config.Config
+	auditHandler AuditHandler
+	pinDecisions bool
+
+	// writeLocks serialize the read-modify-write of encrypted files,
+	// striped by backing path
+	writeLocks [64]sync.Mutex
 }
 
+// AuditHandler receives audit events raised outside the policy decision
+// itself, such as quota violations detected by the FUSE layer.
+type AuditHandler func(event *AuditEvent, message string)
+
 type FileOperation struct {
-	Type     string
-	Path     string
-	Data     []byte
-	Mode     os.FileMode
-	Flags    int
-	UID      int
-	GID      int
-	PID      int
-	Binary   string
+	Type   string
+	Path   string
+	Data   []byte
+	Mode   os.FileMode
+	Flags  int
+	UID    int
+	GID    int
+	PID    int
+	Binary string
+	// Offset is the plaintext offset of a write
+	Offset int64
+
+	// Decision, when set, is a decision pinned to an open file handle and
+	// is used instead of evaluating the policy again.
+	Decision *policy.AccessResult
 }
 
 type OperationResult struct {
@@ -37,6 +55,11 @@ type OperationResult struct {
 	Encrypted  bool
 	Error      error
 	AuditEvent *AuditEvent
+	Decision   *policy.AccessResult
+
+	// Reason says why the operation was allowed or refused; see the
+	// policy Reason constants
+	Reason string
 }
 
 type AuditEvent struct {
@@ -48,27 +71,74 @@ type AuditEvent struct {
 	RuleID     string
 	Success    bool
 	Timestamp  int64
+	Reason     string
+	// Policy is the policy RuleID belongs to
+	Policy string
+
+	// Set on learn mode events: the caller's group, the policy action and
+	// that the access was permitted only because of learn mode
+	Group   int
+	Action  string
+	Learned bool
 }
 
-func NewInterceptor(policyEngine *policy.Engine, cryptoSvc *crypto.Service, cfg *config.Config) *Interceptor {
+func NewInterceptor(policyEngine *policy.Engine, cryptoSvc *crypto.Service) *Interceptor {
 	return &Interceptor{
 		policyEngine: policyEngine,
 		cryptoSvc:    cryptoSvc,
-		config:       cfg,
 	}
 }
 
-func (i *Interceptor) InterceptOpen(ctx context.Context, op *FileOperation) (*OperationResult, error) {
-	req := &policy.AccessRequest{
+// SetAuditHandler installs the sink used by Audit.
+func (i *Interceptor) SetAuditHandler(handler AuditHandler) {
+	i.auditHandler = handler
+}
+
+// SetPinDecisions makes open file handles keep the decision that opened
+// them, so reads and writes through the handle skip policy evaluation.
+func (i *Interceptor) SetPinDecisions(pin bool) {
+	i.pinDecisions = pin
+}
+
+func (i *Interceptor) PinDecisions() bool {
+	return i.pinDecisions
+}
+
+// decide returns the decision pinned to op, or evaluates the policy.
+func (i *Interceptor) decide(op *FileOperation, action string) (*policy.AccessResult, error) {
+	if op.Decision != nil {
+		return op.Decision, nil
+	}
+	return i.policyEngine.EvaluateAccess(&policy.AccessRequest{
 		Path:      op.Path,
-		Action:    "read",
+		Action:    action,
 		UID:       op.UID,
 		GID:       op.GID,
 		ProcessID: op.PID,
 		Binary:    op.Binary,
+	})
+}
+
+// GuardPoint returns the guard point protecting path in the current
+// configuration, if any.
+func (i *Interceptor) GuardPoint(path string) *config.GuardPoint {
+	return i.policyEngine.FindGuardPoint(path)
+}
+
+// Audit forwards an event to the installed audit handler, if any.
+func (i *Interceptor) Audit(event *AuditEvent, message string) {
+	if event.Timestamp == 0 {
+		event.Timestamp = getCurrentTimestamp()
 	}
+	if i.auditHandler == nil {
+		log.Printf("[AUDIT] %s %s uid=%d process=%s: %s", event.Operation, event.Path, event.User, event.Process, message)
+		return
+	}
+	i.auditHandler(event, message)
+}
 
-	result, evalErr := i.policyEngine.EvaluateAccess(req)
+func (i *Interceptor) InterceptOpen(ctx context.Context, op *FileOperation) (*OperationResult, error) {
+	result, evalErr := i.decide(op, "read")
 	if evalErr != nil {
 		return &OperationResult{
 			Allowed: false,
@@ -83,22 +153,34 @@ func (i *Interceptor) InterceptOpen(ctx context.Context, op *FileOperation) (*Op
 		Process:    op.Binary,
 		Permission: result.Permission,
 		RuleID:     result.RuleID,
+		Policy:     result.Policy,
+		Reason:     result.Reason,
 		Success:    result.Permission == "permit",
 		Timestamp:  getCurrentTimestamp(),
 	}
 
 	if result.Permission != "permit" {
+		i.auditDenial(op, auditEvent, result)
 		return &OperationResult{
 			Allowed:    false,
 			AuditEvent: auditEvent,
 			Error:      fmt.Errorf("access denied by policy"),
+			Reason:     result.Reason,
 		}, nil
 	}
+	if result.Learned {
+		i.auditLearned(op, "read", result)
+	}
 
-	guardPoint := i.findGuardPointForPath(op.Path)
-	if guardPoint == nil || !result.ApplyKey {
+	// The ciphertext view reads the backing file as stored
+	guardPoint := enabledGuardPoint(result)
+	if guardPoint == nil || result.View == config.ViewCiphertext {
+		if result.RawAccess {
+			i.auditExemption(op, "read", result)
+		}
 		return &OperationResult{
 			Allowed:    true,
+			Decision:   result,
 			Encrypted:  false,
 			AuditEvent: auditEvent,
 		}, nil
@@ -106,14 +188,13 @@ func (i *Interceptor) InterceptOpen(ctx context.Context, op *FileOperation) (*Op
 
 	encryptedPath := i.getEncryptedPath(guardPoint, op.Path)
 	
-	// Determine if we should decrypt based on authorization and apply_key
-	shouldDecrypt := result.Permission == "permit" && result.ApplyKey
+	// Plaintext and masked views need the plaintext; zeros only its size
+	shouldDecrypt := result.View == config.ViewPlaintext || result.View == config.ViewMasked
 	
 	var data []byte
 	var err error
 	
 	if shouldDecrypt {
-		// Authorized + apply_key: true → Decrypt and return plaintext
 		log.Printf("[CRYPTO] Decrypting file for authorized user: %s", encryptedPath)
 		
 		// Check if file exists and get its size first
@@ -132,6 +213,7 @@ func (i *Interceptor) InterceptOpen(ctx context.Context, op *FileOperation) (*Op
 			return &OperationResult{
 				Data:       []byte{},
 				Allowed:    true,
+				Decision:   result,
 				Encrypted:  true,
 				AuditEvent: auditEvent,
 			}, nil
@@ -164,49 +246,55 @@ func (i *Interceptor) InterceptOpen(ctx context.Context, op *FileOperation) (*Op
 			if isEncrypted {
 				log.Printf("[CRYPTO] ERROR: File %s is encrypted but decryption failed: %v", encryptedPath, err)
 				auditEvent.Success = false
-				return &OperationResult{
+				opResult := &OperationResult{
 					Allowed:    false,
 					AuditEvent: auditEvent,
 					Error:      fmt.Errorf("file is encrypted but decryption failed: %w", err),
-				}, err
+				}
+				i.auditKeyError(op, auditEvent, opResult, err)
+				return opResult, err
 			}
 			log.Printf("[CRYPTO] File %s appears to be plain text, reading without decryption", encryptedPath)
 			data = plainData
 		}
 	} else {
-		// Authorized + apply_key: false OR Unauthorized + apply_key: true → Return raw ciphertext
-		log.Printf("[CRYPTO] Reading raw encrypted data (no decryption): %s", encryptedPath)
-		data, err = os.ReadFile(encryptedPath)
-		if err != nil {
+		log.Printf("[CRYPTO] Returning zeros in place of %s", encryptedPath)
+		fileInfo, statErr := os.Stat(encryptedPath)
+		if statErr != nil {
 			auditEvent.Success = false
 			return &OperationResult{
 				Allowed:    false,
 				AuditEvent: auditEvent,
-				Error:      fmt.Errorf("failed to read encrypted file: %w", err),
-			}, err
+				Error:      fmt.Errorf("file not found: %w", statErr),
+			}, statErr
+		}
+		// The size Getattr reports for the file
+		size := fileInfo.Size() - crypto.Overhead
+		if size < 0 {
+			size = 0
 		}
+		data = make([]byte, size)
+	}
+
+	if result.View == config.ViewMasked {
+		data = maskData(data)
 	}
 
 	return &OperationResult{
 		Data:       data,
 		Allowed:    true,
+		Decision:   result,
 		Encrypted:  true,
 		AuditEvent: auditEvent,
 	}, nil
 }
 
-func (i *Interceptor) InterceptWrite(ctx context.Context, op *FileOperation) (*OperationResult, error) {
-	log.Printf("[INTERCEPT] InterceptWrite called: path=%s, uid=%d, gid=%d, pid=%d", op.Path, op.UID, op.GID, op.PID)
-	req := &policy.AccessRequest{
-		Path:      op.Path,
-		Action:    "write",
-		UID:       op.UID,
-		GID:       op.GID,
-		ProcessID: op.PID,
-		Binary:    op.Binary,
-	}
-
-	result, err := i.policyEngine.EvaluateAccess(req)
+// AuthorizeWrite evaluates the write policy for op without writing
+// anything, auditing a denial and learn mode or exempted access. Callers
+// with checks of their own, such as quotas, authorize first and then
+// write with WriteAuthorized.
+func (i *Interceptor) AuthorizeWrite(op *FileOperation) (*OperationResult, error) {
+	result, err := i.decide(op, "write")
 	if err != nil {
 		return &OperationResult{
 			Allowed: false,
@@ -221,19 +309,49 @@ func (i *Interceptor) InterceptWrite(ctx context.Context, op *FileOperation) (*O
 		Process:    op.Binary,
 		Permission: result.Permission,
 		RuleID:     result.RuleID,
+		Policy:     result.Policy,
+		Reason:     result.Reason,
 		Success:    result.Permission == "permit",
 		Timestamp:  getCurrentTimestamp(),
 	}
 
 	if result.Permission != "permit" {
+		i.auditDenial(op, auditEvent, result)
 		return &OperationResult{
 			Allowed:    false,
 			AuditEvent: auditEvent,
 			Error:      fmt.Errorf("access denied by policy"),
+			Reason:     result.Reason,
 		}, nil
 	}
+	if result.Learned {
+		i.auditLearned(op, "write", result)
+	}
+	if result.RawAccess && enabledGuardPoint(result) != nil {
+		i.auditExemption(op, "write", result)
+	}
+	return &OperationResult{
+		Allowed:    true,
+		Decision:   result,
+		AuditEvent: auditEvent,
+	}, nil
+}
+
+func (i *Interceptor) InterceptWrite(ctx context.Context, op *FileOperation) (*OperationResult, error) {
+	log.Printf("[INTERCEPT] InterceptWrite called: path=%s, uid=%d, gid=%d, pid=%d", op.Path, op.UID, op.GID, op.PID)
+	authorized, err := i.AuthorizeWrite(op)
+	if err != nil || !authorized.Allowed {
+		return authorized, err
+	}
+	return i.WriteAuthorized(op, authorized)
+}
+
+// WriteAuthorized performs the write of op under a decision returned by
+// AuthorizeWrite, which has already audited it.
+func (i *Interceptor) WriteAuthorized(op *FileOperation, authorized *OperationResult) (*OperationResult, error) {
+	result, auditEvent := authorized.Decision, authorized.AuditEvent
 
-	guardPoint := i.findGuardPointForPath(op.Path)
+	guardPoint := enabledGuardPoint(result)
 	if guardPoint == nil {
 		// Not a guard point - write as plain text
 		log.Printf("[INTERCEPT] Writing plain file: %s", op.Path)
@@ -243,31 +361,86 @@ func (i *Interceptor) InterceptWrite(ctx context.Context, op *FileOperation) (*O
 		}
 		return &OperationResult{
 			Allowed:    true,
+			Decision:   result,
 			Encrypted:  false,
 			AuditEvent: auditEvent,
 			Error:      err,
 		}, err
 	}
 
+	if result.RawAccess {
+		// Exempted processes write ciphertext as-is; the caller writes
+		// the data to the backing file unchanged
+		return &OperationResult{
+			Allowed:    true,
+			Encrypted:  false,
+			AuditEvent: auditEvent,
+			Decision:   result,
+		}, nil
+	}
+
 	// Always encrypt when writing to guard points (regardless of apply_key)
 	encryptedPath := i.getEncryptedPath(guardPoint, op.Path)
 	log.Printf("[CRYPTO] Writing encrypted file to: %s", encryptedPath)
 	log.Printf("[CRYPTO] Using guard point ID: %s", guardPoint.ID)
 	log.Printf("[INTERCEPT] Writing encrypted file: %s -> %s", op.Path, encryptedPath)
-	err = i.encryptAndWrite(encryptedPath, op.Data, op.Mode, guardPoint.ID, op.UID, op.GID)
+	err := i.encryptAndWriteAt(encryptedPath, op.Data, op.Offset, op.Mode, guardPoint.ID, op.UID, op.GID)
 	if err != nil {
 		log.Printf("[CRYPTO] ERROR: Failed to encrypt and write file: %v", err)
 		auditEvent.Success = false
-		return &OperationResult{
+		opResult := &OperationResult{
 			Allowed:    false,
 			AuditEvent: auditEvent,
 			Error:      fmt.Errorf("failed to encrypt and write file: %w", err),
-		}, err
+		}
+		i.auditKeyError(op, auditEvent, opResult, err)
+		return opResult, err
 	}
 	log.Printf("[CRYPTO] Successfully encrypted and wrote file: %s", encryptedPath)
 
 	return &OperationResult{
 		Allowed:    true,
+		Decision:   result,
+		Encrypted:  true,
+		AuditEvent: auditEvent,
+	}, nil
+}
+
+// TruncateAuthorized resizes op.Path to size plaintext bytes under a
+// decision returned by AuthorizeWrite. Encrypted files are decrypted, cut
+// or zero-extended and encrypted again. Outside guard points and for
+// exempted processes nothing is touched and the result is not Encrypted;
+// the caller truncates the backing file itself.
+func (i *Interceptor) TruncateAuthorized(op *FileOperation, size int64, authorized *OperationResult) (*OperationResult, error) {
+	result, auditEvent := authorized.Decision, authorized.AuditEvent
+
+	guardPoint := enabledGuardPoint(result)
+	if guardPoint == nil || result.RawAccess {
+		return &OperationResult{
+			Allowed:    true,
+			Decision:   result,
+			Encrypted:  false,
+			AuditEvent: auditEvent,
+		}, nil
+	}
+
+	encryptedPath := i.getEncryptedPath(guardPoint, op.Path)
+	log.Printf("[INTERCEPT] Truncating encrypted file %s to %d bytes", encryptedPath, size)
+	if err := i.resizeEncrypted(encryptedPath, size, guardPoint.ID); err != nil {
+		log.Printf("[CRYPTO] ERROR: Failed to truncate encrypted file: %v", err)
+		auditEvent.Success = false
+		opResult := &OperationResult{
+			Allowed:    false,
+			AuditEvent: auditEvent,
+			Error:      fmt.Errorf("failed to truncate encrypted file: %w", err),
+		}
+		i.auditKeyError(op, auditEvent, opResult, err)
+		return opResult, err
+	}
+
+	return &OperationResult{
+		Allowed:    true,
+		Decision:   result,
 		Encrypted:  true,
 		AuditEvent: auditEvent,
 	}, nil
@@ -311,6 +484,8 @@ func (i *Interceptor) InterceptList(ctx context.Context, op *FileOperation) (*Op
 		Process:    op.Binary,
 		Permission: result.Permission,
 		RuleID:     result.RuleID,
+		Policy:     result.Policy,
+		Reason:     result.Reason,
 		Success:    result.Permission == "permit",
 		Timestamp:  getCurrentTimestamp(),
 	}
@@ -321,12 +496,17 @@ func (i *Interceptor) InterceptList(ctx context.Context, op *FileOperation) (*Op
 	if result.Permission != "permit" {
 		log.Printf("[INTERCEPTOR] InterceptList: ACCESS DENIED - Permission=%s, RuleID=%s", result.Permission, result.RuleID)
 		log.Printf("[INTERCEPTOR] ========== INTERCEPT LIST END (DENIED) ==========")
+		i.auditDenial(op, auditEvent, result)
 		return &OperationResult{
 			Allowed:    false,
 			AuditEvent: auditEvent,
 			Error:      fmt.Errorf("browse access denied by policy"),
+			Reason:     result.Reason,
 		}, nil
 	}
+	if result.Learned {
+		i.auditLearned(op, "browse", result)
+	}
 
 	log.Printf("[INTERCEPTOR] InterceptList: ACCESS GRANTED - Permission=%s, RuleID=%s", result.Permission, result.RuleID)
 	log.Printf("[INTERCEPTOR] ========== INTERCEPT LIST END (GRANTED) ==========")
@@ -336,59 +516,102 @@ func (i *Interceptor) InterceptList(ctx context.Context, op *FileOperation) (*Op
 	}, nil
 }
 
-func (i *Interceptor) findGuardPointForPath(path string) *config.GuardPoint {
-	log.Printf("[INTERCEPT] findGuardPointForPath: searching for path=%s", path)
-	
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		log.Printf("[INTERCEPT] findGuardPointForPath: failed to get absolute path: %v", err)
-		return nil
+// auditExemption records raw access granted through rwp_exempted_resources.
+// Operations on a pinned decision are not audited again; the decision was
+// audited when it was made.
+func (i *Interceptor) auditExemption(op *FileOperation, action string, result *policy.AccessResult) {
+	if op.Decision != nil {
+		return
 	}
-	log.Printf("[INTERCEPT] findGuardPointForPath: absolute path=%s", absPath)
-
-	var bestMatch *config.GuardPoint
-	maxDepth := -1
+	i.Audit(&AuditEvent{
+		Operation:  op.Type,
+		Path:       op.Path,
+		User:       op.UID,
+		Process:    op.Binary,
+		Permission: result.Permission,
+		RuleID:     result.RuleID,
+		Policy:     result.Policy,
+		Success:    true,
+	}, fmt.Sprintf("raw %s without encryption: process exempted for resource set %s", action, result.Exemption))
+}
 
-	for j := range i.config.GuardPoints {
-		gp := &i.config.GuardPoints[j]
-		if !gp.Enabled {
-			log.Printf("[INTERCEPT] findGuardPointForPath: skipping disabled guard point %s", gp.ID)
-			continue
-		}
+// auditDenial records a denial when the deciding rule, or default deny,
+// asks for auditing.
+func (i *Interceptor) auditDenial(op *FileOperation, event *AuditEvent, result *policy.AccessResult) {
+	if !result.Audit {
+		return
+	}
+	event.Group = op.GID
+	i.Audit(event, fmt.Sprintf("%s denied: %s", op.Type, result.Reason))
+}
 
-		absGuardPath, err := filepath.Abs(gp.ProtectedPath)
-		if err != nil {
-			log.Printf("[INTERCEPT] findGuardPointForPath: failed to get absolute guard path for %s: %v", gp.ID, err)
-			continue
-		}
+// auditKeyError marks an operation that failed because the guard point key
+// is unavailable, and audits it.
+func (i *Interceptor) auditKeyError(op *FileOperation, event *AuditEvent, opResult *OperationResult, err error) {
+	if !errors.Is(err, crypto.ErrKeyUnavailable) {
+		return
+	}
+	opResult.Reason = policy.ReasonKeyUnavailable
+	event.Reason = policy.ReasonKeyUnavailable
+	event.Group = op.GID
+	i.Audit(event, err.Error())
+}
 
-		rel, err := filepath.Rel(absGuardPath, absPath)
-		if err != nil {
-			log.Printf("[INTERCEPT] findGuardPointForPath: failed to get relative path for guard point %s: %v", gp.ID, err)
-			continue
-		}
+// auditLearned records access that a rule in learn mode would have
+// denied. Like exemptions, pinned decisions are not audited again.
+func (i *Interceptor) auditLearned(op *FileOperation, action string, result *policy.AccessResult) {
+	if op.Decision != nil {
+		return
+	}
+	i.Audit(&AuditEvent{
+		Operation:  op.Type,
+		Path:       op.Path,
+		User:       op.UID,
+		Group:      op.GID,
+		Process:    op.Binary,
+		Permission: "deny",
+		RuleID:     result.LearnedRule,
+		Policy:     result.LearnedPolicy,
+		Success:    true,
+		Action:     action,
+		Learned:    true,
+	}, fmt.Sprintf("learn mode: %s would be denied by rule %s", action, result.LearnedRule))
+}
 
-		log.Printf("[INTERCEPT] findGuardPointForPath: guard point %s - guardPath=%s, rel=%s", gp.ID, absGuardPath, rel)
+// RawAccess reports whether the caller of op reads the stored ciphertext
+// of op.Path, because of the rule's view or an exemption, and so should
+// see the stored size.
+func (i *Interceptor) RawAccess(op *FileOperation) bool {
+	if op.Decision == nil && !i.policyEngine.HasCiphertextViews() {
+		return false
+	}
+	result, err := i.decide(op, "read")
+	return err == nil && result.Permission == "permit" && result.View == config.ViewCiphertext
+}
 
-		if !filepath.IsAbs(rel) && rel != ".." && !filepath.HasPrefix(rel, "../") {
-			depth := len(filepath.SplitList(absGuardPath))
-			log.Printf("[INTERCEPT] findGuardPointForPath: guard point %s matches with depth %d", gp.ID, depth)
-			if depth > maxDepth {
-				maxDepth = depth
-				bestMatch = gp
-				log.Printf("[INTERCEPT] findGuardPointForPath: new best match: %s", gp.ID)
-			}
-		} else {
-			log.Printf("[INTERCEPT] findGuardPointForPath: guard point %s does not match (rel=%s)", gp.ID, rel)
+// maskData replaces letters and digits, and any non-ASCII byte, with '*'.
+// Whitespace and punctuation are kept, so lines, columns and field
+// separators stay where they are while the content is hidden.
+func maskData(data []byte) []byte {
+	masked := make([]byte, len(data))
+	for n, b := range data {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9', b >= 0x80:
+			masked[n] = '*'
+		default:
+			masked[n] = b
 		}
 	}
+	return masked
+}
 
-	if bestMatch != nil {
-		log.Printf("[INTERCEPT] findGuardPointForPath: FINAL MATCH - guard point %s for path %s", bestMatch.ID, path)
-	} else {
-		log.Printf("[INTERCEPT] findGuardPointForPath: NO MATCH found for path %s", path)
+// enabledGuardPoint returns the enabled guard point the decision was made
+// under, or nil for paths outside any active guard point.
+func enabledGuardPoint(result *policy.AccessResult) *config.GuardPoint {
+	if result.GuardPoint == nil || !result.GuardPoint.Enabled {
+		return nil
 	}
-	return bestMatch
+	return result.GuardPoint
 }
 
 func (i *Interceptor) getEncryptedPath(gp *config.GuardPoint, originalPath string) string {
@@ -445,6 +668,69 @@ func (i *Interceptor) encryptAndWrite(path string, data []byte, mode os.FileMode
 	return nil
 }
 
+// encryptAndWriteAt writes data at plaintext offset off of the encrypted
+// file at path: the existing contents are decrypted, data is spliced in
+// (zero-filling any gap past the end) and the result is encrypted again.
+func (i *Interceptor) encryptAndWriteAt(path string, data []byte, off int64, mode os.FileMode, guardPointID string, uid, gid int) error {
+	lock := &i.writeLocks[fnv32(path)%uint32(len(i.writeLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	var plain []byte
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		if plain, err = i.readAndDecrypt(path, guardPointID); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat encrypted file: %w", err)
+	}
+
+	if end := off + int64(len(data)); end > int64(len(plain)) {
+		plain = append(plain, make([]byte, end-int64(len(plain)))...)
+	}
+	copy(plain[off:], data)
+	return i.encryptAndWrite(path, plain, mode, guardPointID, uid, gid)
+}
+
+// resizeEncrypted sets the plaintext size of the existing encrypted file at
+// path, zero-filling when it grows.
+func (i *Interceptor) resizeEncrypted(path string, size int64, guardPointID string) error {
+	lock := &i.writeLocks[fnv32(path)%uint32(len(i.writeLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat encrypted file: %w", err)
+	}
+	var plain []byte
+	if info.Size() > 0 {
+		if plain, err = i.readAndDecrypt(path, guardPointID); err != nil {
+			return err
+		}
+	}
+
+	if size <= int64(len(plain)) {
+		plain = plain[:size]
+	} else {
+		plain = append(plain, make([]byte, size-int64(len(plain)))...)
+	}
+	encryptedData, err := i.cryptoSvc.EncryptForGuardPoint(plain, guardPointID)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	if err := os.WriteFile(path, encryptedData, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+	return nil
+}
+
+func fnv32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
 func (i *Interceptor) writeFile(path string, data []byte, mode os.FileMode, uid, gid int) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -0,0 +1,463 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testGuardPoint is a guard point over a temporary directory, keyed from a
+// keys.json in the same directory. Audit events are collected in audits.
+type testGuardPoint struct {
+	interceptor *Interceptor
+	cryptoSvc   *crypto.Service
+	protected   string
+	storage     string
+	audits      []*AuditEvent
+	messages    []string
+}
+
+// newTestGuardPoint returns a guard point whose single rule permits
+// everything with option.
+func newTestGuardPoint(t *testing.T, option config.EffectOption) *testGuardPoint {
+	t.Helper()
+	return newConfiguredGuardPoint(t, func(cfg *config.Config) {
+		cfg.Policies[0].SecurityRules[0].Effect.Option = option
+	})
+}
+
+// newConfiguredGuardPoint returns a guard point whose configuration, with
+// guard point gp-1 and policy p permitting everything, is adjusted by
+// configure.
+func newConfiguredGuardPoint(t *testing.T, configure func(cfg *config.Config)) *testGuardPoint {
+	t.Helper()
+	dir := t.TempDir()
+	gp := &testGuardPoint{
+		protected: filepath.Join(dir, "protected"),
+		storage:   filepath.Join(dir, "storage"),
+	}
+	if err := os.MkdirAll(gp.storage, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	key := bytes.Repeat([]byte{7}, 32)
+	keys, _ := json.Marshal([]crypto.KeyMetadata{{
+		ID:           "key-1",
+		Type:         "AES256-GCM",
+		GuardPointID: "gp-1",
+		KeyMaterial:  base64.StdEncoding.EncodeToString(key),
+		Status:       "active",
+	}})
+	keysFile := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(keysFile, keys, 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := crypto.NewFileKeyProvider(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gp.cryptoSvc = crypto.NewService(provider)
+
+	cfg := &config.Config{
+		GuardPoints: []config.GuardPoint{{
+			ID:                "gp-1",
+			Code:              "gp",
+			ProtectedPath:     gp.protected,
+			SecureStoragePath: gp.storage,
+			Policy:            "p",
+			Enabled:           true,
+		}},
+		Policies: []config.Policy{{
+			Code: "p",
+			SecurityRules: []config.SecurityRule{{
+				ID:     "all",
+				Order:  1,
+				Action: []string{"all_ops"},
+				Effect: config.RuleEffect{Permission: "permit"},
+			}},
+		}},
+	}
+	configure(cfg)
+	gp.interceptor = NewInterceptor(policy.NewEngine(cfg), gp.cryptoSvc)
+	gp.interceptor.SetAuditHandler(func(event *AuditEvent, message string) {
+		gp.audits = append(gp.audits, event)
+		gp.messages = append(gp.messages, message)
+	})
+	return gp
+}
+
+// store writes plaintext encrypted under name in the secure storage.
+func (gp *testGuardPoint) store(t *testing.T, name string, plaintext []byte) {
+	t.Helper()
+	data, err := gp.cryptoSvc.EncryptForGuardPoint(plaintext, "gp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(gp.storage, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// plaintext decrypts name from the secure storage.
+func (gp *testGuardPoint) plaintext(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(gp.storage, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := gp.cryptoSvc.DecryptForGuardPoint(data, "gp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func TestInterceptWriteAtOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []byte // nil for an empty backing file
+		data     string
+		offset   int64
+		want     string
+	}{
+		{name: "empty file", data: "hello", want: "hello"},
+		{name: "append", existing: []byte("hello"), data: " world", offset: 5, want: "hello world"},
+		{name: "overwrite middle", existing: []byte("hello world"), data: "W", offset: 6, want: "hello World"},
+		{name: "extend past end", existing: []byte("ab"), data: "c", offset: 4, want: "ab\x00\x00c"},
+		{name: "write at start keeps tail", existing: []byte("abcdef"), data: "XY", want: "XYcdef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := newTestGuardPoint(t, config.EffectOption{ApplyKey: true})
+			if tt.existing != nil {
+				gp.store(t, "f", tt.existing)
+			} else if err := os.WriteFile(filepath.Join(gp.storage, "f"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := gp.interceptor.InterceptWrite(context.Background(), &FileOperation{
+				Type:   "write",
+				Path:   filepath.Join(gp.protected, "f"),
+				Data:   []byte(tt.data),
+				Offset: tt.offset,
+				Mode:   0644,
+				UID:    os.Getuid(),
+				GID:    os.Getgid(),
+			})
+			if err != nil || !result.Allowed || !result.Encrypted {
+				t.Fatalf("InterceptWrite = %+v, %v", result, err)
+			}
+			if got := gp.plaintext(t, "f"); string(got) != tt.want {
+				t.Errorf("plaintext = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskData(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"hello", "*****"},
+		{"id,name\n42,Ann Lee\n", "**,****\n**,*** ***\n"},
+		{"a-b_c.d/e:f", "*-*_*.*/*:*"},
+		{"tab\tsep\r\n", "***\t***\r\n"},
+		{"café", "*****"}, // both bytes of é
+		{"\x00\x01\x7f", "\x00\x01\x7f"},
+	}
+	for _, tt := range tests {
+		if got := maskData([]byte(tt.in)); string(got) != tt.want {
+			t.Errorf("maskData(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInterceptOpenViews(t *testing.T) {
+	secret := []byte("Card 4111-1111, exp 09/27\n")
+
+	tests := []struct {
+		name      string
+		option    config.EffectOption
+		plaintext []byte
+		// want is nil when the caller reads the backing file itself
+		want      []byte
+		encrypted bool
+	}{
+		{name: "plaintext", option: config.EffectOption{ApplyKey: true}, plaintext: secret, want: secret, encrypted: true},
+		{name: "ciphertext", option: config.EffectOption{}, plaintext: secret},
+		{name: "explicit ciphertext", option: config.EffectOption{ApplyKey: true, View: config.ViewCiphertext}, plaintext: secret},
+		{name: "masked", option: config.EffectOption{View: config.ViewMasked}, plaintext: secret, want: []byte("**** ****-****, *** **/**\n"), encrypted: true},
+		{name: "zeros", option: config.EffectOption{View: config.ViewZeros}, plaintext: secret, want: make([]byte, len(secret)), encrypted: true},
+		{name: "zeros of empty plaintext", option: config.EffectOption{View: config.ViewZeros}, plaintext: []byte{}, want: []byte{}, encrypted: true},
+		{name: "masked of empty plaintext", option: config.EffectOption{View: config.ViewMasked}, plaintext: []byte{}, want: []byte{}, encrypted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := newTestGuardPoint(t, tt.option)
+			gp.store(t, "f", tt.plaintext)
+
+			result, err := gp.interceptor.InterceptOpen(context.Background(), &FileOperation{
+				Type: "read",
+				Path: filepath.Join(gp.protected, "f"),
+				UID:  os.Getuid(),
+				GID:  os.Getgid(),
+			})
+			if err != nil || !result.Allowed {
+				t.Fatalf("InterceptOpen = %+v, %v", result, err)
+			}
+			if result.Encrypted != tt.encrypted {
+				t.Errorf("Encrypted = %v, want %v", result.Encrypted, tt.encrypted)
+			}
+			if tt.want == nil {
+				if result.Data != nil {
+					t.Errorf("Data = %q, want the backing file to be read", result.Data)
+				}
+				return
+			}
+			if !bytes.Equal(result.Data, tt.want) {
+				t.Errorf("Data = %q, want %q", result.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterceptOpenShortFile(t *testing.T) {
+	// Files shorter than the encryption overhead, such as one just
+	// created, read as empty in every decrypting view
+	for _, view := range []string{config.ViewPlaintext, config.ViewMasked, config.ViewZeros} {
+		t.Run(view, func(t *testing.T) {
+			gp := newTestGuardPoint(t, config.EffectOption{View: view})
+			if err := os.WriteFile(filepath.Join(gp.storage, "f"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			result, err := gp.interceptor.InterceptOpen(context.Background(), &FileOperation{
+				Type: "read",
+				Path: filepath.Join(gp.protected, "f"),
+				UID:  os.Getuid(),
+				GID:  os.Getgid(),
+			})
+			if err != nil || !result.Allowed {
+				t.Fatalf("InterceptOpen = %+v, %v", result, err)
+			}
+			if len(result.Data) != 0 {
+				t.Errorf("Data = %q, want empty", result.Data)
+			}
+		})
+	}
+}
+
+func TestRawAccess(t *testing.T) {
+	tests := []struct {
+		option config.EffectOption
+		want   bool
+	}{
+		{config.EffectOption{ApplyKey: true}, false},
+		{config.EffectOption{}, true},
+		{config.EffectOption{View: config.ViewCiphertext}, true},
+		{config.EffectOption{View: config.ViewMasked}, false},
+		{config.EffectOption{View: config.ViewZeros}, false},
+	}
+	for _, tt := range tests {
+		gp := newTestGuardPoint(t, tt.option)
+		op := &FileOperation{
+			Type: "getattr",
+			Path: filepath.Join(gp.protected, "f"),
+			UID:  os.Getuid(),
+			GID:  os.Getgid(),
+		}
+		if got := gp.interceptor.RawAccess(op); got != tt.want {
+			t.Errorf("RawAccess with %+v = %v, want %v", tt.option, got, tt.want)
+		}
+	}
+}
+
+func TestAuditLearned(t *testing.T) {
+	learnMode := func(cfg *config.Config) {
+		cfg.Policies[0].Mode = config.PolicyModeLearn
+		cfg.Policies[0].SecurityRules = []config.SecurityRule{{
+			ID:     "no-writes",
+			Order:  1,
+			Action: []string{"write"},
+			Effect: config.RuleEffect{Permission: "deny"},
+		}}
+	}
+	tests := []struct {
+		name   string
+		action string
+		rule   string
+		run    func(gp *testGuardPoint, op *FileOperation) (*OperationResult, error)
+	}{
+		{"open", "read", "default-deny", func(gp *testGuardPoint, op *FileOperation) (*OperationResult, error) {
+			return gp.interceptor.InterceptOpen(context.Background(), op)
+		}},
+		{"write", "write", "no-writes", func(gp *testGuardPoint, op *FileOperation) (*OperationResult, error) {
+			op.Data = []byte("hello")
+			return gp.interceptor.InterceptWrite(context.Background(), op)
+		}},
+		{"authorized write", "write", "no-writes", func(gp *testGuardPoint, op *FileOperation) (*OperationResult, error) {
+			op.Data = []byte("hello")
+			authorized, err := gp.interceptor.AuthorizeWrite(op)
+			if err != nil || !authorized.Allowed {
+				return authorized, err
+			}
+			return gp.interceptor.WriteAuthorized(op, authorized)
+		}},
+		{"list", "browse", "default-deny", func(gp *testGuardPoint, op *FileOperation) (*OperationResult, error) {
+			return gp.interceptor.InterceptList(context.Background(), op)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := newConfiguredGuardPoint(t, learnMode)
+			gp.store(t, "f", []byte("secret"))
+			op := &FileOperation{Type: tt.name, Path: filepath.Join(gp.protected, "f"), UID: 1000, GID: 1001, Binary: "/usr/bin/app"}
+
+			result, err := tt.run(gp, op)
+			if err != nil || !result.Allowed {
+				t.Fatalf("allowed=%v, err=%v; learn mode must permit", result.Allowed, err)
+			}
+			if len(gp.audits) != 1 {
+				t.Fatalf("got %d audit events, want 1: %v", len(gp.audits), gp.messages)
+			}
+			event := gp.audits[0]
+			want := AuditEvent{
+				Operation:  tt.name,
+				Path:       op.Path,
+				User:       1000,
+				Group:      1001,
+				Process:    "/usr/bin/app",
+				Permission: "deny",
+				RuleID:     tt.rule,
+				Policy:     "p",
+				Success:    true,
+				Action:     tt.action,
+				Learned:    true,
+			}
+			event.Timestamp = 0
+			if *event != want {
+				t.Errorf("audit event = %+v, want %+v", *event, want)
+			}
+
+			// A decision pinned to a handle was audited when it was made
+			if result.Decision != nil {
+				op.Decision = result.Decision
+				if _, err := tt.run(gp, op); err != nil {
+					t.Fatal(err)
+				}
+				if len(gp.audits) != 1 {
+					t.Errorf("pinned decision audited again: %v", gp.messages)
+				}
+			}
+		})
+	}
+}
+
+func TestExemptedAccess(t *testing.T) {
+	exempt := func(cfg *config.Config) {
+		cfg.ProcessSets = []config.ProcessSet{{Code: "db", ResourceSetList: []config.ProcessSetResource{
+			{Directory: "/usr/sbin/", File: "mysqld", RWPExemptedResources: []string{"tablespaces"}},
+			{Directory: "/usr/bin/", File: "mysqldump"},
+		}}}
+		cfg.ResourceSets = []config.ResourceSet{{Code: "tablespaces", ResourceList: []config.Resource{{File: "*.ibd"}}}}
+		cfg.Policies[0].SecurityRules = []config.SecurityRule{{
+			ID:         "db",
+			Order:      1,
+			Action:     []string{"all_ops"},
+			ProcessSet: []string{"db"},
+			Effect:     config.RuleEffect{Permission: "permit", Option: config.EffectOption{ApplyKey: true}},
+		}}
+	}
+	tests := []struct {
+		name   string
+		binary string
+		file   string
+		raw    bool
+	}{
+		{"exempted", "/usr/sbin/mysqld", "users.ibd", true},
+		{"exempted process, other file", "/usr/sbin/mysqld", "my.cnf", false},
+		{"process without exemption", "/usr/bin/mysqldump", "users.ibd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := newConfiguredGuardPoint(t, exempt)
+			gp.store(t, tt.file, []byte("rows"))
+			stored, err := os.ReadFile(filepath.Join(gp.storage, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(gp.protected, tt.file)
+			newOp := func(typ string) *FileOperation {
+				return &FileOperation{Type: typ, Path: path, UID: 27, GID: 27, Binary: tt.binary}
+			}
+
+			if got := gp.interceptor.RawAccess(newOp("getattr")); got != tt.raw {
+				t.Errorf("RawAccess = %v, want %v", got, tt.raw)
+			}
+
+			read, err := gp.interceptor.InterceptOpen(context.Background(), newOp("read"))
+			if err != nil || !read.Allowed {
+				t.Fatalf("read: allowed=%v, err=%v", read.Allowed, err)
+			}
+			// Exempted reads go to the backing file as stored
+			if tt.raw && (read.Encrypted || read.Data != nil) {
+				t.Errorf("exempted read returned decrypted data %q", read.Data)
+			}
+			if !tt.raw && string(read.Data) != "rows" {
+				t.Errorf("read = %q, want the plaintext", read.Data)
+			}
+
+			write := newOp("write")
+			write.Data = []byte("more")
+			written, err := gp.interceptor.InterceptWrite(context.Background(), write)
+			if err != nil || !written.Allowed {
+				t.Fatalf("write: allowed=%v, err=%v", written.Allowed, err)
+			}
+			after, err := os.ReadFile(filepath.Join(gp.storage, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The caller writes exempted data unchanged; the interceptor
+			// leaves the backing file alone
+			if tt.raw && (written.Encrypted || !bytes.Equal(after, stored)) {
+				t.Errorf("exempted write was encrypted by the interceptor")
+			}
+			if !tt.raw && string(gp.plaintext(t, tt.file)) != "more" {
+				t.Errorf("plaintext after write = %q, want %q", gp.plaintext(t, tt.file), "more")
+			}
+
+			var exemptions []string
+			for n, event := range gp.audits {
+				if event.RuleID == "db" && event.Success && strings.Contains(gp.messages[n], "exempted for resource set tablespaces") {
+					exemptions = append(exemptions, event.Operation+": "+gp.messages[n])
+				}
+			}
+			want := 0
+			if tt.raw {
+				want = 2
+			}
+			if len(exemptions) != want || len(gp.audits) != want {
+				t.Errorf("got exemption audits %q of %d events, want %d", exemptions, len(gp.audits), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package fuse
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+)
+
+// Mutating operations subject to the guard point access mode.
+const (
+	mutCreate   = "create"
+	mutMkdir    = "mkdir"
+	mutOpen     = "open"
+	mutWrite    = "write"
+	mutTruncate = "truncate"
+	mutChmod    = "chmod"
+	mutRename   = "rename"
+	mutUnlink   = "unlink"
+	mutRmdir    = "rmdir"
+)
+
+// mutation describes an attempted change for access mode enforcement.
+type mutation struct {
+	op          string
+	virtualPath string
+	backingPath string
+	flags       uint32 // open flags, for mutOpen
+	offset      int64  // plaintext write offset, for mutWrite
+	size        int64  // new size, for mutTruncate
+}
+
+// accessModeErrno decides whether the guard point's access mode forbids m.
+// It returns 0 when the mutation may proceed, otherwise the errno and a
+// reason for the audit log. Policy permits cannot override this.
+func accessModeErrno(gp *config.GuardPoint, m *mutation) (syscall.Errno, string) {
+	switch gp.AccessMode {
+	case "", config.AccessModeReadWrite:
+		return 0, ""
+
+	case config.AccessModeReadOnly:
+		if m.op == mutOpen && !openWrites(m.flags) {
+			return 0, ""
+		}
+		return syscall.EROFS, "guard point is read-only"
+
+	case config.AccessModeAppendOnly:
+		switch m.op {
+		case mutCreate, mutMkdir, mutChmod:
+			return 0, ""
+		case mutOpen:
+			if m.flags&syscall.O_TRUNC != 0 {
+				return syscall.EPERM, "append-only guard point forbids truncating on open"
+			}
+			return 0, ""
+		case mutWrite:
+			if size := logicalSize(m.backingPath); m.offset < size {
+				return syscall.EPERM, fmt.Sprintf("append-only guard point forbids writing at offset %d below end of file %d", m.offset, size)
+			}
+			return 0, ""
+		case mutTruncate:
+			if size := logicalSize(m.backingPath); m.size < size {
+				return syscall.EPERM, fmt.Sprintf("append-only guard point forbids shrinking from %d to %d", size, m.size)
+			}
+			return 0, ""
+		}
+		return syscall.EPERM, "append-only guard point forbids " + m.op
+
+	case config.AccessModeWORM:
+		switch m.op {
+		case mutCreate, mutMkdir, mutRmdir:
+			return 0, ""
+		case mutOpen:
+			if openWrites(m.flags) && wormCommitted(m.backingPath) {
+				return syscall.EPERM, "WORM file is committed and cannot be modified"
+			}
+			return 0, ""
+		case mutWrite, mutTruncate, mutChmod, mutRename:
+			if wormCommitted(m.backingPath) {
+				return syscall.EPERM, "WORM file is committed and cannot be modified"
+			}
+			return 0, ""
+		case mutUnlink:
+			if !wormCommitted(m.backingPath) {
+				return 0, ""
+			}
+			if until := wormRetainedUntil(gp, m.backingPath); time.Now().Before(until) {
+				return syscall.EPERM, fmt.Sprintf("WORM file is under retention until %s", until.Format(time.RFC3339))
+			}
+			return 0, ""
+		}
+		return syscall.EPERM, "WORM guard point forbids " + m.op
+	}
+
+	// Unknown modes are rejected by config validation; fail closed anyway.
+	return syscall.EPERM, "unknown access mode " + gp.AccessMode
+}
+
+// enforceAccessMode applies accessModeErrno and audits blocked attempts.
+func enforceAccessMode(interceptor *filesystem.Interceptor, gp *config.GuardPoint, m *mutation, uid int, binary string) syscall.Errno {
+	errno, reason := accessModeErrno(gp, m)
+	if errno == 0 {
+		return 0
+	}
+
+	log.Printf("[FUSE] %s blocked on %s by access mode %s: %s", m.op, m.virtualPath, gp.AccessMode, reason)
+	interceptor.Audit(&filesystem.AuditEvent{
+		Operation:  m.op,
+		Path:       m.virtualPath,
+		User:       uid,
+		Process:    binary,
+		Permission: "deny",
+		RuleID:     "access-mode",
+		Success:    false,
+	}, reason)
+	return errno
+}
+
+// wormCommit makes a WORM file immutable by clearing its write bits. The
+// commit time is the file's modification time.
+func wormCommit(backingPath string) {
+	info, err := os.Stat(backingPath)
+	if err != nil {
+		log.Printf("[FUSE] WORM commit: stat failed for %s: %v", backingPath, err)
+		return
+	}
+	if err := os.Chmod(backingPath, info.Mode().Perm()&^0222); err != nil {
+		log.Printf("[FUSE] WORM commit: chmod failed for %s: %v", backingPath, err)
+		return
+	}
+	log.Printf("[FUSE] WORM commit: %s is now immutable", backingPath)
+}
+
+// wormCommitted reports whether a WORM file has been committed, i.e. is a
+// regular file with every write bit cleared.
+func wormCommitted(backingPath string) bool {
+	info, err := os.Stat(backingPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return info.Mode().Perm()&0222 == 0
+}
+
+func wormRetainedUntil(gp *config.GuardPoint, backingPath string) time.Time {
+	info, err := os.Stat(backingPath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime().Add(time.Duration(gp.WORMRetentionDays) * 24 * time.Hour)
+}
+
+func openWrites(flags uint32) bool {
+	return int(flags)&syscall.O_ACCMODE != syscall.O_RDONLY || flags&syscall.O_TRUNC != 0
+}
+
+// logicalSize is the plaintext size of a backing file.
+func logicalSize(backingPath string) int64 {
+	info, err := os.Stat(backingPath)
+	if err != nil {
+		return 0
+	}
+	if info.Size() >= crypto.Overhead {
+		return info.Size() - crypto.Overhead
+	}
+	return info.Size()
+}
@@ -0,0 +1,45 @@
+package fuse
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+)
+
+func TestAppendOnlyAccessMode(t *testing.T) {
+	// A stored file holding 10 bytes of plaintext
+	backing := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(backing, make([]byte, 10+crypto.Overhead), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gp := &config.GuardPoint{AccessMode: config.AccessModeAppendOnly}
+
+	tests := []struct {
+		name string
+		m    mutation
+		want syscall.Errno
+	}{
+		{"append at end", mutation{op: mutWrite, offset: 10}, 0},
+		{"append past end", mutation{op: mutWrite, offset: 12}, 0},
+		{"overwrite", mutation{op: mutWrite, offset: 9}, syscall.EPERM},
+		{"grow", mutation{op: mutTruncate, size: 20}, 0},
+		{"shrink", mutation{op: mutTruncate, size: 0}, syscall.EPERM},
+		{"open with O_TRUNC", mutation{op: mutOpen, flags: syscall.O_WRONLY | syscall.O_TRUNC}, syscall.EPERM},
+		{"open for append", mutation{op: mutOpen, flags: syscall.O_WRONLY | syscall.O_APPEND}, 0},
+		{"chmod", mutation{op: mutChmod}, 0},
+		{"unlink", mutation{op: mutUnlink}, syscall.EPERM},
+		{"rename", mutation{op: mutRename}, syscall.EPERM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.backingPath = backing
+			if got, reason := accessModeErrno(gp, &tt.m); got != tt.want {
+				t.Errorf("accessModeErrno = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
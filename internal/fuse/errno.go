@@ -0,0 +1,30 @@
+package fuse
+
+import (
+	"syscall"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+// denyErrno returns the errno for an operation the interceptor refused,
+// following the errno_mode of mounted guard point gp as currently
+// configured, since a reload changing it does not remount.
+func denyErrno(interceptor *filesystem.Interceptor, gp *config.GuardPoint, result *filesystem.OperationResult) syscall.Errno {
+	if gp != nil {
+		if current := interceptor.GuardPoint(gp.ProtectedPath); current != nil {
+			gp = current
+		}
+	}
+	if gp == nil || gp.ErrnoMode != config.ErrnoModeReason || result == nil {
+		return syscall.EACCES
+	}
+	switch result.Reason {
+	case policy.ReasonRuleDenied:
+		return syscall.EPERM
+	case policy.ReasonKeyUnavailable:
+		return syscall.EKEYREJECTED
+	}
+	return syscall.EACCES
+}
@@ -0,0 +1,97 @@
+package fuse
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+func TestDenyErrno(t *testing.T) {
+	reasons := []string{
+		policy.ReasonRuleDenied,
+		policy.ReasonKeyUnavailable,
+		policy.ReasonNoRuleMatched,
+		policy.ReasonIdentityUnresolved,
+		"",
+	}
+	tests := []struct {
+		mode string
+		want map[string]syscall.Errno
+	}{
+		{"", map[string]syscall.Errno{}},
+		{config.ErrnoModeEACCES, map[string]syscall.Errno{}},
+		{config.ErrnoModeReason, map[string]syscall.Errno{
+			policy.ReasonRuleDenied:     syscall.EPERM,
+			policy.ReasonKeyUnavailable: syscall.EKEYREJECTED,
+		}},
+	}
+	for _, tt := range tests {
+		gp := &config.GuardPoint{Code: "gp", ProtectedPath: "/data", ErrnoMode: tt.mode}
+		// An engine without the guard point leaves the mounted one in force
+		interceptor := filesystem.NewInterceptor(policy.NewEngine(&config.Config{}), nil)
+		for _, reason := range reasons {
+			want, ok := tt.want[reason]
+			if !ok {
+				want = syscall.EACCES
+			}
+			result := &filesystem.OperationResult{Reason: reason}
+			if got := denyErrno(interceptor, gp, result); got != want {
+				t.Errorf("errno_mode %q, reason %q: got %v, want %v", tt.mode, reason, got, want)
+			}
+		}
+		if got := denyErrno(interceptor, gp, nil); got != syscall.EACCES {
+			t.Errorf("errno_mode %q without a result: got %v, want EACCES", tt.mode, got)
+		}
+	}
+	if got := denyErrno(nil, nil, &filesystem.OperationResult{Reason: policy.ReasonRuleDenied}); got != syscall.EACCES {
+		t.Errorf("without a guard point: got %v, want EACCES", got)
+	}
+}
+
+func TestDenyErrnoFollowsReload(t *testing.T) {
+	mounted := config.GuardPoint{Code: "gp", ProtectedPath: "/data", Policy: "p", Enabled: true}
+	reloaded := mounted
+	reloaded.ErrnoMode = config.ErrnoModeReason
+	engine := policy.NewEngine(&config.Config{GuardPoints: []config.GuardPoint{mounted}})
+	interceptor := filesystem.NewInterceptor(engine, nil)
+	result := &filesystem.OperationResult{Reason: policy.ReasonRuleDenied}
+
+	if got := denyErrno(interceptor, &mounted, result); got != syscall.EACCES {
+		t.Errorf("before the reload: got %v, want EACCES", got)
+	}
+	engine.Update(&config.Config{GuardPoints: []config.GuardPoint{reloaded}})
+	if got := denyErrno(interceptor, &mounted, result); got != syscall.EPERM {
+		t.Errorf("after the reload: got %v, want EPERM", got)
+	}
+}
+
+func TestRefusedCreateErrno(t *testing.T) {
+	denyWrites := config.SecurityRule{
+		ID:     "no-writes",
+		Order:  1,
+		Action: []string{"write"},
+		Effect: config.RuleEffect{Permission: "deny"},
+	}
+	tests := []struct {
+		name  string
+		mode  string
+		rules []config.SecurityRule
+		want  syscall.Errno
+	}{
+		{"deny rule", "", []config.SecurityRule{denyWrites}, syscall.EACCES},
+		{"no rule", "", nil, syscall.EACCES},
+		{"deny rule by reason", config.ErrnoModeReason, []config.SecurityRule{denyWrites}, syscall.EPERM},
+		{"no rule by reason", config.ErrnoModeReason, nil, syscall.EACCES},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tfs := newTestFS(t, config.GuardPoint{ErrnoMode: tt.mode}, tt.rules...)
+			if _, errno := tfs.create(t, "f"); errno != tt.want {
+				t.Errorf("Create = %v, want %v", errno, tt.want)
+			}
+		})
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"log"
 	"os"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 
 	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
 	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
 )
 
 type TransparentFile struct {
@@ -19,6 +23,7 @@ type TransparentFile struct {
 	guardPoint  *config.GuardPoint
 	virtualPath string
 	backingPath string
+	quota       *quota.Tracker
 }
 
 type TransparentFileHandle struct {
@@ -27,6 +32,12 @@ type TransparentFileHandle struct {
 	guardPoint  *config.GuardPoint
 	virtualPath string
 	backingPath string
+	quota       *quota.Tracker
+	written     bool
+
+	// Decisions pinned to this handle when decision pinning is enabled
+	readDecision  atomic.Pointer[policy.AccessResult]
+	writeDecision atomic.Pointer[policy.AccessResult]
 }
 
 var _ = (fs.NodeOpener)((*TransparentFile)(nil))
@@ -44,6 +55,11 @@ func (tf *TransparentFile) Open(ctx context.Context, flags uint32) (fs.FileHandl
 	uid, gid, pid := getRealUserContext(ctx)
 	binary := getProcessBinaryFromPid(pid)
 
+	m := &mutation{op: mutOpen, virtualPath: tf.virtualPath, backingPath: tf.backingPath, flags: flags}
+	if errno := enforceAccessMode(tf.interceptor, tf.guardPoint, m, uid, binary); errno != 0 {
+		return nil, 0, errno
+	}
+
 	op := &filesystem.FileOperation{
 		Type:   "open",
 		Path:   tf.virtualPath,
@@ -58,7 +74,7 @@ func (tf *TransparentFile) Open(ctx context.Context, flags uint32) (fs.FileHandl
 	log.Printf("[FUSE] Open result: allowed=%v, err=%v, backingPath=%s", result.Allowed, err, tf.backingPath)
 	if err != nil || !result.Allowed {
 		log.Printf("[FUSE] Open denied by policy")
-		return nil, 0, syscall.EACCES
+		return nil, 0, denyErrno(tf.interceptor, tf.guardPoint, result)
 	}
 
 	log.Printf("[FUSE] Opening backing file: %s", tf.backingPath)
@@ -73,11 +89,13 @@ func (tf *TransparentFile) Open(ctx context.Context, flags uint32) (fs.FileHandl
 		file:        file,
 		interceptor: tf.interceptor,
 		guardPoint:  tf.guardPoint,
+		quota:       tf.quota,
 		virtualPath: tf.virtualPath,
 		backingPath: tf.backingPath,
 	}
+	fileHandle.pin(&fileHandle.readDecision, result)
 
-	return fileHandle, 0, 0
+	return fileHandle, openFlags(tf.guardPoint), 0
 }
 
 func (tf *TransparentFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
@@ -93,15 +111,8 @@ func (tf *TransparentFile) Getattr(ctx context.Context, fh fs.FileHandle, out *f
 
 	attr := fileInfoToAttr(info)
 	
-	// Fix file size: encrypted files have 28 bytes overhead (12-byte nonce + 16-byte auth tag)
-	// Report the actual decrypted content size to applications
-	if info.Size() >= 28 {
-		decryptedSize := info.Size() - 28
-		attr.Size = uint64(decryptedSize)
-		log.Printf("[FUSE] File Getattr: adjusted size from %d to %d (removed 28-byte encryption overhead)", info.Size(), decryptedSize)
-	} else {
-		log.Printf("[FUSE] File Getattr: file too small for encryption overhead, reporting actual size %d", info.Size())
-	}
+	attr.Size = tf.visibleSize(ctx, fh, info)
+	log.Printf("[FUSE] File Getattr: reporting size %d for stored size %d", attr.Size, info.Size())
 	// Ensure the FUSE view shows the correct ownership from the backing store
 	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
 		log.Printf("[FUSE] File Getattr: backing store ownership - uid=%d, gid=%d", stat.Uid, stat.Gid)
@@ -120,6 +131,30 @@ func (tf *TransparentFile) Getattr(ctx context.Context, fh fs.FileHandle, out *f
 	return 0
 }
 
+// visibleSize is the size of the file as the caller's reads return it.
+// Encrypted files carry 28 bytes of overhead (12-byte nonce + 16-byte auth
+// tag), which every view but ciphertext leaves out.
+func (tf *TransparentFile) visibleSize(ctx context.Context, fh fs.FileHandle, info os.FileInfo) uint64 {
+	if info.Size() < crypto.Overhead || tf.rawAccess(ctx, fh) {
+		return uint64(info.Size())
+	}
+	return uint64(info.Size() - crypto.Overhead)
+}
+
+// rawAccess reports whether the caller sees this file's ciphertext, and so
+// its stored size, because of its rule's view or an exemption.
+func (tf *TransparentFile) rawAccess(ctx context.Context, fh fs.FileHandle) bool {
+	op := &filesystem.FileOperation{Type: "getattr", Path: tf.virtualPath}
+	if handle, ok := fh.(*TransparentFileHandle); ok {
+		op.Decision = handle.readDecision.Load()
+	}
+	if op.Decision == nil {
+		op.UID, op.GID, op.PID = getRealUserContext(ctx)
+		op.Binary = getProcessBinaryFromPid(op.PID)
+	}
+	return tf.interceptor.RawAccess(op)
+}
+
 func (tf *TransparentFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
 	// Get real user context from FUSE
 	uid, gid, pid := getRealUserContext(ctx)
@@ -127,6 +162,19 @@ func (tf *TransparentFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fu
 
 	log.Printf("[FUSE] Setattr: path=%s, uid=%d, pid=%d, binary=%s", tf.virtualPath, uid, pid, binary)
 
+	if in.Valid&fuse.FATTR_MODE != 0 {
+		m := &mutation{op: mutChmod, virtualPath: tf.virtualPath, backingPath: tf.backingPath}
+		if errno := enforceAccessMode(tf.interceptor, tf.guardPoint, m, uid, binary); errno != 0 {
+			return errno
+		}
+	}
+	if in.Valid&fuse.FATTR_SIZE != 0 {
+		m := &mutation{op: mutTruncate, virtualPath: tf.virtualPath, backingPath: tf.backingPath, size: int64(in.Size)}
+		if errno := enforceAccessMode(tf.interceptor, tf.guardPoint, m, uid, binary); errno != 0 {
+			return errno
+		}
+	}
+
 	if in.Valid&fuse.FATTR_MODE != 0 {
 		log.Printf("[FUSE] Setting mode: %o", in.Mode)
 		if err := os.Chmod(tf.backingPath, os.FileMode(in.Mode)); err != nil {
@@ -139,28 +187,59 @@ func (tf *TransparentFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fu
 		log.Printf("[FUSE] Truncating to size: %d", in.Size)
 		
 		// For encrypted files, we need to handle truncation through the interceptor
+		var op *filesystem.FileOperation
+		var authorized *filesystem.OperationResult
 		if tf.guardPoint != nil {
 			// Check if user has write permission for truncation
-			op := &filesystem.FileOperation{
+			op = &filesystem.FileOperation{
 				Type:   "write",
 				Path:   tf.virtualPath,
-				Data:   make([]byte, in.Size), // Create buffer of target size
 				UID:    uid,
 				GID:    gid,
 				PID:    pid,
 				Binary: binary,
 			}
 
-			result, err := tf.interceptor.InterceptWrite(ctx, op)
-			if err != nil || !result.Allowed {
+			var err error
+			authorized, err = tf.interceptor.AuthorizeWrite(op)
+			if err != nil || !authorized.Allowed {
 				log.Printf("[FUSE] Truncate denied: %v", err)
-				return syscall.EACCES
+				return denyErrno(tf.interceptor, tf.guardPoint, authorized)
 			}
 		}
-		
-		if err := os.Truncate(tf.backingPath, int64(in.Size)); err != nil {
-			log.Printf("[FUSE] Truncate failed: %v", err)
-			return syscall.EIO
+
+		// Check the quota before resizing, reserving room for the nonce
+		// and tag of an encrypted file
+		owner, oldSize, _ := backingUsage(tf.backingPath)
+		reserved := int64(in.Size) + crypto.Overhead - oldSize
+		if errno := reserveQuota(tf.quota, tf.interceptor, "truncate", tf.virtualPath, owner, uid, binary, reserved, 0); errno != 0 {
+			return errno
+		}
+		defer func() {
+			// Settle the reservation with the size actually stored
+			used := int64(0)
+			if _, newSize, ok := backingUsage(tf.backingPath); ok {
+				used = newSize - oldSize
+			}
+			tf.quota.Charge(owner, used-reserved, 0)
+		}()
+
+		// Encrypted files are resized as plaintext; cutting the
+		// ciphertext would destroy the nonce and tag
+		resized := false
+		if op != nil {
+			result, err := tf.interceptor.TruncateAuthorized(op, int64(in.Size), authorized)
+			if err != nil || !result.Allowed {
+				log.Printf("[FUSE] Truncate failed: %v", err)
+				return denyErrno(tf.interceptor, tf.guardPoint, result)
+			}
+			resized = result.Encrypted
+		}
+		if !resized {
+			if err := os.Truncate(tf.backingPath, int64(in.Size)); err != nil {
+				log.Printf("[FUSE] Truncate failed: %v", err)
+				return syscall.EIO
+			}
 		}
 	}
 
@@ -171,6 +250,7 @@ func (tf *TransparentFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fu
 	}
 
 	out.Attr = fileInfoToAttr(info)
+	out.Attr.Size = tf.visibleSize(ctx, fh, info)
 	return 0
 }
 
@@ -182,19 +262,21 @@ func (fh *TransparentFileHandle) Read(ctx context.Context, dest []byte, off int6
 	log.Printf("[FUSE] Read: path=%s, uid=%d, gid=%d, pid=%d, binary=%s", fh.virtualPath, uid, gid, pid, binary)
 
 	op := &filesystem.FileOperation{
-		Type:   "read",
-		Path:   fh.virtualPath,
-		UID:    uid,
-		GID:    gid,
-		PID:    pid,
-		Binary: binary,
+		Type:     "read",
+		Path:     fh.virtualPath,
+		UID:      uid,
+		GID:      gid,
+		PID:      pid,
+		Binary:   binary,
+		Decision: fh.readDecision.Load(),
 	}
 
 	result, err := fh.interceptor.InterceptOpen(ctx, op)
 	log.Printf("[FUSE] Read result: allowed=%v, err=%v", result.Allowed, err)
 	if err != nil || !result.Allowed {
-		return nil, syscall.EACCES
+		return nil, denyErrno(fh.interceptor, fh.guardPoint, result)
 	}
+	fh.pin(&fh.readDecision, result)
 
 	if result.Encrypted && result.Data != nil {
 		if off >= int64(len(result.Data)) {
@@ -217,6 +299,15 @@ func (fh *TransparentFileHandle) Read(ctx context.Context, dest []byte, off int6
 	return fuse.ReadResultData(dest[:n]), 0
 }
 
+// pin keeps a permitting decision on the handle, if pinning is enabled and
+// no decision is pinned yet. Like file permissions checked at open(2), a
+// pinned decision outlives policy reloads for the life of the handle.
+func (fh *TransparentFileHandle) pin(slot *atomic.Pointer[policy.AccessResult], result *filesystem.OperationResult) {
+	if fh.interceptor.PinDecisions() && result.Allowed && result.Decision != nil {
+		slot.CompareAndSwap(nil, result.Decision)
+	}
+}
+
 func (fh *TransparentFileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
 	// Get real user context from FUSE
 	uid, gid, pid := getRealUserContext(ctx)
@@ -224,21 +315,64 @@ func (fh *TransparentFileHandle) Write(ctx context.Context, data []byte, off int
 
 	log.Printf("[FUSE] Write: path=%s, offset=%d, size=%d, uid=%d, pid=%d, binary=%s", fh.virtualPath, off, len(data), uid, pid, binary)
 
+	m := &mutation{op: mutWrite, virtualPath: fh.virtualPath, backingPath: fh.backingPath, offset: off}
+	if errno := enforceAccessMode(fh.interceptor, fh.guardPoint, m, uid, binary); errno != 0 {
+		return 0, errno
+	}
+
 	op := &filesystem.FileOperation{
-		Type:   "write",
-		Path:   fh.virtualPath,
-		Data:   data,
-		UID:    uid,
-		GID:    gid,
-		PID:    pid,
-		Binary: binary,
+		Type:     "write",
+		Path:     fh.virtualPath,
+		Data:     data,
+		Offset:   off,
+		UID:      uid,
+		GID:      gid,
+		PID:      pid,
+		Binary:   binary,
+		Decision: fh.writeDecision.Load(),
 	}
 
-	result, err := fh.interceptor.InterceptWrite(ctx, op)
+	// Authorize before the quota check, so that callers the policy denies
+	// get its errno and not EDQUOT
+	authorized, err := fh.interceptor.AuthorizeWrite(op)
+	if err != nil || !authorized.Allowed {
+		log.Printf("[FUSE] Write denied: %v", err)
+		return 0, denyErrno(fh.interceptor, fh.guardPoint, authorized)
+	}
+
+	// Encrypted writes rewrite the backing file with the spliced plaintext
+	// plus the nonce and tag, so reserve the larger of that and an
+	// in-place write.
+	owner, oldSize, _ := backingUsage(fh.backingPath)
+	projected := off + int64(len(data))
+	encrypted := logicalSize(fh.backingPath)
+	if projected > encrypted {
+		encrypted = projected
+	}
+	if encrypted += crypto.Overhead; encrypted > projected {
+		projected = encrypted
+	}
+	reserved := projected - oldSize
+	if errno := reserveQuota(fh.quota, fh.interceptor, "write", fh.virtualPath, owner, uid, binary, reserved, 0); errno != 0 {
+		return 0, errno
+	}
+	defer func() {
+		// Settle the reservation with the size actually written
+		used := int64(0)
+		if _, newSize, ok := backingUsage(fh.backingPath); ok {
+			used = newSize - oldSize
+		}
+		fh.quota.Charge(owner, used-reserved, 0)
+	}()
+
+	result, err := fh.interceptor.WriteAuthorized(op, authorized)
 	if err != nil || !result.Allowed {
 		log.Printf("[FUSE] Write denied: %v", err)
-		return 0, syscall.EACCES
+		return 0, denyErrno(fh.interceptor, fh.guardPoint, result)
 	}
+	fh.pin(&fh.writeDecision, result)
+
+	fh.written = true
 
 	if result.Encrypted {
 		// For encrypted files, the interceptor handles the actual write
@@ -278,6 +412,10 @@ func (fh *TransparentFileHandle) Release(ctx context.Context) syscall.Errno {
 	if err := fh.file.Close(); err != nil {
 		return syscall.EIO
 	}
+	// Closing a file written on a WORM guard point commits it
+	if fh.written && fh.guardPoint.AccessMode == config.AccessModeWORM {
+		wormCommit(fh.backingPath)
+	}
 	return 0
 }
 
@@ -0,0 +1,166 @@
+package fuse
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+)
+
+func TestVisibleSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		option config.EffectOption
+		stored int64
+		want   uint64
+	}{
+		{"plaintext", config.EffectOption{ApplyKey: true}, 100 + crypto.Overhead, 100},
+		{"ciphertext by default", config.EffectOption{}, 100 + crypto.Overhead, 100 + crypto.Overhead},
+		{"ciphertext", config.EffectOption{ApplyKey: true, View: config.ViewCiphertext}, 100 + crypto.Overhead, 100 + crypto.Overhead},
+		{"masked", config.EffectOption{View: config.ViewMasked}, 100 + crypto.Overhead, 100},
+		{"zeros", config.EffectOption{View: config.ViewZeros}, 100 + crypto.Overhead, 100},
+		{"empty", config.EffectOption{ApplyKey: true}, 0, 0},
+		{"shorter than overhead", config.EffectOption{ApplyKey: true}, crypto.Overhead - 1, crypto.Overhead - 1},
+		{"overhead only", config.EffectOption{View: config.ViewZeros}, crypto.Overhead, 0},
+		{"shorter than overhead, ciphertext", config.EffectOption{}, 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			storage := filepath.Join(dir, "storage")
+			if err := os.MkdirAll(storage, 0755); err != nil {
+				t.Fatal(err)
+			}
+			backing := filepath.Join(storage, "f")
+			if err := os.WriteFile(backing, make([]byte, tt.stored), 0644); err != nil {
+				t.Fatal(err)
+			}
+			gp := config.GuardPoint{
+				ID:                "gp-1",
+				Code:              "gp",
+				ProtectedPath:     filepath.Join(dir, "protected"),
+				SecureStoragePath: storage,
+				Policy:            "p",
+				Enabled:           true,
+			}
+			engine := policy.NewEngine(&config.Config{
+				GuardPoints: []config.GuardPoint{gp},
+				Policies: []config.Policy{{
+					Code: "p",
+					SecurityRules: []config.SecurityRule{{
+						ID:     "all",
+						Order:  1,
+						Action: []string{"all_ops"},
+						Effect: config.RuleEffect{Permission: "permit", Option: tt.option},
+					}},
+				}},
+			})
+			tf := &TransparentFile{
+				interceptor: filesystem.NewInterceptor(engine, nil),
+				guardPoint:  &gp,
+				virtualPath: filepath.Join(gp.ProtectedPath, "f"),
+				backingPath: backing,
+			}
+
+			info, err := os.Stat(backing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tf.visibleSize(context.Background(), nil, info); got != tt.want {
+				t.Errorf("visibleSize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name       string
+		accessMode string
+		size       uint64
+		want       string
+		errno      syscall.Errno
+	}{
+		{name: "shrink", size: 5, want: "hello"},
+		{name: "same size", size: 11, want: "hello world"},
+		{name: "grow", size: 13, want: "hello world\x00\x00"},
+		{name: "empty", size: 0, want: ""},
+		{name: "append-only grow", accessMode: config.AccessModeAppendOnly, size: 12, want: "hello world\x00"},
+		{name: "append-only same size", accessMode: config.AccessModeAppendOnly, size: 11, want: "hello world"},
+		{name: "append-only shrink", accessMode: config.AccessModeAppendOnly, size: 10, want: "hello world", errno: syscall.EPERM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tfs := newTestFS(t, config.GuardPoint{AccessMode: tt.accessMode}, permitAll)
+			fh, errno := tfs.create(t, "f")
+			if errno != 0 {
+				t.Fatalf("Create: %v", errno)
+			}
+			if _, errno := fh.Write(callerContext(), []byte("hello world"), 0); errno != 0 {
+				t.Fatalf("Write: %v", errno)
+			}
+
+			in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: tt.size}}
+			out := &fuse.AttrOut{}
+			if errno := tfs.file("f").Setattr(callerContext(), nil, in, out); errno != tt.errno {
+				t.Fatalf("Setattr = %v, want %v", errno, tt.errno)
+			}
+			if got := tfs.plaintext(t, "f"); string(got) != tt.want {
+				t.Errorf("plaintext = %q, want %q", got, tt.want)
+			}
+			if tt.errno == 0 && out.Attr.Size != tt.size {
+				t.Errorf("reported size = %d, want %d", out.Attr.Size, tt.size)
+			}
+		})
+	}
+}
+
+func TestPinnedDecisions(t *testing.T) {
+	for _, pin := range []bool{true, false} {
+		t.Run(map[bool]string{true: "pinned", false: "unpinned"}[pin], func(t *testing.T) {
+			tfs := newTestFS(t, config.GuardPoint{}, permitAll)
+			tfs.root.interceptor.SetPinDecisions(pin)
+			fh, errno := tfs.create(t, "f")
+			if errno != 0 {
+				t.Fatalf("Create: %v", errno)
+			}
+			if _, errno := fh.Write(callerContext(), []byte("hello"), 0); errno != 0 {
+				t.Fatalf("Write: %v", errno)
+			}
+			opened, _, errno := tfs.file("f").Open(callerContext(), syscall.O_RDONLY)
+			if errno != 0 {
+				t.Fatalf("Open: %v", errno)
+			}
+			handle := opened.(*TransparentFileHandle)
+			defer handle.file.Close()
+
+			// A reload that denies everything
+			cfg := tfs.engine.Config().Copy()
+			cfg.Policies[0].SecurityRules = nil
+			tfs.engine.Update(cfg)
+
+			_, readErrno := handle.Read(callerContext(), make([]byte, 16), 0)
+			_, writeErrno := fh.Write(callerContext(), []byte("HELLO"), 0)
+			want := syscall.Errno(syscall.EACCES)
+			if pin {
+				// The handles keep the decisions that let them read and write
+				want = 0
+			}
+			if readErrno != want || writeErrno != want {
+				t.Errorf("read = %v, write = %v on open handles after the reload, want %v", readErrno, writeErrno, want)
+			}
+			// New handles get the new policy
+			if _, _, errno := tfs.file("f").Open(callerContext(), syscall.O_RDONLY); errno != syscall.EACCES {
+				t.Errorf("Open after the reload = %v, want EACCES", errno)
+			}
+		})
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/hanwen/go-fuse/v2/fuse"
 
 	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
 	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
 )
 
 type TransparentFS struct {
@@ -21,15 +23,17 @@ type TransparentFS struct {
 	interceptor  *filesystem.Interceptor
 	guardPoint   *config.GuardPoint
 	backingPath  string
+	quota        *quota.Tracker
 }
 
-func NewTransparentFS(interceptor *filesystem.Interceptor, guardPoint *config.GuardPoint) *TransparentFS {
+func NewTransparentFS(interceptor *filesystem.Interceptor, guardPoint *config.GuardPoint, tracker *quota.Tracker) *TransparentFS {
 	log.Printf("[FUSE] NewTransparentFS: creating root FS with backingPath=%s, guardPoint.SecureStoragePath=%s", 
 		guardPoint.SecureStoragePath, guardPoint.SecureStoragePath)
 	return &TransparentFS{
 		interceptor: interceptor,
 		guardPoint:  guardPoint,
 		backingPath: guardPoint.SecureStoragePath,
+		quota:       tracker,
 	}
 }
 
@@ -63,6 +67,7 @@ func (tfs *TransparentFS) Lookup(ctx context.Context, name string, out *fuse.Ent
 		child = &TransparentFS{
 			interceptor: tfs.interceptor,
 			guardPoint:  tfs.guardPoint,
+			quota:       tfs.quota,
 			backingPath: backingPath,
 		}
 		log.Printf("[FUSE] Lookup: created directory child for %s -> %s", virtualPath, backingPath)
@@ -70,6 +75,7 @@ func (tfs *TransparentFS) Lookup(ctx context.Context, name string, out *fuse.Ent
 		child = &TransparentFile{
 			interceptor: tfs.interceptor,
 			guardPoint:  tfs.guardPoint,
+			quota:       tfs.quota,
 			virtualPath: virtualPath,
 			backingPath: backingPath,
 		}
@@ -85,6 +91,9 @@ func (tfs *TransparentFS) Lookup(ctx context.Context, name string, out *fuse.Ent
 	}
 
 	attr := fileInfoToAttr(info)
+	if file, ok := child.(*TransparentFile); ok {
+		attr.Size = file.visibleSize(ctx, nil, info)
+	}
 	// Force correct ownership display in FUSE
 	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
 		log.Printf("[FUSE] Lookup: backing store ownership - uid=%d, gid=%d", stat.Uid, stat.Gid)
@@ -121,6 +130,15 @@ func (tfs *TransparentFS) Create(ctx context.Context, name string, flags uint32,
 
 	log.Printf("[FUSE] Create: user context - uid=%d, gid=%d, pid=%d, binary=%s, flags=%d, mode=%o", uid, gid, pid, binary, flags, mode)
 
+	m := &mutation{op: mutCreate, virtualPath: virtualPath, backingPath: backingPath, flags: flags}
+	if _, err := os.Lstat(backingPath); err == nil {
+		// O_CREAT on an existing file is an open of that file
+		m.op = mutOpen
+	}
+	if errno := enforceAccessMode(tfs.interceptor, tfs.guardPoint, m, uid, binary); errno != 0 {
+		return nil, nil, 0, errno
+	}
+
 	op := &filesystem.FileOperation{
 		Type:   "write", // Changed from "create" to "write" to match policy actions
 		Path:   virtualPath,
@@ -132,10 +150,38 @@ func (tfs *TransparentFS) Create(ctx context.Context, name string, flags uint32,
 		Binary: binary,
 	}
 
-	result, err := tfs.interceptor.InterceptWrite(ctx, op)
-	if err != nil || !result.Allowed {
+	authorized, err := tfs.interceptor.AuthorizeWrite(op)
+	if err != nil || !authorized.Allowed {
 		log.Printf("[FUSE] Create denied: %v", err)
-		return nil, nil, 0, syscall.EACCES
+		return nil, nil, 0, denyErrno(tfs.interceptor, tfs.guardPoint, authorized)
+	}
+
+	// Reserve before the interceptor creates the backing file: an inode
+	// and the nonce and tag of an empty encrypted file
+	oldOwner, oldSize, existed := backingUsage(backingPath)
+	var reservedBytes, reservedInodes int64
+	if !existed {
+		reservedBytes, reservedInodes = crypto.Overhead, 1
+		if errno := reserveQuota(tfs.quota, tfs.interceptor, "create", virtualPath, uid, uid, binary, reservedBytes, reservedInodes); errno != 0 {
+			return nil, nil, 0, errno
+		}
+	}
+	defer func() {
+		// Give the reservation back and charge the difference the create
+		// actually made, whether or not it succeeded
+		tfs.quota.Charge(uid, -reservedBytes, -reservedInodes)
+		if existed {
+			tfs.quota.Charge(oldOwner, -oldSize, -1)
+		}
+		if owner, size, ok := backingUsage(backingPath); ok {
+			tfs.quota.Charge(owner, size, 1)
+		}
+	}()
+
+	result, err := tfs.interceptor.WriteAuthorized(op, authorized)
+	if err != nil || !result.Allowed {
+		log.Printf("[FUSE] Create failed: %v", err)
+		return nil, nil, 0, denyErrno(tfs.interceptor, tfs.guardPoint, result)
 	}
 
 	// Ensure parent directory exists
@@ -171,6 +217,7 @@ func (tfs *TransparentFS) Create(ctx context.Context, name string, flags uint32,
 	child := &TransparentFile{
 		interceptor: tfs.interceptor,
 		guardPoint:  tfs.guardPoint,
+		quota:       tfs.quota,
 		virtualPath: virtualPath,
 		backingPath: backingPath,
 	}
@@ -199,25 +246,48 @@ func (tfs *TransparentFS) Create(ctx context.Context, name string, flags uint32,
 		file:        file,
 		interceptor: tfs.interceptor,
 		guardPoint:  tfs.guardPoint,
+		quota:       tfs.quota,
 		virtualPath: virtualPath,
 		backingPath: backingPath,
 	}
+	fileHandle.pin(&fileHandle.writeDecision, result)
 
 	log.Printf("[FUSE] Create successful: virtual=%s, backing=%s", virtualPath, backingPath)
 	log.Printf("[FUSE] Create: ========== FILE CREATE END ==========")
-	return tfs.NewInode(ctx, child, stable), fileHandle, 0, 0
+	return tfs.NewInode(ctx, child, stable), fileHandle, openFlags(tfs.guardPoint), 0
 }
 
 func (tfs *TransparentFS) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	backingPath := filepath.Join(tfs.backingPath, name)
 
+	virtualPath := filepath.Join(tfs.getVirtualPath(), name)
+	uid, gid, pid := getRealUserContext(ctx)
+	binary := getProcessBinaryFromPid(pid)
+
+	m := &mutation{op: mutMkdir, virtualPath: virtualPath, backingPath: backingPath}
+	if errno := enforceAccessMode(tfs.interceptor, tfs.guardPoint, m, uid, binary); errno != 0 {
+		return nil, errno
+	}
+	if errno := reserveQuota(tfs.quota, tfs.interceptor, "mkdir", virtualPath, uid, uid, binary, 0, 1); errno != 0 {
+		return nil, errno
+	}
+	defer tfs.quota.Charge(uid, 0, -1)
+
 	if err := os.MkdirAll(backingPath, os.FileMode(mode)); err != nil {
 		return nil, syscall.EIO
 	}
+	// Like files, directories belong to the requesting user
+	if err := os.Chown(backingPath, uid, gid); err != nil {
+		log.Printf("[FUSE] Warning: Could not set backing store ownership: %v", err)
+	}
+	if owner, _, ok := backingUsage(backingPath); ok {
+		tfs.quota.Charge(owner, 0, 1)
+	}
 
 	child := &TransparentFS{
 		interceptor: tfs.interceptor,
 		guardPoint:  tfs.guardPoint,
+		quota:       tfs.quota,
 		backingPath: backingPath,
 	}
 
@@ -234,17 +304,29 @@ func (tfs *TransparentFS) Mkdir(ctx context.Context, name string, mode uint32, o
 
 func (tfs *TransparentFS) Rmdir(ctx context.Context, name string) syscall.Errno {
 	backingPath := filepath.Join(tfs.backingPath, name)
+	if errno := tfs.enforceEntryMode(ctx, mutRmdir, name); errno != 0 {
+		return errno
+	}
+
+	owner, _, _ := backingUsage(backingPath)
 	if err := os.Remove(backingPath); err != nil {
 		return syscall.EIO
 	}
+	tfs.quota.Charge(owner, 0, -1)
 	return 0
 }
 
 func (tfs *TransparentFS) Unlink(ctx context.Context, name string) syscall.Errno {
 	backingPath := filepath.Join(tfs.backingPath, name)
+	if errno := tfs.enforceEntryMode(ctx, mutUnlink, name); errno != 0 {
+		return errno
+	}
+
+	owner, size, _ := backingUsage(backingPath)
 	if err := os.Remove(backingPath); err != nil {
 		return syscall.EIO
 	}
+	tfs.quota.Charge(owner, -size, -1)
 	return 0
 }
 
@@ -279,9 +361,9 @@ func (tfs *TransparentFS) Readdir(ctx context.Context) (fs.DirStream, syscall.Er
 	result, err := tfs.interceptor.InterceptList(ctx, op)
 	log.Printf("[FUSE] Readdir: Interceptor response - allowed=%v, err=%v", result.Allowed, err)
 	if err != nil || !result.Allowed {
-		log.Printf("[FUSE] Readdir: ACCESS DENIED - returning EACCES")
+		log.Printf("[FUSE] Readdir: ACCESS DENIED (%s)", result.Reason)
 		log.Printf("[FUSE] ========== READDIR OPERATION END (DENIED) ==========")
-		return nil, syscall.EACCES
+		return nil, denyErrno(tfs.interceptor, tfs.guardPoint, result)
 	}
 
 	log.Printf("[FUSE] Readdir: ACCESS GRANTED - reading directory %s", tfs.backingPath)
@@ -333,6 +415,20 @@ func (tfs *TransparentFS) Getattr(ctx context.Context, fh fs.FileHandle, out *fu
 }
 
 func (tfs *TransparentFS) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	uid, _, pid := getRealUserContext(ctx)
+	if in.Valid&fuse.FATTR_MODE != 0 {
+		m := &mutation{op: mutChmod, virtualPath: tfs.getVirtualPath(), backingPath: tfs.backingPath}
+		if errno := enforceAccessMode(tfs.interceptor, tfs.guardPoint, m, uid, getProcessBinaryFromPid(pid)); errno != 0 {
+			return errno
+		}
+	}
+	if in.Valid&fuse.FATTR_SIZE != 0 {
+		m := &mutation{op: mutTruncate, virtualPath: tfs.getVirtualPath(), backingPath: tfs.backingPath, size: int64(in.Size)}
+		if errno := enforceAccessMode(tfs.interceptor, tfs.guardPoint, m, uid, getProcessBinaryFromPid(pid)); errno != 0 {
+			return errno
+		}
+	}
+
 	if in.Valid&fuse.FATTR_MODE != 0 {
 		if err := os.Chmod(tfs.backingPath, os.FileMode(in.Mode)); err != nil {
 			return syscall.EIO
@@ -368,6 +464,15 @@ func (tfs *TransparentFS) Rename(ctx context.Context, name string, newParent fs.
 
 	log.Printf("[FUSE] Rename: from=%s to=%s, uid=%d, pid=%d, binary=%s", oldVirtualPath, newVirtualPath, uid, pid, binary)
 
+	for _, m := range []*mutation{
+		{op: mutRename, virtualPath: oldVirtualPath, backingPath: oldBackingPath},
+		{op: mutRename, virtualPath: newVirtualPath, backingPath: newBackingPath},
+	} {
+		if errno := enforceAccessMode(tfs.interceptor, tfs.guardPoint, m, uid, binary); errno != 0 {
+			return errno
+		}
+	}
+
 	// Check permissions for both source and destination
 	writeOp := &filesystem.FileOperation{
 		Type:   "write",
@@ -378,17 +483,25 @@ func (tfs *TransparentFS) Rename(ctx context.Context, name string, newParent fs.
 		Binary: binary,
 	}
 
-	result, err := tfs.interceptor.InterceptWrite(ctx, writeOp)
+	// Only authorize: a rename writes no data, and writing would create
+	// the destination before its usage is read
+	result, err := tfs.interceptor.AuthorizeWrite(writeOp)
 	if err != nil || !result.Allowed {
 		log.Printf("[FUSE] Rename denied: %v", err)
-		return syscall.EACCES
+		return denyErrno(tfs.interceptor, tfs.guardPoint, result)
 	}
 
+	// Renaming over an existing file releases the replaced file's usage
+	replacedOwner, replacedSize, replaced := backingUsage(newBackingPath)
+
 	// Perform the rename operation
 	if err := os.Rename(oldBackingPath, newBackingPath); err != nil {
 		log.Printf("[FUSE] Rename failed: %v", err)
 		return syscall.EIO
 	}
+	if replaced {
+		tfs.quota.Charge(replacedOwner, -replacedSize, -1)
+	}
 
 	log.Printf("[FUSE] Rename successful")
 	return 0
@@ -413,6 +526,18 @@ func (tfs *TransparentFS) Fsync(ctx context.Context, fh fs.FileHandle, flags uin
 	return 0
 }
 
+// enforceEntryMode applies the access mode to an operation on the named
+// child of this directory.
+func (tfs *TransparentFS) enforceEntryMode(ctx context.Context, op, name string) syscall.Errno {
+	uid, _, pid := getRealUserContext(ctx)
+	m := &mutation{
+		op:          op,
+		virtualPath: filepath.Join(tfs.getVirtualPath(), name),
+		backingPath: filepath.Join(tfs.backingPath, name),
+	}
+	return enforceAccessMode(tfs.interceptor, tfs.guardPoint, m, uid, getProcessBinaryFromPid(pid))
+}
+
 func (tfs *TransparentFS) getVirtualPath() string {
 	// Clean paths to handle any path inconsistencies
 	guardSecurePath := filepath.Clean(tfs.guardPoint.SecureStoragePath)
@@ -0,0 +1,248 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+)
+
+// Mount states reported by Status.
+const (
+	MountStateMounted    = "mounted"
+	MountStateFailed     = "failed"
+	MountStateRemounting = "remounting"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 10 * time.Second
+	minRemountBackoff   = 1 * time.Second
+	maxRemountBackoff   = 60 * time.Second
+)
+
+// MountStatus is a point-in-time view of one supervised guard point mount.
+type MountStatus struct {
+	GuardPoint  string    `json:"guard_point"`
+	MountPoint  string    `json:"mount_point"`
+	StoragePath string    `json:"secure_storage_path"`
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+	Restarts    int       `json:"restarts"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Status reports the supervision state of every mount, ordered by guard
+// point code.
+func (mm *MountManager) Status() []MountStatus {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	statuses := make([]MountStatus, 0, len(mm.mounts))
+	for code, m := range mm.mounts {
+		statuses = append(statuses, MountStatus{
+			GuardPoint:  code,
+			MountPoint:  m.MountPoint,
+			StoragePath: m.GuardPoint.SecureStoragePath,
+			State:       m.state,
+			Since:       m.since,
+			Restarts:    m.restarts,
+			LastError:   m.lastError,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].GuardPoint < statuses[j].GuardPoint
+	})
+	return statuses
+}
+
+// supervise watches a mount until it is deliberately unmounted or ctx ends.
+// When the FUSE server exits on its own or the mount point goes stale
+// ("transport endpoint is not connected"), the mount is detached and
+// remounted with exponential backoff.
+func (mm *MountManager) supervise(ctx context.Context, m *Mount) {
+	defer close(m.done)
+
+	for {
+		mm.mu.Lock()
+		server := m.Server
+		mm.mu.Unlock()
+
+		exited := make(chan struct{})
+		go func() {
+			server.Wait()
+			close(exited)
+		}()
+
+		reason := mm.watch(ctx, m, exited)
+		if reason == "" {
+			return
+		}
+
+		mm.setState(m, MountStateFailed, reason)
+
+		// Release the dead server and detach the stale mount so the mount
+		// point can be reused
+		if err := server.Unmount(); err != nil {
+			log.Printf("[MOUNT] Unmount of failed server for %s: %v", m.MountPoint, err)
+		}
+		if err := lazyUnmount(m.MountPoint); err != nil {
+			log.Printf("[MOUNT] Lazy unmount of %s failed: %v", m.MountPoint, err)
+		}
+		select {
+		case <-exited:
+		case <-time.After(healthCheckTimeout):
+			log.Printf("[MOUNT] FUSE server for %s did not exit after unmount", m.MountPoint)
+		}
+
+		if !mm.remount(ctx, m) {
+			return
+		}
+	}
+}
+
+// watch blocks until the mount needs recovery, returning why, or until it
+// is stopped deliberately, returning "".
+func (mm *MountManager) watch(ctx context.Context, m *Mount, exited <-chan struct{}) string {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-exited:
+			if mm.isStopping(m) {
+				return ""
+			}
+			return "FUSE server exited unexpectedly"
+		case <-ticker.C:
+			if err := checkMountHealth(m.MountPoint); err != nil {
+				if mm.isStopping(m) {
+					return ""
+				}
+				return err.Error()
+			}
+		}
+	}
+}
+
+// remount retries mounting until it succeeds, returning false if the mount
+// was stopped or ctx ended first.
+func (mm *MountManager) remount(ctx context.Context, m *Mount) bool {
+	backoff := minRemountBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-mm.after(backoff):
+		}
+		if mm.isStopping(m) {
+			return false
+		}
+
+		mm.setState(m, MountStateRemounting, fmt.Sprintf("attempting remount after %s", backoff))
+		server, tracker, err := mm.serveMount(m.GuardPoint)
+		if err != nil {
+			mm.setState(m, MountStateFailed, fmt.Sprintf("remount failed: %v", err))
+			backoff *= 2
+			if backoff > maxRemountBackoff {
+				backoff = maxRemountBackoff
+			}
+			continue
+		}
+
+		mm.mu.Lock()
+		if m.stopping {
+			mm.mu.Unlock()
+			server.Unmount()
+			return false
+		}
+		m.Server = server
+		m.Quota = tracker
+		m.restarts++
+		mm.mu.Unlock()
+
+		mm.setState(m, MountStateMounted, "remounted")
+		return true
+	}
+}
+
+func (mm *MountManager) isStopping(m *Mount) bool {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return m.stopping
+}
+
+// setState records a state transition and reports it to the audit log.
+func (mm *MountManager) setState(m *Mount, state, detail string) {
+	mm.mu.Lock()
+	m.state = state
+	m.since = mm.now()
+	if state == MountStateFailed {
+		m.lastError = detail
+	}
+	mm.mu.Unlock()
+
+	message := fmt.Sprintf("guard point %s %s: %s", m.GuardPoint.Code, state, detail)
+	log.Printf("[MOUNT] %s", message)
+	mm.interceptor.Audit(&filesystem.AuditEvent{
+		Operation: "mount_state",
+		Path:      m.MountPoint,
+		User:      os.Getuid(),
+		Process:   "takakrypt-agent",
+		RuleID:    "mount-health",
+		Success:   state != MountStateFailed,
+	}, message)
+}
+
+// checkMountHealth stats the mount point through FUSE. A dead connection
+// shows up as ENOTCONN; a server that is merely slow is only logged, since
+// remounting a busy database would do more harm than good.
+func checkMountHealth(mountPoint string) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(mountPoint)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if errors.Is(err, syscall.ENOTCONN) {
+			return fmt.Errorf("stale mount: %w", err)
+		}
+		if err != nil {
+			log.Printf("[MOUNT] Health check stat of %s failed: %v", mountPoint, err)
+		}
+		return nil
+	case <-time.After(healthCheckTimeout):
+		log.Printf("[MOUNT] Health check of %s timed out after %s", mountPoint, healthCheckTimeout)
+		return nil
+	}
+}
+
+// lazyUnmount detaches a mount even if it is busy or its server is gone,
+// falling back to fusermount when the agent lacks CAP_SYS_ADMIN.
+func lazyUnmount(mountPoint string) error {
+	err := syscall.Unmount(mountPoint, syscall.MNT_DETACH)
+	if err == nil || err == syscall.EINVAL {
+		// EINVAL: not a mount point any more
+		return nil
+	}
+
+	for _, helper := range []string{"fusermount3", "fusermount"} {
+		out, helperErr := exec.Command(helper, "-uz", mountPoint).CombinedOutput()
+		if helperErr == nil {
+			return nil
+		}
+		log.Printf("[MOUNT] %s -uz %s failed: %v: %s", helper, mountPoint, helperErr, out)
+	}
+	return fmt.Errorf("lazy unmount of %s failed: %w", mountPoint, err)
+}
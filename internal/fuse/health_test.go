@@ -0,0 +1,223 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
+)
+
+// fakeServer is a FUSE server that runs until it crashes or is unmounted.
+type fakeServer struct {
+	once   sync.Once
+	exited chan struct{}
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{exited: make(chan struct{})}
+}
+
+func (s *fakeServer) Wait() { <-s.exited }
+
+func (s *fakeServer) Unmount() error {
+	s.crash()
+	return nil
+}
+
+func (s *fakeServer) crash() { s.once.Do(func() { close(s.exited) }) }
+
+// supervisedMount is a mount manager whose mounts and clock are fakes.
+// Mounting fails as long as failures is positive, and every wait returns
+// at once, recording how long it was meant to be.
+type supervisedMount struct {
+	mm *MountManager
+	gp *config.GuardPoint
+	at time.Time
+
+	mu       sync.Mutex
+	failures int
+	servers  []*fakeServer
+	waits    []time.Duration
+	states   []string
+	mounted  chan struct{}
+}
+
+func newSupervisedMount(t *testing.T, failures int) *supervisedMount {
+	sm := &supervisedMount{
+		gp:       &config.GuardPoint{Code: "gp", ProtectedPath: t.TempDir(), Enabled: true},
+		at:       time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC),
+		failures: failures,
+		mounted:  make(chan struct{}, 16),
+	}
+	interceptor := filesystem.NewInterceptor(policy.NewEngine(&config.Config{}), nil)
+	interceptor.SetAuditHandler(func(event *filesystem.AuditEvent, message string) {
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+		if event.Operation != "mount_state" || event.RuleID != "mount-health" || event.Path != sm.gp.ProtectedPath {
+			t.Errorf("unexpected audit event %+v", event)
+		}
+		sm.states = append(sm.states, message)
+	})
+	sm.mm = NewMountManager(interceptor, &config.Config{})
+	sm.mm.now = func() time.Time { return sm.at }
+	sm.mm.after = func(d time.Duration) <-chan time.Time {
+		sm.mu.Lock()
+		sm.waits = append(sm.waits, d)
+		sm.mu.Unlock()
+		fired := make(chan time.Time, 1)
+		fired <- sm.at
+		return fired
+	}
+	sm.mm.serveMount = func(gp *config.GuardPoint) (mountServer, *quota.Tracker, error) {
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+		if sm.failures > 0 {
+			sm.failures--
+			return nil, nil, errors.New("device busy")
+		}
+		server := newFakeServer()
+		sm.servers = append(sm.servers, server)
+		sm.mounted <- struct{}{}
+		return server, quota.NewTracker(gp, nil), nil
+	}
+	return sm
+}
+
+func (sm *supervisedMount) server(i int) *fakeServer {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.servers[i]
+}
+
+func (sm *supervisedMount) audited(message string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, m := range sm.states {
+		if m == message {
+			return true
+		}
+	}
+	return false
+}
+
+func (sm *supervisedMount) waitMounted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-sm.mounted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a mount")
+	}
+}
+
+func TestSuperviseRemountsWithBackoff(t *testing.T) {
+	// The first mount succeeds; after the crash, seven remounts fail
+	sm := newSupervisedMount(t, 0)
+	if err := sm.mm.MountGuardPoint(context.Background(), sm.gp); err != nil {
+		t.Fatal(err)
+	}
+	sm.waitMounted(t)
+	sm.mu.Lock()
+	sm.failures = 7
+	sm.mu.Unlock()
+
+	sm.server(0).crash()
+	sm.waitMounted(t)
+
+	// The mount is back once the remount is audited
+	deadline := time.Now().Add(5 * time.Second)
+	for !sm.audited("guard point gp mounted: remounted") {
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %+v, want remounted", sm.mm.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 32 * time.Second, 60 * time.Second, 60 * time.Second,
+	}
+	sm.mu.Lock()
+	waits, states := sm.waits, sm.states
+	sm.mu.Unlock()
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("backoff = %v, want %v", waits, want)
+	}
+
+	wantStates := []string{"guard point gp failed: FUSE server exited unexpectedly"}
+	for _, d := range want[:7] {
+		wantStates = append(wantStates,
+			"guard point gp remounting: attempting remount after "+d.String(),
+			"guard point gp failed: remount failed: device busy")
+	}
+	wantStates = append(wantStates,
+		"guard point gp remounting: attempting remount after 1m0s",
+		"guard point gp mounted: remounted")
+	if !reflect.DeepEqual(states, wantStates) {
+		t.Errorf("audited states:\n%q\nwant\n%q", states, wantStates)
+	}
+
+	status := sm.mm.Status()[0]
+	if status.State != MountStateMounted || status.Restarts != 1 || status.LastError != "remount failed: device busy" || !status.Since.Equal(sm.at) {
+		t.Errorf("status = %+v, want mounted after one restart, with the last failure and the fake clock", status)
+	}
+
+	// A deliberate unmount ends supervision without a remount
+	if err := sm.mm.UnmountGuardPoint("gp"); err != nil {
+		t.Fatal(err)
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if len(sm.servers) != 2 || len(sm.states) != len(wantStates) {
+		t.Errorf("%d servers and %d audited states after unmounting, want 2 and %d", len(sm.servers), len(sm.states), len(wantStates))
+	}
+}
+
+func TestSuperviseStopsDuringBackoff(t *testing.T) {
+	sm := newSupervisedMount(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := sm.mm.MountGuardPoint(ctx, sm.gp); err != nil {
+		t.Fatal(err)
+	}
+	sm.waitMounted(t)
+
+	// Remounts fail until the agent shuts down
+	sm.mu.Lock()
+	sm.failures = 1 << 30
+	sm.mu.Unlock()
+	blocked := make(chan time.Time)
+	waited := make(chan struct{}, 1)
+	sm.mm.after = func(d time.Duration) <-chan time.Time {
+		select {
+		case waited <- struct{}{}:
+		default:
+		}
+		return blocked
+	}
+
+	sm.server(0).crash()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the backoff")
+	}
+	cancel()
+
+	sm.mm.mu.Lock()
+	done := sm.mm.mounts["gp"].done
+	sm.mm.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervision did not stop with the context")
+	}
+	if status := sm.mm.Status()[0]; status.State != MountStateFailed || status.Restarts != 0 {
+		t.Errorf("status = %+v, want failed without restarts", status)
+	}
+}
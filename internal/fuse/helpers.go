@@ -44,7 +44,9 @@ func fileInfoToAttr(info os.FileInfo) fuse.Attr {
 	return attr
 }
 
-// Extract real user context from FUSE operation
+// Extract real user context from FUSE operation. The IDs are as seen in
+// the agent's user namespace; the policy engine resolves the caller's
+// container and translates them only when a configuration needs it.
 func getRealUserContext(ctx context.Context) (uid, gid, pid int) {
 	// Try to get from FUSE context
 	if caller, ok := fuse.FromContext(ctx); ok {
@@ -6,31 +6,74 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 
 	"github.com/takakrypt/transparent-encryption/internal/config"
 	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
 )
 
 type MountManager struct {
 	interceptor *filesystem.Interceptor
-	mounts      map[string]*Mount
+	config      *config.Config
+
+	mu     sync.Mutex
+	mounts map[string]*Mount
+
+	// serveMount mounts a guard point; after and now are the clock of
+	// supervision. Tests replace them.
+	serveMount func(gp *config.GuardPoint) (mountServer, *quota.Tracker, error)
+	after      func(d time.Duration) <-chan time.Time
+	now        func() time.Time
 }
 
+// mountServer is the part of *fuse.Server that supervision uses.
+type mountServer interface {
+	Wait()
+	Unmount() error
+}
+
+var _ = (mountServer)((*fuse.Server)(nil))
+
 type Mount struct {
 	GuardPoint *config.GuardPoint
-	Server     *fuse.Server
+	Server     mountServer
 	MountPoint string
+	Quota      *quota.Tracker
+
+	// Supervision state, guarded by MountManager.mu
+	state     string
+	since     time.Time
+	restarts  int
+	lastError string
+	stopping  bool
+	done      chan struct{}
 }
 
-func NewMountManager(interceptor *filesystem.Interceptor) *MountManager {
-	return &MountManager{
+func NewMountManager(interceptor *filesystem.Interceptor, cfg *config.Config) *MountManager {
+	mm := &MountManager{
 		interceptor: interceptor,
+		config:      cfg,
 		mounts:      make(map[string]*Mount),
+		after:       time.After,
+		now:         time.Now,
 	}
+	mm.serveMount = mm.serve
+	return mm
+}
+
+// SetConfig replaces the configuration used by guard points mounted from
+// now on, leaving current mounts alone.
+func (mm *MountManager) SetConfig(cfg *config.Config) {
+	mm.mu.Lock()
+	mm.config = cfg
+	mm.mu.Unlock()
 }
 
 func (mm *MountManager) MountGuardPoints(ctx context.Context, guardPoints []config.GuardPoint) error {
@@ -53,48 +96,181 @@ func (mm *MountManager) MountGuardPoints(ctx context.Context, guardPoints []conf
 }
 
 func (mm *MountManager) MountGuardPoint(ctx context.Context, gp *config.GuardPoint) error {
+	server, tracker, err := mm.serveMount(gp)
+	if err != nil {
+		return err
+	}
+
+	mount := &Mount{
+		GuardPoint: gp,
+		Server:     server,
+		MountPoint: gp.ProtectedPath,
+		Quota:      tracker,
+		state:      MountStateMounted,
+		since:      mm.now(),
+		done:       make(chan struct{}),
+	}
+
+	mm.mu.Lock()
+	mm.mounts[gp.Code] = mount
+	mm.mu.Unlock()
+
+	go mm.supervise(ctx, mount)
+
+	return nil
+}
+
+// serve mounts a fresh FUSE server for the guard point.
+func (mm *MountManager) serve(gp *config.GuardPoint) (mountServer, *quota.Tracker, error) {
 	// Create mount point directory if it doesn't exist (ignore if it already exists)
 	if err := os.MkdirAll(gp.ProtectedPath, 0755); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("failed to create mount point: %w", err)
+		return nil, nil, fmt.Errorf("failed to create mount point: %w", err)
 	}
 
 	// Create backing storage directory if it doesn't exist
 	if err := os.MkdirAll(gp.SecureStoragePath, 0755); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("failed to create backing storage: %w", err)
+		return nil, nil, fmt.Errorf("failed to create backing storage: %w", err)
 	}
 
-	root := NewTransparentFS(mm.interceptor, gp)
+	mm.mu.Lock()
+	userSets := mm.config.UserSets
+	mm.mu.Unlock()
+
+	tracker := quota.NewTracker(gp, userSets)
+	if err := tracker.Scan(); err != nil {
+		return nil, nil, fmt.Errorf("failed to compute quota usage: %w", err)
+	}
+
+	root := NewTransparentFS(mm.interceptor, gp, tracker)
 	log.Printf("[MOUNT] Created root FUSE FS for guard point: protected=%s, secure=%s", gp.ProtectedPath, gp.SecureStoragePath)
 
 	opts := &fs.Options{
-		MountOptions: fuse.MountOptions{
-			AllowOther: true,
-			Debug:      false,
-			Name:       "takakrypt-te",
-			FsName:     "takakrypt-transparent-encryption",
-		},
+		MountOptions: fuseMountOptions(gp),
 	}
+	log.Printf("[MOUNT] Mount options for %s: allow_other=%v, options=%v, max_write=%d, max_readahead=%d, sync_read=%v, debug=%v",
+		gp.Code, opts.AllowOther, opts.Options, opts.MaxWrite, opts.MaxReadAhead, opts.SyncRead, opts.Debug)
 
 	server, err := fs.Mount(gp.ProtectedPath, root, opts)
 	if err != nil {
-		return fmt.Errorf("failed to mount FUSE filesystem: %w", err)
+		return nil, nil, fmt.Errorf("failed to mount FUSE filesystem: %w", err)
 	}
 
-	mount := &Mount{
-		GuardPoint: gp,
-		Server:     server,
-		MountPoint: gp.ProtectedPath,
+	return server, tracker, nil
+}
+
+// Reconcile brings the mounted guard points in line with cfg. Guard points
+// that were removed, disabled or changed are unmounted, new and changed ones
+// are mounted, and unchanged mounts are left alone so that open files and
+// running databases are not disturbed.
+func (mm *MountManager) Reconcile(ctx context.Context, cfg *config.Config) error {
+	mm.mu.Lock()
+	mm.config = cfg
+	current := make(map[string]*config.GuardPoint, len(mm.mounts))
+	for code, mount := range mm.mounts {
+		current[code] = mount.GuardPoint
 	}
+	mm.mu.Unlock()
 
-	mm.mounts[gp.Code] = mount
+	desired := make(map[string]*config.GuardPoint)
+	for i := range cfg.GuardPoints {
+		gp := &cfg.GuardPoints[i]
+		if gp.Enabled {
+			desired[gp.Code] = gp
+		}
+	}
 
-	go server.Wait()
+	var failures []string
 
+	for code, old := range current {
+		if gp, ok := desired[code]; ok && sameMount(old, gp) {
+			continue
+		}
+		log.Printf("[MOUNT] Reconcile: unmounting removed or changed guard point %s", code)
+		if err := mm.UnmountGuardPoint(code); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	for code, gp := range desired {
+		if old, ok := current[code]; ok && sameMount(old, gp) {
+			continue
+		}
+		log.Printf("[MOUNT] Reconcile: mounting new or changed guard point %s: %s -> %s", code, gp.ProtectedPath, gp.SecureStoragePath)
+		if err := mm.MountGuardPoint(ctx, gp); err != nil {
+			failures = append(failures, fmt.Sprintf("guard point %s: %v", code, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("reconcile failed: %s", strings.Join(failures, "; "))
+	}
 	return nil
 }
 
+// sameMount reports whether two definitions of a guard point can be served
+// by the same mount. Only fields the mount itself depends on count;
+// policies, modes and metadata are read from the current configuration.
+func sameMount(a, b *config.GuardPoint) bool {
+	return a.ProtectedPath == b.ProtectedPath &&
+		a.SecureStoragePath == b.SecureStoragePath &&
+		a.Enabled == b.Enabled &&
+		a.AccessMode == b.AccessMode &&
+		a.WORMRetentionDays == b.WORMRetentionDays &&
+		reflect.DeepEqual(a.MountOptions, b.MountOptions) &&
+		reflect.DeepEqual(a.Quota, b.Quota)
+}
+
+// fuseMountOptions translates the guard point's mount_options block into
+// go-fuse options. Validation already happened in config.Load.
+func fuseMountOptions(gp *config.GuardPoint) fuse.MountOptions {
+	opts := fuse.MountOptions{
+		AllowOther: true,
+		Debug:      false,
+		Name:       "takakrypt-te",
+		FsName:     "takakrypt-transparent-encryption",
+	}
+
+	mo := gp.MountOptions
+	if mo == nil {
+		return opts
+	}
+
+	if mo.AllowOther != nil {
+		opts.AllowOther = *mo.AllowOther
+	}
+	if mo.DefaultPermissions {
+		opts.Options = append(opts.Options, "default_permissions")
+	}
+	if mo.ReadOnly {
+		opts.Options = append(opts.Options, "ro")
+	}
+	if mo.AsyncRead != nil {
+		opts.SyncRead = !*mo.AsyncRead
+	}
+	opts.MaxWrite = mo.MaxWrite
+	opts.MaxReadAhead = mo.MaxReadahead
+	opts.Debug = mo.Debug
+
+	return opts
+}
+
+// openFlags returns the FOPEN_* flags for file handles on the guard point.
+func openFlags(gp *config.GuardPoint) uint32 {
+	if gp.MountOptions != nil && gp.MountOptions.DirectIO {
+		return fuse.FOPEN_DIRECT_IO
+	}
+	return 0
+}
+
 func (mm *MountManager) UnmountAll() error {
+	mm.mu.Lock()
+	codes := make([]string, 0, len(mm.mounts))
 	for code := range mm.mounts {
+		codes = append(codes, code)
+	}
+	mm.mu.Unlock()
+
+	for _, code := range codes {
 		if err := mm.UnmountGuardPoint(code); err != nil {
 			log.Printf("Failed to unmount guard point %s: %v", code, err)
 		}
@@ -103,23 +279,45 @@ func (mm *MountManager) UnmountAll() error {
 }
 
 func (mm *MountManager) UnmountGuardPoint(code string) error {
+	mm.mu.Lock()
 	mount, exists := mm.mounts[code]
 	if !exists {
+		mm.mu.Unlock()
 		return fmt.Errorf("guard point %s not mounted", code)
 	}
+	mount.stopping = true
+	server := mount.Server
+	mm.mu.Unlock()
 
-	if err := mount.Server.Unmount(); err != nil {
-		return fmt.Errorf("failed to unmount %s: %w", mount.MountPoint, err)
+	if err := server.Unmount(); err != nil {
+		// A crashed server can't unmount cleanly; detach the mount instead
+		log.Printf("[MOUNT] Clean unmount of %s failed, detaching: %v", mount.MountPoint, err)
+		if err := lazyUnmount(mount.MountPoint); err != nil {
+			mm.mu.Lock()
+			mount.stopping = false
+			mm.mu.Unlock()
+			return fmt.Errorf("failed to unmount %s: %w", mount.MountPoint, err)
+		}
 	}
+	<-mount.done
 
+	mm.mu.Lock()
 	delete(mm.mounts, code)
+	mm.mu.Unlock()
 	log.Printf("Unmounted guard point: %s", mount.MountPoint)
 
 	return nil
 }
 
 func (mm *MountManager) GetMountInfo() map[string]*Mount {
-	return mm.mounts
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mounts := make(map[string]*Mount, len(mm.mounts))
+	for code, mount := range mm.mounts {
+		mounts[code] = mount
+	}
+	return mounts
 }
 
 func (mm *MountManager) IsMounted(path string) bool {
@@ -128,6 +326,9 @@ func (mm *MountManager) IsMounted(path string) bool {
 		return false
 	}
 
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
 	for _, mount := range mm.mounts {
 		mountPath, err := filepath.Abs(mount.MountPoint)
 		if err != nil {
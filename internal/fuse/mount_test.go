@@ -0,0 +1,100 @@
+package fuse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func TestSameMount(t *testing.T) {
+	base := config.GuardPoint{
+		Code:              "gp",
+		ProtectedPath:     "/data",
+		SecureStoragePath: "/secure/data",
+		Policy:            "p1",
+		Enabled:           true,
+	}
+	tests := []struct {
+		name   string
+		change func(gp *config.GuardPoint)
+		want   bool
+	}{
+		{"unchanged", func(gp *config.GuardPoint) {}, true},
+		{"policy", func(gp *config.GuardPoint) { gp.Policy = "p2" }, true},
+		{"policies", func(gp *config.GuardPoint) { gp.Policy = ""; gp.Policies = []string{"p1", "p2"} }, true},
+		{"learn mode", func(gp *config.GuardPoint) { gp.Mode = config.PolicyModeLearn }, true},
+		{"errno mode", func(gp *config.GuardPoint) { gp.ErrnoMode = config.ErrnoModeReason }, true},
+		{"metadata", func(gp *config.GuardPoint) { gp.Name = "renamed"; gp.UpdatedAt = 42 }, true},
+		{"protected path", func(gp *config.GuardPoint) { gp.ProtectedPath = "/data2" }, false},
+		{"secure storage", func(gp *config.GuardPoint) { gp.SecureStoragePath = "/secure/data2" }, false},
+		{"access mode", func(gp *config.GuardPoint) { gp.AccessMode = config.AccessModeReadOnly }, false},
+		{"mount options", func(gp *config.GuardPoint) { gp.MountOptions = &config.MountOptions{DirectIO: true} }, false},
+		{"quota", func(gp *config.GuardPoint) { gp.Quota = &config.GuardPointQuota{MaxBytes: 1} }, false},
+		{"disabled", func(gp *config.GuardPoint) { gp.Enabled = false }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			tt.change(&changed)
+			if got := sameMount(&base, &changed); got != tt.want {
+				t.Errorf("sameMount = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuseMountOptions(t *testing.T) {
+	on, off := true, false
+	defaults := fuse.MountOptions{AllowOther: true, Name: "takakrypt-te", FsName: "takakrypt-transparent-encryption"}
+	tests := []struct {
+		name  string
+		mo    *config.MountOptions
+		want  func(opts *fuse.MountOptions)
+		flags uint32
+	}{
+		{"none", nil, func(opts *fuse.MountOptions) {}, 0},
+		{"empty", &config.MountOptions{}, func(opts *fuse.MountOptions) {}, 0},
+		{"allow_other off", &config.MountOptions{AllowOther: &off}, func(opts *fuse.MountOptions) { opts.AllowOther = false }, 0},
+		{"allow_other on", &config.MountOptions{AllowOther: &on}, func(opts *fuse.MountOptions) {}, 0},
+		{"default_permissions and ro", &config.MountOptions{DefaultPermissions: true, ReadOnly: true}, func(opts *fuse.MountOptions) {
+			opts.Options = []string{"default_permissions", "ro"}
+		}, 0},
+		{"sync reads", &config.MountOptions{AsyncRead: &off}, func(opts *fuse.MountOptions) { opts.SyncRead = true }, 0},
+		{"async reads", &config.MountOptions{AsyncRead: &on}, func(opts *fuse.MountOptions) {}, 0},
+		{"sizes and debug", &config.MountOptions{MaxWrite: 131072, MaxReadahead: 65536, Debug: true}, func(opts *fuse.MountOptions) {
+			opts.MaxWrite, opts.MaxReadAhead, opts.Debug = 131072, 65536, true
+		}, 0},
+		{"direct_io", &config.MountOptions{DirectIO: true}, func(opts *fuse.MountOptions) {}, fuse.FOPEN_DIRECT_IO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := &config.GuardPoint{Code: "gp", MountOptions: tt.mo}
+			want := defaults
+			tt.want(&want)
+			if got := fuseMountOptions(gp); !reflect.DeepEqual(got, want) {
+				t.Errorf("fuseMountOptions = %+v, want %+v", got, want)
+			}
+			if got := openFlags(gp); got != tt.flags {
+				t.Errorf("openFlags = %#x, want %#x", got, tt.flags)
+			}
+		})
+	}
+
+	// writeback_cache never reaches the mount: the configuration is
+	// refused before anything is mounted
+	gp := config.GuardPoint{Code: "gp", ProtectedPath: "/data", SecureStoragePath: "/secure/data", Policy: "p", MountOptions: &config.MountOptions{WritebackCache: true}}
+	findings := config.Lint(&config.Config{GuardPoints: []config.GuardPoint{gp}, Policies: []config.Policy{{Code: "p"}}})
+	found := false
+	for _, f := range findings {
+		if strings.Contains(f.Message, "writeback_cache") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Lint findings = %+v, want writeback_cache refused", findings)
+	}
+}
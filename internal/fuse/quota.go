@@ -0,0 +1,49 @@
+package fuse
+
+import (
+	"log"
+	"os"
+	"syscall"
+
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
+)
+
+// reserveQuota charges owner for the given deltas if the tracker allows
+// it. The caller settles the reservation with Charge once the operation is
+// done. A violation is audited and mapped to EDQUOT.
+func reserveQuota(tracker *quota.Tracker, interceptor *filesystem.Interceptor, operation, virtualPath string, owner, uid int, binary string, bytes, inodes int64) syscall.Errno {
+	err := tracker.Reserve(owner, bytes, inodes)
+	if err == nil {
+		return 0
+	}
+
+	log.Printf("[QUOTA] %s denied for %s: %v", operation, virtualPath, err)
+	interceptor.Audit(&filesystem.AuditEvent{
+		Operation:  operation,
+		Path:       virtualPath,
+		User:       uid,
+		Process:    binary,
+		Permission: "deny",
+		RuleID:     "quota",
+		Success:    false,
+	}, err.Error())
+	return syscall.EDQUOT
+}
+
+// backingUsage returns the owner and on-disk size of a backing file, or
+// ok=false if it does not exist.
+func backingUsage(path string) (owner int, size int64, ok bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return -1, 0, false
+	}
+	owner = -1
+	if stat, isStat := info.Sys().(*syscall.Stat_t); isStat {
+		owner = int(stat.Uid)
+	}
+	if info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	return owner, size, true
+}
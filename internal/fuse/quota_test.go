@@ -0,0 +1,251 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+	"github.com/takakrypt/transparent-encryption/internal/filesystem"
+	"github.com/takakrypt/transparent-encryption/internal/policy"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+const testUID = 1000
+
+// testFS is the root of an unmounted guard point over a temporary
+// directory, keyed from a keys.json in the same directory. Its operations
+// are called directly, as testUID.
+type testFS struct {
+	root       *TransparentFS
+	engine     *policy.Engine
+	guardPoint *config.GuardPoint
+	cryptoSvc  *crypto.Service
+	tracker    *quota.Tracker
+
+	mu     sync.Mutex
+	audits []*filesystem.AuditEvent
+}
+
+func newTestFS(t *testing.T, gp config.GuardPoint, rules ...config.SecurityRule) *testFS {
+	t.Helper()
+	dir := t.TempDir()
+	gp.ID, gp.Code, gp.Policy, gp.Enabled = "gp-1", "gp", "p", true
+	gp.ProtectedPath = filepath.Join(dir, "protected")
+	gp.SecureStoragePath = filepath.Join(dir, "storage")
+	if err := os.MkdirAll(gp.SecureStoragePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ := json.Marshal([]crypto.KeyMetadata{{
+		ID:           "key-1",
+		Type:         "AES256-GCM",
+		GuardPointID: "gp-1",
+		KeyMaterial:  base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)),
+		Status:       "active",
+	}})
+	keysFile := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(keysFile, keys, 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := crypto.NewFileKeyProvider(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tfs := &testFS{guardPoint: &gp, cryptoSvc: crypto.NewService(provider)}
+	tfs.engine = policy.NewEngine(&config.Config{
+		GuardPoints: []config.GuardPoint{gp},
+		Policies:    []config.Policy{{Code: "p", SecurityRules: rules}},
+	})
+	interceptor := filesystem.NewInterceptor(tfs.engine, tfs.cryptoSvc)
+	interceptor.SetAuditHandler(func(event *filesystem.AuditEvent, message string) {
+		tfs.mu.Lock()
+		defer tfs.mu.Unlock()
+		tfs.audits = append(tfs.audits, event)
+	})
+	tfs.tracker = quota.NewTracker(&gp, nil)
+	tfs.root = NewTransparentFS(interceptor, tfs.guardPoint, tfs.tracker)
+	// Give the root an inode, as mounting would
+	fs.NewNodeFS(tfs.root, &fs.Options{})
+	return tfs
+}
+
+// permitAll is a rule permitting every action with the key applied.
+var permitAll = config.SecurityRule{
+	ID:     "all",
+	Order:  1,
+	Action: []string{"all_ops"},
+	Effect: config.RuleEffect{Permission: "permit", Option: config.EffectOption{ApplyKey: true}},
+}
+
+func callerContext() context.Context {
+	return fuse.NewContext(context.Background(), &fuse.Caller{
+		Owner: fuse.Owner{Uid: testUID, Gid: testUID},
+		Pid:   uint32(os.Getpid()),
+	})
+}
+
+func (tfs *testFS) create(t *testing.T, name string) (*TransparentFileHandle, syscall.Errno) {
+	t.Helper()
+	_, fh, _, errno := tfs.root.Create(callerContext(), name, syscall.O_WRONLY|syscall.O_CREAT, 0644, &fuse.EntryOut{})
+	if errno != 0 {
+		return nil, errno
+	}
+	handle := fh.(*TransparentFileHandle)
+	t.Cleanup(func() { handle.file.Close() })
+	return handle, 0
+}
+
+func (tfs *testFS) file(name string) *TransparentFile {
+	return &TransparentFile{
+		interceptor: tfs.root.interceptor,
+		guardPoint:  tfs.guardPoint,
+		quota:       tfs.tracker,
+		virtualPath: filepath.Join(tfs.guardPoint.ProtectedPath, name),
+		backingPath: filepath.Join(tfs.guardPoint.SecureStoragePath, name),
+	}
+}
+
+func (tfs *testFS) usage() quota.Usage {
+	_, usage := tfs.tracker.Effective(testUID)
+	return usage
+}
+
+func (tfs *testFS) plaintext(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(tfs.guardPoint.SecureStoragePath, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := tfs.cryptoSvc.DecryptForGuardPoint(data, "gp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func userQuota(maxBytes, maxInodes int64) *config.GuardPointQuota {
+	uid := testUID
+	return &config.GuardPointQuota{Users: []config.UserQuota{{UID: &uid, MaxBytes: maxBytes, MaxInodes: maxInodes}}}
+}
+
+func TestCreateChargesQuota(t *testing.T) {
+	tfs := newTestFS(t, config.GuardPoint{Quota: userQuota(0, 1)}, permitAll)
+
+	if _, errno := tfs.create(t, "a"); errno != 0 {
+		t.Fatalf("Create a: %v", errno)
+	}
+	if got, want := tfs.usage(), (quota.Usage{Bytes: crypto.Overhead, Inodes: 1}); got != want {
+		t.Errorf("usage after create = %+v, want %+v", got, want)
+	}
+	if _, errno := tfs.create(t, "b"); errno != syscall.EDQUOT {
+		t.Errorf("Create b = %v, want EDQUOT", errno)
+	}
+	if _, err := os.Lstat(filepath.Join(tfs.guardPoint.SecureStoragePath, "b")); !os.IsNotExist(err) {
+		t.Errorf("refused create left a backing file: %v", err)
+	}
+	if got, want := tfs.usage(), (quota.Usage{Bytes: crypto.Overhead, Inodes: 1}); got != want {
+		t.Errorf("usage after refused create = %+v, want %+v", got, want)
+	}
+}
+
+func TestRenameKeepsUsage(t *testing.T) {
+	tfs := newTestFS(t, config.GuardPoint{Quota: userQuota(0, 2)}, permitAll)
+
+	if _, errno := tfs.create(t, "a"); errno != 0 {
+		t.Fatalf("Create a: %v", errno)
+	}
+	if _, errno := tfs.create(t, "b"); errno != 0 {
+		t.Fatalf("Create b: %v", errno)
+	}
+	// Renaming to a new name neither charges nor releases anything
+	if errno := tfs.root.Rename(callerContext(), "a", tfs.root, "c", 0); errno != 0 {
+		t.Fatalf("Rename a to c: %v", errno)
+	}
+	if got, want := tfs.usage(), (quota.Usage{Bytes: 2 * crypto.Overhead, Inodes: 2}); got != want {
+		t.Errorf("usage after rename = %+v, want %+v", got, want)
+	}
+	// Renaming over an existing file releases the replaced one
+	if errno := tfs.root.Rename(callerContext(), "c", tfs.root, "b", 0); errno != 0 {
+		t.Fatalf("Rename c to b: %v", errno)
+	}
+	if got, want := tfs.usage(), (quota.Usage{Bytes: crypto.Overhead, Inodes: 1}); got != want {
+		t.Errorf("usage after replacing rename = %+v, want %+v", got, want)
+	}
+	if got := tfs.plaintext(t, "b"); len(got) != 0 {
+		t.Errorf("renamed file = %q, want empty", got)
+	}
+}
+
+func TestTruncateChecksQuota(t *testing.T) {
+	tfs := newTestFS(t, config.GuardPoint{Quota: userQuota(100, 0)}, permitAll)
+
+	if _, errno := tfs.create(t, "f"); errno != 0 {
+		t.Fatalf("Create: %v", errno)
+	}
+	f := tfs.file("f")
+	grow := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: 1000}}
+	if errno := f.Setattr(callerContext(), nil, grow, &fuse.AttrOut{}); errno != syscall.EDQUOT {
+		t.Errorf("growing past the quota = %v, want EDQUOT", errno)
+	}
+	if got, want := tfs.usage(), (quota.Usage{Bytes: crypto.Overhead, Inodes: 1}); got != want {
+		t.Errorf("usage after refused truncate = %+v, want %+v", got, want)
+	}
+
+	grow.Size = 50
+	if errno := f.Setattr(callerContext(), nil, grow, &fuse.AttrOut{}); errno != 0 {
+		t.Fatalf("growing within the quota: %v", errno)
+	}
+	if got, want := tfs.usage(), (quota.Usage{Bytes: 50 + crypto.Overhead, Inodes: 1}); got != want {
+		t.Errorf("usage after truncate = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteAuditsLearned(t *testing.T) {
+	tfs := newTestFS(t, config.GuardPoint{Mode: config.PolicyModeLearn}, config.SecurityRule{
+		ID:     "no-writes",
+		Order:  1,
+		Action: []string{"write"},
+		Effect: config.RuleEffect{Permission: "deny"},
+	})
+
+	fh, errno := tfs.create(t, "f")
+	if errno != 0 {
+		t.Fatalf("Create: %v", errno)
+	}
+	if _, errno := fh.Write(callerContext(), []byte("hello"), 0); errno != 0 {
+		t.Fatalf("Write: %v", errno)
+	}
+	if got := tfs.plaintext(t, "f"); string(got) != "hello" {
+		t.Errorf("plaintext = %q, want %q", got, "hello")
+	}
+
+	var learned int
+	for _, event := range tfs.audits {
+		if event.Learned && event.Action == "write" && event.RuleID == "no-writes" {
+			learned++
+		}
+	}
+	// One for the create and one for the write
+	if learned != 2 {
+		t.Errorf("got %d learned write audits, want 2: %+v", learned, tfs.audits)
+	}
+}
@@ -0,0 +1,91 @@
+package fuse
+
+import (
+	"context"
+	"log"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+	"github.com/takakrypt/transparent-encryption/internal/quota"
+)
+
+var _ = (fs.NodeStatfser)((*TransparentFS)(nil))
+var _ = (fs.NodeStatfser)((*TransparentFile)(nil))
+
+func (tfs *TransparentFS) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	uid, _, _ := getRealUserContext(ctx)
+	return statfsGuardPoint(tfs.guardPoint, tfs.quota, uid, out)
+}
+
+func (tf *TransparentFile) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	uid, _, _ := getRealUserContext(ctx)
+	return statfsGuardPoint(tf.guardPoint, tf.quota, uid, out)
+}
+
+// statfsGuardPoint reports the capacity of the filesystem backing the guard
+// point's secure storage, as seen through the encryption layer: every new
+// file costs crypto.Overhead bytes more than its plaintext, and the quota
+// that binds the caller most tightly caps the reported size.
+func statfsGuardPoint(gp *config.GuardPoint, tracker *quota.Tracker, uid int, out *fuse.StatfsOut) syscall.Errno {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(gp.SecureStoragePath, &st); err != nil {
+		log.Printf("[FUSE] Statfs: statfs failed for %s: %v", gp.SecureStoragePath, err)
+		if errno, ok := err.(syscall.Errno); ok {
+			return errno
+		}
+		return syscall.EIO
+	}
+	out.FromStatfsT(&st)
+
+	bsize := uint64(out.Bsize)
+	if bsize == 0 {
+		bsize = 4096
+		out.Bsize = uint32(bsize)
+	}
+
+	// The largest file an application can still create loses one header's
+	// worth of space to the nonce and tag.
+	overheadBlocks := (uint64(crypto.Overhead) + bsize - 1) / bsize
+	out.Bavail = subClamp(out.Bavail, overheadBlocks)
+	out.Bfree = subClamp(out.Bfree, overheadBlocks)
+
+	limits, usage := tracker.Effective(uid)
+	if limits.Bytes > 0 {
+		limitBlocks := uint64(limits.Bytes) / bsize
+		usedBlocks := (uint64(usage.Bytes) + bsize - 1) / bsize
+		freeBlocks := subClamp(subClamp(limitBlocks, usedBlocks), overheadBlocks)
+
+		if limitBlocks < out.Blocks {
+			out.Blocks = limitBlocks
+		}
+		if freeBlocks < out.Bfree {
+			out.Bfree = freeBlocks
+		}
+		if freeBlocks < out.Bavail {
+			out.Bavail = freeBlocks
+		}
+	}
+	if limits.Inodes > 0 {
+		freeInodes := subClamp(uint64(limits.Inodes), uint64(usage.Inodes))
+		if uint64(limits.Inodes) < out.Files {
+			out.Files = uint64(limits.Inodes)
+		}
+		if freeInodes < out.Ffree {
+			out.Ffree = freeInodes
+		}
+	}
+	log.Printf("[FUSE] Statfs: guard point %s uid=%d limits=%+v usage=%+v", gp.Code, uid, limits, usage)
+
+	return 0
+}
+
+func subClamp(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
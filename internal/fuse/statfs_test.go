@@ -0,0 +1,120 @@
+package fuse
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+)
+
+func TestStatfsGuardPoint(t *testing.T) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(t.TempDir(), &st); err != nil {
+		t.Fatal(err)
+	}
+	bsize := int64(st.Bsize)
+	if bsize == 0 {
+		bsize = 4096
+	}
+	// The header of the largest file still to be created
+	overheadBlocks := uint64((crypto.Overhead + bsize - 1) / bsize)
+
+	tests := []struct {
+		name          string
+		maxBytes      int64
+		maxInodes     int64
+		usedBytes     int64
+		usedInodes    int64
+		blocks, bfree uint64
+		files, ffree  uint64
+	}{
+		{
+			name:     "empty",
+			maxBytes: 100 * bsize, maxInodes: 10,
+			blocks: 100, bfree: 100 - overheadBlocks, files: 10, ffree: 10,
+		},
+		{
+			name:     "partly used",
+			maxBytes: 100 * bsize, maxInodes: 10,
+			// Partly used blocks count as used
+			usedBytes: 20*bsize + 1, usedInodes: 3,
+			blocks: 100, bfree: 100 - 21 - overheadBlocks, files: 10, ffree: 7,
+		},
+		{
+			name:     "full",
+			maxBytes: 100 * bsize, maxInodes: 10,
+			usedBytes: 100*bsize - 1, usedInodes: 10,
+			blocks: 100, bfree: 0, files: 10, ffree: 0,
+		},
+		{
+			name:     "over",
+			maxBytes: 100 * bsize, maxInodes: 10,
+			usedBytes: 200 * bsize, usedInodes: 20,
+			blocks: 100, bfree: 0, files: 10, ffree: 0,
+		},
+		{
+			name:     "bytes only",
+			maxBytes: 50 * bsize,
+			blocks:   50, bfree: 50 - overheadBlocks,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tfs := newTestFS(t, config.GuardPoint{Quota: userQuota(tt.maxBytes, tt.maxInodes)})
+			tfs.tracker.Charge(testUID, tt.usedBytes, tt.usedInodes)
+
+			var out fuse.StatfsOut
+			if errno := tfs.root.Statfs(callerContext(), &out); errno != 0 {
+				t.Fatalf("Statfs: %v", errno)
+			}
+			if out.Blocks != tt.blocks || out.Bfree != tt.bfree || out.Bavail != tt.bfree {
+				t.Errorf("blocks = %d, bfree = %d, bavail = %d; want %d, %d, %d", out.Blocks, out.Bfree, out.Bavail, tt.blocks, tt.bfree, tt.bfree)
+			}
+			if tt.maxInodes > 0 && (out.Files != tt.files || out.Ffree != tt.ffree) {
+				t.Errorf("files = %d, ffree = %d; want %d, %d", out.Files, out.Ffree, tt.files, tt.ffree)
+			}
+			if tt.maxInodes == 0 && out.Files < out.Ffree {
+				t.Errorf("files = %d below ffree = %d", out.Files, out.Ffree)
+			}
+
+			// Other users are not bound by the quota
+			var other fuse.StatfsOut
+			if errno := statfsGuardPoint(tfs.guardPoint, tfs.tracker, testUID+1, &other); errno != 0 {
+				t.Fatalf("statfsGuardPoint: %v", errno)
+			}
+			if other.Blocks <= tt.blocks {
+				t.Errorf("blocks for another user = %d, want those of the filesystem", other.Blocks)
+			}
+		})
+	}
+}
+
+func TestStatfsOverhead(t *testing.T) {
+	tfs := newTestFS(t, config.GuardPoint{})
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(tfs.guardPoint.SecureStoragePath, &st); err != nil {
+		t.Fatal(err)
+	}
+	var out fuse.StatfsOut
+	if errno := tfs.root.Statfs(callerContext(), &out); errno != 0 {
+		t.Fatalf("Statfs: %v", errno)
+	}
+	if out.Blocks != st.Blocks || out.Files != st.Files {
+		t.Errorf("blocks = %d, files = %d; want the filesystem's %d, %d", out.Blocks, out.Files, st.Blocks, st.Files)
+	}
+	// Free space is one header short of the filesystem's, give or take
+	// what other tests write meanwhile
+	overheadBlocks := (uint64(crypto.Overhead) + uint64(out.Bsize) - 1) / uint64(out.Bsize)
+	const slack = 1024
+	if want := st.Bavail - overheadBlocks; out.Bavail > want || out.Bavail+slack < want {
+		t.Errorf("bavail = %d, want about %d", out.Bavail, want)
+	}
+
+	tfs.guardPoint.SecureStoragePath += "/missing"
+	if errno := tfs.root.Statfs(callerContext(), &out); errno != syscall.ENOENT {
+		t.Errorf("Statfs of missing storage = %v, want ENOENT", errno)
+	}
+}
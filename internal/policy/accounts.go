@@ -0,0 +1,149 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// accountCheckInterval is how often the account files are checked
+	// for changes
+	accountCheckInterval = time.Second
+	// accountMaxAge bounds how long lookups are kept, for NSS sources
+	// such as SSSD whose changes do not show in the files
+	accountMaxAge = 5 * time.Minute
+)
+
+// accountDB caches group memberships from the account database (NSS,
+// normally /etc/passwd and /etc/group). Everything cached is dropped when
+// one of the files changes, so that "usermod -aG" takes effect on the
+// next access without a reload.
+type accountDB struct {
+	files []string
+	now   func() time.Time
+	// resolveMemberships and resolveGroupID look accounts up through NSS;
+	// replaceable for tests
+	resolveMemberships func(uid int) map[int]bool
+	resolveGroupID     func(name string) (int, bool)
+
+	mu          sync.Mutex
+	checked     time.Time
+	loaded      time.Time
+	fingerprint string
+	generation  uint64
+	// memberships by UID; nil for a UID that does not resolve
+	memberships map[int]map[int]bool
+	groupIDs    map[string]*int
+}
+
+// newAccountDB returns an account database whose cached lookups are
+// dropped when one of files changes, checked on the clock now.
+func newAccountDB(now func() time.Time, files ...string) *accountDB {
+	return &accountDB{
+		files:              files,
+		now:                now,
+		resolveMemberships: lookupMemberships,
+		resolveGroupID:     lookupGroupID,
+		memberships:        make(map[int]map[int]bool),
+		groupIDs:           make(map[string]*int),
+	}
+}
+
+// current drops cached lookups if the files changed or they are too old,
+// and returns the generation of the lookups. db.mu must be held.
+func (db *accountDB) current(now time.Time) uint64 {
+	if now.Sub(db.checked) < accountCheckInterval && now.Sub(db.checked) >= 0 {
+		return db.generation
+	}
+	db.checked = now
+
+	fingerprint := ""
+	for _, name := range db.files {
+		info, err := os.Stat(name)
+		if err != nil {
+			fingerprint += name + ":missing;"
+			continue
+		}
+		fingerprint += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	if fingerprint == db.fingerprint && now.Sub(db.loaded) < accountMaxAge {
+		return db.generation
+	}
+	if db.fingerprint != "" && fingerprint != db.fingerprint {
+		log.Printf("[POLICY] Account database changed, dropping cached group memberships")
+	}
+	db.fingerprint = fingerprint
+	db.loaded = now
+	db.generation++
+	db.memberships = make(map[int]map[int]bool)
+	db.groupIDs = make(map[string]*int)
+	return db.generation
+}
+
+// Generation changes whenever cached lookups are dropped, so decisions
+// depending on them can be tied to it.
+func (db *accountDB) Generation() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.current(db.now())
+}
+
+// memberOf reports whether the account database lists the user uid in
+// group gid, as its primary group or as a supplementary member.
+func (db *accountDB) memberOf(uid, gid int) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.current(db.now())
+
+	groups, ok := db.memberships[uid]
+	if !ok {
+		groups = db.resolveMemberships(uid)
+		db.memberships[uid] = groups
+	}
+	return groups[gid]
+}
+
+// groupID resolves a group name, like lookupGroupID but cached.
+func (db *accountDB) groupID(name string) (int, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.current(db.now())
+
+	gid, ok := db.groupIDs[name]
+	if !ok {
+		if id, found := db.resolveGroupID(name); found {
+			gid = &id
+		}
+		db.groupIDs[name] = gid
+	}
+	if gid == nil {
+		return 0, false
+	}
+	return *gid, true
+}
+
+// lookupMemberships returns the groups of the user uid through NSS, or nil
+// if the user does not resolve.
+func lookupMemberships(uid int) map[int]bool {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		log.Printf("[POLICY] Failed to look up groups of %s: %v", u.Username, err)
+		gids = []string{u.Gid}
+	}
+	groups := make(map[int]bool, len(gids))
+	for _, g := range gids {
+		if gid, err := strconv.Atoi(g); err == nil {
+			groups[gid] = true
+		}
+	}
+	return groups
+}
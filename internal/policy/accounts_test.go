@@ -0,0 +1,309 @@
+package policy
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeAccounts is an account database over temporary passwd and group
+// files, with a settable clock and NSS lookups served from maps.
+type fakeAccounts struct {
+	*accountDB
+	passwd, group string
+	clock         time.Time
+	groups        map[int]map[int]bool
+	gids          map[string]int
+	lookups       int
+}
+
+func newFakeAccounts(t *testing.T) *fakeAccounts {
+	t.Helper()
+	dir := t.TempDir()
+	f := &fakeAccounts{
+		passwd: filepath.Join(dir, "passwd"),
+		group:  filepath.Join(dir, "group"),
+		clock:  time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC),
+		groups: make(map[int]map[int]bool),
+		gids:   make(map[string]int),
+	}
+	f.write(t, f.passwd, "alice:x:1000:1000::/home/alice:/bin/sh\n")
+	f.write(t, f.group, "dba:x:50:\n")
+	f.accountDB = newAccountDB(func() time.Time { return f.clock }, f.passwd, f.group)
+	f.resolveMemberships = func(uid int) map[int]bool {
+		f.lookups++
+		return f.groups[uid]
+	}
+	f.resolveGroupID = func(name string) (int, bool) {
+		f.lookups++
+		gid, ok := f.gids[name]
+		return gid, ok
+	}
+	return f
+}
+
+func (f *fakeAccounts) write(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccountDBInvalidation(t *testing.T) {
+	db := newFakeAccounts(t)
+	db.gids["dba"] = 50
+	db.groups[1000] = map[int]bool{1000: true}
+
+	generation := db.Generation()
+	if db.memberOf(1000, 50) {
+		t.Fatal("alice is in dba before being added")
+	}
+	if db.lookups != 1 {
+		t.Fatalf("%d lookups, want 1", db.lookups)
+	}
+
+	// usermod -aG dba alice
+	db.groups[1000] = map[int]bool{1000: true, 50: true}
+	db.write(t, db.group, "dba:x:50:alice\n")
+
+	// The files are not checked again within the check interval
+	db.clock = db.clock.Add(accountCheckInterval / 2)
+	if db.memberOf(1000, 50) || db.Generation() != generation {
+		t.Error("change seen before the check interval passed")
+	}
+	if db.lookups != 1 {
+		t.Errorf("%d lookups, want the cached one", db.lookups)
+	}
+
+	db.clock = db.clock.Add(accountCheckInterval)
+	if !db.memberOf(1000, 50) {
+		t.Error("change not seen after the check interval")
+	}
+	if next := db.Generation(); next == generation {
+		t.Error("generation did not change with the files")
+	} else {
+		generation = next
+	}
+
+	// Unchanged files keep the generation and the lookups
+	lookups := db.lookups
+	db.clock = db.clock.Add(accountCheckInterval)
+	if !db.memberOf(1000, 50) || db.Generation() != generation || db.lookups != lookups {
+		t.Error("unchanged files dropped the cache")
+	}
+
+	// Changes outside the files, as with SSSD, show after accountMaxAge
+	db.groups[1000] = map[int]bool{1000: true}
+	db.clock = db.clock.Add(accountMaxAge)
+	if db.memberOf(1000, 50) {
+		t.Error("change outside the files not seen after the maximum age")
+	}
+	if db.Generation() == generation {
+		t.Error("generation did not change after the maximum age")
+	}
+	generation = db.Generation()
+
+	// A clock stepping back checks the files again
+	db.write(t, db.passwd, "")
+	db.clock = db.clock.Add(-time.Hour)
+	if db.Generation() == generation {
+		t.Error("generation did not change after the clock stepped back")
+	}
+}
+
+func TestAccountDBGroupID(t *testing.T) {
+	db := newFakeAccounts(t)
+
+	// Unknown names are cached too, and retried once the files change
+	if _, ok := db.groupID("dba"); ok {
+		t.Fatal("dba resolved before it exists")
+	}
+	db.gids["dba"] = 50
+	if _, ok := db.groupID("dba"); ok || db.lookups != 1 {
+		t.Errorf("unresolved name looked up again: %d lookups", db.lookups)
+	}
+
+	db.write(t, db.group, "dba:x:50:\nops:x:51:\n")
+	db.clock = db.clock.Add(accountCheckInterval)
+	if gid, ok := db.groupID("dba"); !ok || gid != 50 {
+		t.Errorf("groupID = %d, %v; want 50", gid, ok)
+	}
+
+	// A missing file is a change like any other
+	os.Remove(db.group)
+	db.clock = db.clock.Add(accountCheckInterval)
+	before := db.lookups
+	db.groupID("dba")
+	if db.lookups != before+1 {
+		t.Error("removing the group file did not drop the cache")
+	}
+}
+
+func TestGroupMemberDecisions(t *testing.T) {
+	db := newFakeAccounts(t)
+	db.gids["dba"] = 50
+	db.groups[1000] = map[int]bool{1000: true}
+
+	cfg := &config.Config{
+		GuardPoints: []config.GuardPoint{{
+			ID:                "gp-1",
+			Code:              "gp",
+			ProtectedPath:     "/data/db",
+			SecureStoragePath: "/secure/db",
+			Policy:            "p",
+			Enabled:           true,
+		}},
+		UserSets: []config.UserSet{{
+			Code:  "dba",
+			Users: []config.User{{GName: "dba", MatchBy: config.MatchByGroupMember}},
+		}},
+		Policies: []config.Policy{{
+			Code: "p",
+			SecurityRules: []config.SecurityRule{
+				{ID: "dba", Order: 1, Action: []string{"read"}, UserSet: []string{"dba"}, Effect: config.RuleEffect{Permission: "permit"}},
+				{ID: "deny", Order: 2, Action: []string{"all_ops"}, Effect: config.RuleEffect{Permission: "deny"}},
+			},
+		}},
+	}
+	engine := NewEngine(cfg)
+	engine.accounts = db.accountDB
+	engine.Update(cfg)
+	engine.SetDecisionCache(NewDecisionCache(16))
+
+	decide := func() string {
+		t.Helper()
+		result, err := engine.EvaluateAccess(&AccessRequest{Path: "/data/db/table", Action: "read", UID: 1000, GID: 1000, Groups: []int{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RuleID
+	}
+
+	if got := decide(); got != "deny" {
+		t.Fatalf("before usermod: rule %s, want deny", got)
+	}
+	if got := decide(); got != "deny" {
+		t.Fatalf("cached: rule %s, want deny", got)
+	}
+
+	// The cached denial is not served once the account database changed
+	db.groups[1000] = map[int]bool{1000: true, 50: true}
+	db.write(t, db.group, "dba:x:50:alice\n")
+	db.clock = db.clock.Add(accountCheckInterval)
+	if got := decide(); got != "dba" {
+		t.Errorf("after usermod: rule %s, want dba", got)
+	}
+}
+
+func TestSupplementaryGroupMatching(t *testing.T) {
+	db := newFakeAccounts(t)
+	db.gids["dba"] = 50
+	// alice's primary group is 1000; the account database also lists her
+	// in dba, but her running processes predate that
+	db.groups[1000] = map[int]bool{1000: true, 50: true}
+	db.groups[1001] = map[int]bool{1001: true}
+
+	entries := map[string]config.User{
+		"gid":          {GID: 50, MatchBy: config.MatchByGID},
+		"gname":        {GName: "dba", MatchBy: config.MatchByGName},
+		"group_member": {GName: "dba", MatchBy: config.MatchByGroupMember},
+		"unknown":      {GName: "nosuchgroup", MatchBy: config.MatchByGName},
+	}
+	tests := []struct {
+		name   string
+		uid    int
+		gid    int
+		groups []int
+		want   map[string]bool
+	}{
+		{"supplementary group", 1001, 1001, []int{20, 50}, map[string]bool{"gid": true, "gname": true}},
+		{"primary group", 1001, 50, []int{}, map[string]bool{"gid": true, "gname": true}},
+		{"member by account database", 1000, 1000, []int{20}, map[string]bool{"group_member": true}},
+		{"both", 1000, 1000, []int{50}, map[string]bool{"gid": true, "gname": true, "group_member": true}},
+		{"neither", 1001, 1001, []int{20}, map[string]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &AccessRequest{UID: tt.uid, GID: tt.gid, Groups: tt.groups}
+			matched := map[string]bool{}
+			for name, u := range entries {
+				u := u
+				if matchesUser(req, &u, db.accountDB) {
+					matched[name] = true
+				}
+			}
+			if !reflect.DeepEqual(matched, tt.want) {
+				t.Errorf("matching entries = %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestGNameDecisions(t *testing.T) {
+	db := newFakeAccounts(t)
+	db.gids["dba"] = 50
+	cfg := &config.Config{
+		GuardPoints: []config.GuardPoint{{
+			ID:                "gp-1",
+			Code:              "gp",
+			ProtectedPath:     "/data/db",
+			SecureStoragePath: "/secure/db",
+			Policy:            "p",
+			Enabled:           true,
+		}},
+		UserSets: []config.UserSet{{
+			Code:  "dba",
+			Users: []config.User{{GName: "dba", MatchBy: config.MatchByGName}},
+		}},
+		Policies: []config.Policy{{
+			Code: "p",
+			SecurityRules: []config.SecurityRule{
+				{ID: "dba", Order: 1, Action: []string{"read"}, UserSet: []string{"dba"}, Effect: config.RuleEffect{Permission: "permit"}},
+				{ID: "deny", Order: 2, Action: []string{"all_ops"}, Effect: config.RuleEffect{Permission: "deny"}},
+			},
+		}},
+	}
+	engine := NewEngine(cfg)
+	engine.accounts = db.accountDB
+	engine.Update(cfg)
+	engine.SetDecisionCache(NewDecisionCache(16))
+
+	decide := func(groups ...int) string {
+		t.Helper()
+		result, err := engine.EvaluateAccess(&AccessRequest{Path: "/data/db/table", Action: "read", UID: 1001, GID: 1001, Groups: groups})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RuleID
+	}
+
+	if got := decide(20, 50); got != "dba" {
+		t.Errorf("with dba as a supplementary group: rule %s, want dba", got)
+	}
+	if got := decide(20, 60); got != "deny" {
+		t.Errorf("without dba: rule %s, want deny", got)
+	}
+
+	// Renumbering the group takes effect with the account database, even
+	// for decisions already cached
+	db.gids["dba"] = 60
+	db.write(t, db.group, "dba:x:60:\n")
+	db.clock = db.clock.Add(accountCheckInterval)
+	if got := decide(20, 60); got != "dba" {
+		t.Errorf("after renumbering dba: rule %s, want dba", got)
+	}
+	if got := decide(20, 50); got != "deny" {
+		t.Errorf("with the old dba gid: rule %s, want deny", got)
+	}
+}
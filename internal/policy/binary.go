@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// maxHashCacheEntries bounds the binary hash cache; it is simply emptied
+// when full, since the working set of executables is small.
+const maxHashCacheEntries = 4096
+
+// fileID identifies one version of an executable. A binary replaced in
+// place gets a new mtime (or inode), so stale hashes are never reused.
+type fileID struct {
+	dev   uint64
+	ino   uint64
+	mtime int64
+	size  int64
+}
+
+var hashCache = struct {
+	sync.Mutex
+	digests map[fileID][]byte
+}{digests: make(map[fileID][]byte)}
+
+// executablePath returns the path to open for the process's executable.
+// /proc/<pid>/exe refers to the inode actually executed even if the path
+// has since been replaced or deleted.
+func executablePath(req *AccessRequest) string {
+	if req.ProcessID > 0 {
+		return fmt.Sprintf("/proc/%d/exe", req.ProcessID)
+	}
+	return req.Binary
+}
+
+func statFileID(path string) (fileID, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return fileID{}, err
+	}
+	return fileID{
+		dev:   uint64(st.Dev),
+		ino:   uint64(st.Ino),
+		mtime: st.Mtim.Nano(),
+		size:  st.Size,
+	}, nil
+}
+
+// binaryIdentity renders the identity of the requesting executable for
+// decision cache keys.
+func binaryIdentity(req *AccessRequest) string {
+	id, err := statFileID(executablePath(req))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", id.dev, id.ino, id.mtime, id.size)
+}
+
+// binarySHA256 hashes the requesting executable, caching the digest by
+// device, inode, mtime and size.
+func binarySHA256(req *AccessRequest) ([]byte, error) {
+	path := executablePath(req)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open executable: %w", err)
+	}
+	defer f.Close()
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return nil, fmt.Errorf("failed to stat executable: %w", err)
+	}
+	id := fileID{dev: uint64(st.Dev), ino: uint64(st.Ino), mtime: st.Mtim.Nano(), size: st.Size}
+
+	hashCache.Lock()
+	digest, ok := hashCache.digests[id]
+	hashCache.Unlock()
+	if ok {
+		return digest, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash executable: %w", err)
+	}
+	digest = h.Sum(nil)
+	log.Printf("[POLICY] Hashed executable %s: sha256:%x", req.Binary, digest)
+
+	hashCache.Lock()
+	if len(hashCache.digests) >= maxHashCacheEntries {
+		hashCache.digests = make(map[fileID][]byte)
+	}
+	hashCache.digests[id] = digest
+	hashCache.Unlock()
+
+	return digest, nil
+}
+
+// imaDigest returns the file digest recorded in the security.ima xattr.
+// Only digest records are understood; IMA signatures (type 3) are not.
+func imaDigest(path string) ([]byte, error) {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, "security.ima", buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read security.ima: %w", err)
+	}
+	buf = buf[:n]
+
+	switch {
+	case n > 1 && buf[0] == 0x01: // IMA_XATTR_DIGEST: SHA-1 digest
+		return buf[1:], nil
+	case n > 2 && buf[0] == 0x04: // IMA_XATTR_DIGEST_NG: algorithm, digest
+		return buf[2:], nil
+	}
+	return nil, fmt.Errorf("unsupported security.ima record type %#x", buf[0])
+}
+
+// verifiesSignature reports whether the requesting executable satisfies
+// one signature entry. Ed25519 signatures are detached, stored base64
+// encoded in "<binary>.sig", and sign the executable's SHA-256 digest.
+func verifiesSignature(req *AccessRequest, entry string) bool {
+	kind, value, err := config.ParseSignature(entry)
+	if err != nil {
+		log.Printf("[POLICY] Ignoring invalid signature: %v", err)
+		return false
+	}
+
+	switch kind {
+	case config.SignatureSHA256:
+		digest, err := binarySHA256(req)
+		if err != nil {
+			log.Printf("[POLICY] Cannot verify %s: %v", req.Binary, err)
+			return false
+		}
+		return bytes.Equal(digest, value)
+
+	case config.SignatureIMA:
+		digest, err := imaDigest(executablePath(req))
+		if err != nil {
+			log.Printf("[POLICY] Cannot verify %s: %v", req.Binary, err)
+			return false
+		}
+		return bytes.Equal(digest, value)
+
+	case config.SignatureEd25519:
+		digest, err := binarySHA256(req)
+		if err != nil {
+			log.Printf("[POLICY] Cannot verify %s: %v", req.Binary, err)
+			return false
+		}
+		encoded, err := os.ReadFile(req.Binary + ".sig")
+		if err != nil {
+			log.Printf("[POLICY] Cannot verify %s: %v", req.Binary, err)
+			return false
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil {
+			log.Printf("[POLICY] Invalid detached signature for %s: %v", req.Binary, err)
+			return false
+		}
+		return ed25519.Verify(ed25519.PublicKey(value), digest, sig)
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeBinary writes an executable into a temporary directory and returns
+// its path and SHA-256 digest.
+func writeBinary(t *testing.T, content string) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(content))
+	return path, digest[:]
+}
+
+func TestVerifiesSHA256Signature(t *testing.T) {
+	path, digest := writeBinary(t, "binary")
+	other := sha256.Sum256([]byte("other"))
+	req := &AccessRequest{Binary: path}
+
+	if !verifiesSignature(req, "sha256:"+hex.EncodeToString(digest)) {
+		t.Error("the binary's own digest did not verify")
+	}
+	if verifiesSignature(req, "sha256:"+hex.EncodeToString(other[:])) {
+		t.Error("another digest verified")
+	}
+	if verifiesSignature(req, "sha256:nothex") {
+		t.Error("an invalid entry verified")
+	}
+	if verifiesSignature(&AccessRequest{Binary: path + ".missing"}, "sha256:"+hex.EncodeToString(digest)) {
+		t.Error("a missing binary verified")
+	}
+}
+
+func TestVerifiesEd25519Signature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := "ed25519:" + base64.StdEncoding.EncodeToString(public)
+	sign := func(digest []byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(private, digest)) + "\n"
+	}
+
+	tests := []struct {
+		name  string
+		setup func(path string, digest []byte) error
+		entry string
+		want  bool
+	}{
+		{"valid", func(path string, digest []byte) error {
+			return os.WriteFile(path+".sig", []byte(sign(digest)), 0644)
+		}, entry, true},
+		{"other key", func(path string, digest []byte) error {
+			return os.WriteFile(path+".sig", []byte(sign(digest)), 0644)
+		}, "ed25519:" + base64.StdEncoding.EncodeToString(otherPublic), false},
+		{"binary tampered", func(path string, digest []byte) error {
+			if err := os.WriteFile(path+".sig", []byte(sign(digest)), 0644); err != nil {
+				return err
+			}
+			return os.WriteFile(path, []byte("tampered"), 0755)
+		}, entry, false},
+		{"signature tampered", func(path string, digest []byte) error {
+			sig := ed25519.Sign(private, digest)
+			sig[0] ^= 1
+			return os.WriteFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(sig)), 0644)
+		}, entry, false},
+		{"signature not base64", func(path string, digest []byte) error {
+			return os.WriteFile(path+".sig", []byte("not base64!"), 0644)
+		}, entry, false},
+		{"signature missing", func(path string, digest []byte) error { return nil }, entry, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, digest := writeBinary(t, "binary")
+			if err := tt.setup(path, digest); err != nil {
+				t.Fatal(err)
+			}
+			if got := verifiesSignature(&AccessRequest{Binary: path}, tt.entry); got != tt.want {
+				t.Errorf("verifiesSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifiesIMASignature(t *testing.T) {
+	path, digest := writeBinary(t, "binary")
+	// IMA_XATTR_DIGEST_NG with hash algorithm 4 (SHA-256)
+	record := append([]byte{0x04, 0x04}, digest...)
+	if err := syscall.Setxattr(path, "security.ima", record, 0); err != nil {
+		t.Skipf("cannot set security.ima: %v", err)
+	}
+	req := &AccessRequest{Binary: path}
+	if !verifiesSignature(req, "ima:"+hex.EncodeToString(digest)) {
+		t.Error("the recorded digest did not verify")
+	}
+	other := sha256.Sum256([]byte("other"))
+	if verifiesSignature(req, "ima:"+hex.EncodeToString(other[:])) {
+		t.Error("another digest verified")
+	}
+
+	// IMA_XATTR_DIGEST: a bare SHA-1 digest
+	sha1 := digest[:20]
+	if err := syscall.Setxattr(path, "security.ima", append([]byte{0x01}, sha1...), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !verifiesSignature(req, "ima:"+hex.EncodeToString(sha1)) {
+		t.Error("the recorded SHA-1 digest did not verify")
+	}
+
+	// IMA signatures are not understood
+	if err := syscall.Setxattr(path, "security.ima", append([]byte{0x03}, digest...), 0); err != nil {
+		t.Fatal(err)
+	}
+	if verifiesSignature(req, "ima:"+hex.EncodeToString(digest)) {
+		t.Error("an IMA signature record verified")
+	}
+
+	unlabelled, digest := writeBinary(t, "binary")
+	if verifiesSignature(&AccessRequest{Binary: unlabelled}, "ima:"+hex.EncodeToString(digest)) {
+		t.Error("a binary without security.ima verified")
+	}
+}
+
+func TestBinaryHashCache(t *testing.T) {
+	path, digest := writeBinary(t, "binary")
+	req := &AccessRequest{Binary: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := binarySHA256(req); err != nil || !bytes.Equal(got, digest) {
+		t.Fatalf("binarySHA256 = %x, %v; want %x", got, err, digest)
+	}
+
+	// Rewritten in place with the same size and mtime, the binary keeps
+	// its cached digest: the cache key is all that is checked
+	if err := os.WriteFile(path, []byte("BINARY"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := binarySHA256(req); !bytes.Equal(got, digest) {
+		t.Fatalf("binarySHA256 = %x, want the cached %x", got, digest)
+	}
+
+	// A new mtime invalidates it
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256([]byte("BINARY"))
+	if got, _ := binarySHA256(req); !bytes.Equal(got, want[:]) {
+		t.Errorf("after an mtime change binarySHA256 = %x, want %x", got, want)
+	}
+
+	// So does a new size, even with the mtime restored
+	if err := os.WriteFile(path, []byte("binary, longer"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	want = sha256.Sum256([]byte("binary, longer"))
+	if got, _ := binarySHA256(req); !bytes.Equal(got, want[:]) {
+		t.Errorf("after a size change binarySHA256 = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// decisionKey identifies a cacheable decision. The generation ties the
+// entry to the snapshot it was computed from, so decisions made before a
+// reload can never be served after it.
+type decisionKey struct {
+	generation uint64
+	guardPoint string
+	path       string
+	action     string
+	uid        int
+	gid        int
+	groups     string
+	accounts   uint64
+	binary     string
+	binaryID   string
+	process    string
+	container  string
+	file       string
+}
+
+type decisionEntry struct {
+	key    decisionKey
+	result AccessResult
+	// A decision under a scheduled rule holds from when it was made until
+	// expires; expires is zero if the decision does not depend on time
+	since   time.Time
+	expires time.Time
+}
+
+// CacheStats is a snapshot of decision cache metrics.
+type CacheStats struct {
+	Capacity  int    `json:"capacity"`
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// DecisionCache is a bounded LRU of policy decisions.
+type DecisionCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[decisionKey]*list.Element
+	order    *list.List // front is most recently used
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func NewDecisionCache(capacity int) *DecisionCache {
+	return &DecisionCache{
+		capacity: capacity,
+		entries:  make(map[decisionKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *DecisionCache) get(key decisionKey, now time.Time) (*AccessResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := elem.Value.(*decisionEntry)
+	if !entry.expires.IsZero() && (now.Before(entry.since) || !now.Before(entry.expires)) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	c.hits.Add(1)
+
+	result := entry.result
+	return &result, true
+}
+
+func (c *DecisionCache) put(key decisionKey, result *AccessResult, since, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*decisionEntry)
+		entry.result = *result
+		entry.since = since
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&decisionEntry{key: key, result: *result, since: since, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decisionEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// Purge drops every entry.
+func (c *DecisionCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[decisionKey]*list.Element)
+	c.order.Init()
+}
+
+func (c *DecisionCache) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Capacity:  c.capacity,
+		Size:      size,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
@@ -0,0 +1,199 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func scheduledConfig(schedules ...*config.RuleSchedule) *config.Config {
+	cfg := &config.Config{
+		GuardPoints: []config.GuardPoint{{
+			ID:                "gp-1",
+			Code:              "gp",
+			ProtectedPath:     "/data/reports",
+			SecureStoragePath: "/secure/reports",
+			Enabled:           true,
+		}},
+	}
+	for i, schedule := range schedules {
+		code := string(rune('a' + i))
+		cfg.GuardPoints[0].Policies = append(cfg.GuardPoints[0].Policies, code)
+		cfg.Policies = append(cfg.Policies, config.Policy{
+			Code: code,
+			SecurityRules: []config.SecurityRule{
+				{ID: code + "-hours", Order: 1, Action: []string{"read"}, Schedule: schedule, Effect: config.RuleEffect{Permission: "permit"}},
+				{ID: code + "-deny", Order: 2, Action: []string{"all_ops"}, Effect: config.RuleEffect{Permission: "deny"}},
+			},
+		})
+	}
+	return cfg
+}
+
+func TestScheduleChange(t *testing.T) {
+	cfg := scheduledConfig(
+		&config.RuleSchedule{NotAfter: "2026-01-05T12:00:00Z"},
+		&config.RuleSchedule{NotBefore: "2026-01-05T10:00:00Z"},
+	)
+	cgp := compile(cfg).findGuardPoint("/data/reports/q1.csv")
+
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC), time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC), time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := cgp.scheduleChange(tt.now); !got.Equal(tt.want) {
+			t.Errorf("scheduleChange(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestDecisionCacheScheduleExpiry(t *testing.T) {
+	cfg := scheduledConfig(&config.RuleSchedule{
+		Timezone: "UTC",
+		Windows:  []config.TimeWindow{{Start: "09:00", End: "17:00"}},
+	})
+	now := time.Date(2026, 1, 5, 8, 59, 30, 0, time.UTC)
+	engine := NewEngine(cfg)
+	engine.SetClock(func() time.Time { return now })
+	cache := NewDecisionCache(16)
+	engine.SetDecisionCache(cache)
+
+	decide := func() string {
+		t.Helper()
+		result, err := engine.EvaluateAccess(&AccessRequest{Path: "/data/reports/q1.csv", Action: "read", UID: 1000, GID: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RuleID
+	}
+
+	steps := []struct {
+		at   time.Time
+		want string
+		hit  bool
+	}{
+		{time.Date(2026, 1, 5, 8, 59, 30, 0, time.UTC), "a-deny", false},
+		{time.Date(2026, 1, 5, 8, 59, 59, 999, time.UTC), "a-deny", true},
+		// The decision expires when the schedule can next change
+		{time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC), "a-hours", false},
+		{time.Date(2026, 1, 5, 9, 0, 30, 0, time.UTC), "a-hours", true},
+		{time.Date(2026, 1, 5, 16, 59, 59, 0, time.UTC), "a-hours", false},
+		{time.Date(2026, 1, 5, 17, 0, 0, 0, time.UTC), "a-deny", false},
+		// A clock stepping back does not get a decision made later
+		{time.Date(2026, 1, 5, 16, 59, 0, 0, time.UTC), "a-hours", false},
+	}
+	for _, step := range steps {
+		now = step.at
+		hits := cache.Stats().Hits
+		if got := decide(); got != step.want {
+			t.Errorf("at %s: rule %s, want %s", step.at.Format("15:04:05"), got, step.want)
+		}
+		if hit := cache.Stats().Hits > hits; hit != step.hit {
+			t.Errorf("at %s: cache hit %v, want %v", step.at.Format("15:04:05"), hit, step.hit)
+		}
+	}
+}
+
+func TestDecisionCacheLRU(t *testing.T) {
+	cache := NewDecisionCache(2)
+	key := func(path string) decisionKey { return decisionKey{path: path, action: "read"} }
+	put := func(path string) {
+		cache.put(key(path), &AccessResult{Permission: "permit", RuleID: path}, time.Time{}, time.Time{})
+	}
+	get := func(path string) string {
+		result, ok := cache.get(key(path), time.Now())
+		if !ok {
+			return ""
+		}
+		return result.RuleID
+	}
+
+	put("a")
+	put("b")
+	// Using a makes b the least recently used
+	if got := get("a"); got != "a" {
+		t.Fatalf("get(a) = %q, want a", got)
+	}
+	put("c")
+	if got := get("b"); got != "" {
+		t.Errorf("get(b) = %q after eviction, want a miss", got)
+	}
+	if get("a") != "a" || get("c") != "c" {
+		t.Errorf("a and c were not kept")
+	}
+	// Replacing an entry evicts nothing
+	cache.put(key("a"), &AccessResult{Permission: "deny", RuleID: "a2"}, time.Time{}, time.Time{})
+	if got := get("a"); got != "a2" {
+		t.Errorf("get(a) = %q after replacing it, want a2", got)
+	}
+
+	// Entries are handed out by value
+	result, _ := cache.get(key("c"), time.Now())
+	result.RuleID = "changed"
+	if got := get("c"); got != "c" {
+		t.Errorf("get(c) = %q after changing a returned result, want c", got)
+	}
+
+	want := CacheStats{Capacity: 2, Size: 2, Hits: 6, Misses: 1, Evictions: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	cache.Purge()
+	if got := get("a"); got != "" {
+		t.Errorf("get(a) = %q after Purge, want a miss", got)
+	}
+	// Purging keeps the counters
+	want = CacheStats{Capacity: 2, Size: 0, Hits: 6, Misses: 2, Evictions: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("Stats() after Purge = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecisionCacheReload(t *testing.T) {
+	cfg := scheduledConfig(nil)
+	engine := NewEngine(cfg)
+	cache := NewDecisionCache(16)
+	engine.SetDecisionCache(cache)
+	req := func() *AccessRequest {
+		return &AccessRequest{Path: "/data/reports/q1.csv", Action: "read", UID: 1000, GID: 1000}
+	}
+	decide := func() string {
+		t.Helper()
+		result, err := engine.EvaluateAccess(req())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RuleID
+	}
+
+	decide()
+	if got := decide(); got != "a-hours" || cache.Stats().Hits != 1 {
+		t.Fatalf("second decision: rule %s, %d hits; want a-hours from the cache", got, cache.Stats().Hits)
+	}
+	var old decisionKey
+	for key := range cache.entries {
+		old = key
+	}
+
+	cfg.Policies[0].SecurityRules[0].Action = []string{"write"}
+	engine.Update(cfg)
+	if size := cache.Stats().Size; size != 0 {
+		t.Errorf("cache holds %d entries after a reload, want 0", size)
+	}
+	// A decision from the previous snapshot stored after the purge, as by
+	// an evaluation racing the reload, is never served
+	cache.put(old, &AccessResult{Permission: "permit", RuleID: "a-hours"}, time.Time{}, time.Time{})
+	if got := decide(); got != "a-deny" {
+		t.Errorf("after the reload: rule %s, want a-deny", got)
+	}
+	if hits := cache.Stats().Hits; hits != 1 {
+		t.Errorf("%d cache hits after the reload, want 1", hits)
+	}
+}
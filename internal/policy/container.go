@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"path"
+	"strings"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/container"
+)
+
+// matchesContainer checks where the process runs against a container
+// condition. An unresolved identity never matches.
+func matchesContainer(id *container.Identity, cm *config.ContainerMatch) bool {
+	if id == nil {
+		return false
+	}
+	if cm.ID == "" && cm.Image == "" && cm.Cgroup == "" {
+		return id.InContainer()
+	}
+	if cm.ID != "" && (id.ID == "" || !strings.HasPrefix(id.ID, cm.ID)) {
+		return false
+	}
+	if cm.Image != "" {
+		if matched, _ := path.Match(cm.Image, id.Image); id.Image == "" || !matched {
+			return false
+		}
+	}
+	if cm.Cgroup != "" {
+		matched, _ := path.Match(cm.Cgroup, id.Cgroup)
+		if !matched && !strings.HasPrefix(id.Cgroup, strings.TrimSuffix(cm.Cgroup, "/")+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// namespacedRequest returns req with the caller's IDs as seen inside its
+// user namespace, for entries scoped to a container.
+func namespacedRequest(req *AccessRequest) *AccessRequest {
+	inner := *req
+	inner.UID = req.Container.UID
+	inner.GID = req.Container.GID
+	inner.Groups = req.Container.Groups
+	return &inner
+}
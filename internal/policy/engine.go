@@ -5,20 +5,25 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/container"
 )
 
+// Engine evaluates access requests against an immutable compiled snapshot
+// of the configuration. Evaluation is lock-free; Update swaps in a new
+// snapshot atomically, so reloads never block or tear in-flight decisions.
 type Engine struct {
-	config *config.Config
-	
-	userSetMap     map[string]*config.UserSet
-	processSetMap  map[string]*config.ProcessSet
-	resourceSetMap map[string]*config.ResourceSet
-	policyMap      map[string]*config.Policy
+	current    atomic.Pointer[snapshot]
+	generation atomic.Uint64
+	cache      *DecisionCache
+	clock      func() time.Time
+	containers *container.Resolver
+	accounts   *accountDB
 }
 
 type AccessRequest struct {
@@ -28,6 +33,57 @@ type AccessRequest struct {
 	GID       int
 	ProcessID int
 	Binary    string
+
+	// Groups are the supplementary group IDs of the process. When nil and
+	// the configuration matches users by group, they are read from /proc.
+	Groups []int
+
+	// Time is when the access happens, for rule schedules. The engine
+	// clock is used when it is zero.
+	Time time.Time
+
+	// Container is where the process runs. When nil and the configuration
+	// has container conditions, it is resolved from the process.
+	Container *container.Identity
+
+	// File is the metadata of the file at Path. When nil and resources
+	// have conditions on it, it is read from the guard point's secure
+	// storage.
+	File *FileAttributes
+
+	// Lookups that failed while completing the request
+	groupsUnresolved    bool
+	containerUnresolved bool
+
+	// procs caches the process tree for the evaluation
+	procs *ancestryCache
+}
+
+// Reasons for a decision, in AccessResult.Reason.
+const (
+	ReasonNoGuardPoint       = "no_guard_point"
+	ReasonGuardPointDisabled = "guard_point_disabled"
+	ReasonRulePermitted      = "rule_permitted"
+	ReasonRuleDenied         = "rule_denied"
+	ReasonNoRuleMatched      = "no_rule_matched"
+	// No rule matched, and a user or process set condition failed
+	// because the caller's identity could not be resolved
+	ReasonIdentityUnresolved = "identity_unresolved"
+	// Set by the interceptor when the guard point key cannot be obtained
+	ReasonKeyUnavailable = "key_unavailable"
+)
+
+// identityUnresolved reports whether a failed condition may be due to an
+// identity lookup that failed rather than to the caller.
+func (req *AccessRequest) identityUnresolved(condition string) bool {
+	switch condition {
+	case ConditionUserSet:
+		return req.groupsUnresolved || req.containerUnresolved
+	case ConditionProcessSet:
+		unknownBinary := req.ProcessID > 0 && (req.Binary == "" || req.Binary == "unknown")
+		return unknownBinary || req.containerUnresolved
+	}
+	return false
 }
 
 type AccessResult struct {
@@ -35,51 +91,227 @@ type AccessResult struct {
 	ApplyKey   bool
 	Audit      bool
 	RuleID     string
+	// Policy is the code of the policy RuleID belongs to; empty for the
+	// default deny unless learn mode attributes it to a policy
+	Policy string
+
+	// GuardPoint is the guard point the path falls under, nil if none. It
+	// comes from the same snapshot as the decision.
+	GuardPoint *config.GuardPoint
+
+	// RawAccess grants reads and writes of the stored ciphertext, without
+	// decryption or encryption, because the process is exempted for the
+	// resource; Exemption names the exempting resource set.
+	RawAccess bool
+	Exemption string
+
+	// Reason says why the decision was made; see the Reason constants
+	Reason string
+
+	// View is what a permitted read returns, one of the config View
+	// constants; empty outside enabled guard points and for denials
+	View string
+
+	// Learned marks a decision a rule in learn mode would have denied:
+	// a denial turned into a permit, or under deny-overrides a permit
+	// that a policy in learn mode would have overridden. LearnedRule and
+	// LearnedPolicy name the rule that would have denied.
+	Learned       bool
+	LearnedRule   string
+	LearnedPolicy string
 }
 
 func NewEngine(cfg *config.Config) *Engine {
 	engine := &Engine{
-		config:         cfg,
-		userSetMap:     make(map[string]*config.UserSet),
-		processSetMap:  make(map[string]*config.ProcessSet),
-		resourceSetMap: make(map[string]*config.ResourceSet),
-		policyMap:      make(map[string]*config.Policy),
+		clock:      time.Now,
+		containers: container.NewResolver(""),
+		accounts:   newAccountDB(time.Now, "/etc/passwd", "/etc/group"),
 	}
+	engine.Update(cfg)
+	return engine
+}
 
-	for i := range cfg.UserSets {
-		engine.userSetMap[cfg.UserSets[i].Code] = &cfg.UserSets[i]
+// Update compiles cfg and makes it the configuration for all subsequent
+// evaluations.
+func (e *Engine) Update(cfg *config.Config) {
+	snap := compile(cfg)
+	snap.generation = e.generation.Add(1)
+	snap.accounts = e.accounts
+	e.current.Store(snap)
+	if e.cache != nil {
+		e.cache.Purge()
 	}
+}
+
+// SetClock replaces the clock used for rule schedules. It must be called
+// before the engine is shared between goroutines.
+func (e *Engine) SetClock(clock func() time.Time) {
+	e.clock = clock
+}
+
+// SetContainerResolver replaces the resolver of container identities. It
+// must be called before the engine is shared between goroutines.
+func (e *Engine) SetContainerResolver(resolver *container.Resolver) {
+	e.containers = resolver
+}
 
-	for i := range cfg.ProcessSets {
-		engine.processSetMap[cfg.ProcessSets[i].Code] = &cfg.ProcessSets[i]
+// SetDecisionCache enables caching of decisions. It must be called before
+// the engine is shared between goroutines.
+func (e *Engine) SetDecisionCache(cache *DecisionCache) {
+	e.cache = cache
+}
+
+// CacheStats reports decision cache metrics; ok is false when caching is
+// disabled.
+func (e *Engine) CacheStats() (stats CacheStats, ok bool) {
+	if e.cache == nil {
+		return CacheStats{}, false
+	}
+	return e.cache.Stats(), true
+}
+
+// Config returns a copy of the configuration of the current snapshot. The
+// copy is made once per snapshot and shared by every caller until the
+// next update, so it must not be modified.
+func (e *Engine) Config() *config.Config {
+	snap := e.current.Load()
+	snap.handedOnce.Do(func() {
+		snap.handed = snap.config.Copy()
+	})
+	return snap.handed
+}
+
+// FindGuardPoint returns a copy of the guard point protecting path, if any.
+func (e *Engine) FindGuardPoint(path string) *config.GuardPoint {
+	if cgp := e.current.Load().findGuardPoint(path); cgp != nil {
+		return cgp.guardPoint.Copy()
+	}
+	return nil
+}
+
+// HasCiphertextViews reports whether any decision can give the ciphertext
+// view, through a rule or rwp_exempted_resources.
+func (e *Engine) HasCiphertextViews() bool {
+	return e.current.Load().ciphertextViews
+}
+
+// complete fills in the parts of req the snapshot needs but the caller
+// left out: the time, supplementary groups, container identity and the
+// attributes of the file under guard point cgp.
+func (e *Engine) complete(snap *snapshot, cgp *compiledGuardPoint, req *AccessRequest) {
+	if req.Time.IsZero() {
+		req.Time = e.clock()
 	}
 
-	for i := range cfg.ResourceSets {
-		engine.resourceSetMap[cfg.ResourceSets[i].Code] = &cfg.ResourceSets[i]
+	if snap.matchesGroups && req.Groups == nil && req.ProcessID > 0 {
+		groups, err := GetProcessGroups(req.ProcessID)
+		if err != nil {
+			log.Printf("[POLICY] Failed to read groups of pid %d: %v", req.ProcessID, err)
+			req.groupsUnresolved = true
+		}
+		req.Groups = groups
 	}
 
-	for i := range cfg.Policies {
-		engine.policyMap[cfg.Policies[i].Code] = &cfg.Policies[i]
+	if snap.matchesContainers && req.Container == nil && req.ProcessID > 0 {
+		id, err := e.containers.Resolve(req.ProcessID, req.UID, req.GID, req.Groups)
+		if err != nil {
+			log.Printf("[POLICY] Failed to resolve container of pid %d: %v", req.ProcessID, err)
+			req.containerUnresolved = true
+		}
+		req.Container = id
 	}
 
-	return engine
+	if snap.checksAncestry && req.ProcessID > 0 {
+		req.procs = newAncestryCache()
+	}
+
+	if snap.inspectsFiles && req.File == nil && cgp != nil {
+		req.File = resolveFile(req, cgp, snap.xattrNames)
+	}
 }
 
 func (e *Engine) EvaluateAccess(req *AccessRequest) (*AccessResult, error) {
+	result, err := e.decide(req)
+	// The guard point of a result is the snapshot's own, which is shared
+	// with the cache; callers get their own copy
+	if result != nil && result.GuardPoint != nil {
+		result.GuardPoint = result.GuardPoint.Copy()
+	}
+	return result, err
+}
+
+// decide evaluates req, through the cache if there is one.
+func (e *Engine) decide(req *AccessRequest) (*AccessResult, error) {
+	snap := e.current.Load()
+	cgp := snap.findGuardPoint(req.Path)
+
+	e.complete(snap, cgp, req)
+
+	// Only decisions inside a guard point are worth caching; everything
+	// else is an unconditional permit
+	if e.cache == nil || cgp == nil {
+		return snap.evaluate(req, cgp, nil)
+	}
+
+	key := decisionKey{
+		generation: snap.generation,
+		guardPoint: cgp.path,
+		path:       absPath(req.Path),
+		action:     req.Action,
+		uid:        req.UID,
+		gid:        req.GID,
+		binary:     req.Binary,
+	}
+	if snap.matchesGroups {
+		key.groups = groupsKey(req.Groups)
+	}
+	if snap.matchesMembers {
+		key.accounts = snap.accounts.Generation()
+	}
+	if snap.verifiesBinaries {
+		key.binaryID = binaryIdentity(req)
+	}
+	if snap.checksAncestry {
+		key.process = req.procs.ancestryKey(req.ProcessID)
+	}
+	if req.Container != nil {
+		key.container = req.Container.Key()
+	}
+	if req.File != nil {
+		key.file = req.File.key()
+	}
+	if result, ok := e.cache.get(key, req.Time); ok {
+		return result, nil
+	}
+
+	result, err := snap.evaluate(req, cgp, nil)
+	// A failed lookup may succeed next time, so its outcome is not kept
+	if err == nil && result.Reason != ReasonIdentityUnresolved {
+		// A decision under a scheduled rule only holds until the schedule
+		// can next change
+		e.cache.put(key, result, req.Time, cgp.scheduleChange(req.Time))
+	}
+	return result, err
+}
+
+// evaluate decides req under guard point cgp. If trace is not nil, every
+// rule considered is recorded in it.
+func (snap *snapshot) evaluate(req *AccessRequest, cgp *compiledGuardPoint, trace *Explanation) (*AccessResult, error) {
 	log.Printf("[POLICY] ========== POLICY EVALUATION START ==========")
 	log.Printf("[POLICY] EvaluateAccess: path=%s, action=%s, uid=%d, gid=%d, pid=%d, binary=%s", req.Path, req.Action, req.UID, req.GID, req.ProcessID, req.Binary)
 
-	guardPoint := e.findGuardPoint(req.Path)
-	if guardPoint == nil {
+	if cgp == nil {
 		log.Printf("[POLICY] No guard point found for path: %s", req.Path)
 		return &AccessResult{
 			Permission: "permit",
 			ApplyKey:   false,
 			Audit:      false,
+			Reason:     ReasonNoGuardPoint,
 		}, nil
 	}
+	guardPoint := &cgp.guardPoint
 
-	log.Printf("[POLICY] Found guard point: %s -> %s (policy: %s, enabled: %v)", guardPoint.ProtectedPath, guardPoint.SecureStoragePath, guardPoint.Policy, guardPoint.Enabled)
+	log.Printf("[POLICY] Found guard point: %s -> %s (policies: %v, enabled: %v)", guardPoint.ProtectedPath, guardPoint.SecureStoragePath, guardPoint.PolicyCodes(), guardPoint.Enabled)
 
 	if !guardPoint.Enabled {
 		log.Printf("[POLICY] Guard point disabled, permitting access")
@@ -87,139 +319,307 @@ func (e *Engine) EvaluateAccess(req *AccessRequest) (*AccessResult, error) {
 			Permission: "permit",
 			ApplyKey:   false,
 			Audit:      false,
+			GuardPoint: guardPoint,
+			Reason:     ReasonGuardPointDisabled,
 		}, nil
 	}
 
-	policy := e.policyMap[guardPoint.Policy]
-	if policy == nil {
-		log.Printf("[POLICY] Policy %s not found for guard point %s", guardPoint.Policy, guardPoint.Code)
-		return nil, fmt.Errorf("policy %s not found for guard point %s", guardPoint.Policy, guardPoint.Code)
+	if cgp.missing != "" || len(cgp.policies) == 0 {
+		log.Printf("[POLICY] Policy %s not found for guard point %s", cgp.missing, guardPoint.Code)
+		return nil, fmt.Errorf("policy %s not found for guard point %s", cgp.missing, guardPoint.Code)
 	}
 
-	log.Printf("[POLICY] Found policy: %s with %d rules", policy.Name, len(policy.SecurityRules))
+	relPath, err := filepath.Rel(cgp.path, absPath(req.Path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s within guard point %s: %w", req.Path, guardPoint.Code, err)
+	}
+	if trace != nil {
+		trace.RelativePath = relPath
+	}
 
-	rules := make([]config.SecurityRule, len(policy.SecurityRules))
-	copy(rules, policy.SecurityRules)
-	sort.Slice(rules, func(i, j int) bool {
-		return rules[i].Order < rules[j].Order
-	})
+	// Whether some rule may have failed only because of a failed lookup
+	unresolved := false
+	// The decision so far under deny-overrides and permit-overrides, and
+	// under deny-overrides a deny in learn mode, which is never enforced:
+	// it only marks the decision for audit, or is learned if nothing
+	// permits
+	var decided, learnDeny *AccessResult
+
+	for _, cp := range cgp.policies {
+		log.Printf("[POLICY] Found policy: %s with %d rules (combining: %s)", cp.policy.Name, len(cp.rules), cgp.algorithm)
+		cr := snap.firstMatch(req, relPath, cp, trace, &unresolved)
+		if cr == nil {
+			continue
+		}
+		result := snap.ruleResult(req, relPath, cgp, cp, cr)
 
-	for _, rule := range rules {
-		log.Printf("[POLICY] Evaluating rule %d: %s (userSet: %v, processSet: %v, resourceSet: %v, action: %v)", rule.Order, rule.ID, rule.UserSet, rule.ProcessSet, rule.ResourceSet, rule.Action)
-		if e.matchesRule(req, &rule) {
-			log.Printf("[POLICY] Rule matched! RuleID: %s, Permission: %s, ApplyKey: %v, Audit: %v", rule.ID, rule.Effect.Permission, rule.Effect.Option.ApplyKey, rule.Effect.Option.Audit)
-			log.Printf("[POLICY] ========== POLICY EVALUATION END (RULE MATCH: %s) ==========", rule.ID)
-			return &AccessResult{
-				Permission: rule.Effect.Permission,
-				ApplyKey:   rule.Effect.Option.ApplyKey,
-				Audit:      rule.Effect.Option.Audit,
-				RuleID:     rule.ID,
-			}, nil
+		switch cgp.algorithm {
+		case config.CombineDenyOverrides:
+			if result.Permission == "deny" {
+				if !cp.learning() && !cgp.learning() {
+					return result, nil
+				}
+				if learnDeny == nil {
+					learnDeny = result
+				}
+			} else if decided == nil {
+				decided = result
+			}
+		case config.CombinePermitOverrides:
+			if result.Permission == "permit" {
+				return result, nil
+			}
+			// An enforced deny takes precedence over one in learn mode
+			if decided == nil || (!cp.learning() && cgp.policyLearning(decided.Policy)) {
+				decided = result
+			}
+		default:
+			return cgp.learn(result), nil
 		}
-		log.Printf("[POLICY] Rule %s did not match", rule.ID)
+	}
+	if learnDeny != nil {
+		if decided == nil {
+			decided = learnDeny
+		} else {
+			// Leave the permit as it is, view included
+			log.Printf("[POLICY] Learn mode: rule %s would deny what rule %s permits", learnDeny.RuleID, decided.RuleID)
+			decided.Audit = true
+			decided.Learned = true
+			decided.LearnedRule = learnDeny.RuleID
+			decided.LearnedPolicy = learnDeny.Policy
+		}
+	}
+	if decided != nil {
+		log.Printf("[POLICY] ========== POLICY EVALUATION END (COMBINED: %s) ==========", decided.RuleID)
+		return cgp.learn(decided), nil
 	}
 
 	log.Printf("[POLICY] No rules matched, using default deny")
 	log.Printf("[POLICY] ========== POLICY EVALUATION END (DEFAULT DENY) ==========")
-	return &AccessResult{
+	reason := ReasonNoRuleMatched
+	if unresolved {
+		reason = ReasonIdentityUnresolved
+	}
+	return cgp.learn(&AccessResult{
 		Permission: "deny",
 		ApplyKey:   false,
 		Audit:      true,
 		RuleID:     "default-deny",
-	}, nil
+		GuardPoint: guardPoint,
+		Reason:     reason,
+	}), nil
 }
 
-func (e *Engine) findGuardPoint(path string) *config.GuardPoint {
-	var bestMatch *config.GuardPoint
-	maxDepth := -1
+// firstMatch returns the first rule of policy cp that matches req, or nil.
+// unresolved is set if a rule may have failed only because of a failed
+// lookup.
+func (snap *snapshot) firstMatch(req *AccessRequest, relPath string, cp *compiledPolicy, trace *Explanation, unresolved *bool) *compiledRule {
+	for _, cr := range cp.rules {
+		rule := &cr.rule
+		log.Printf("[POLICY] Evaluating rule %d: %s (userSet: %v, processSet: %v, resourceSet: %v, action: %v)", rule.Order, rule.ID, rule.UserSet, rule.ProcessSet, rule.ResourceSet, rule.Action)
+		condition, detail := snap.ruleMismatch(req, relPath, cr)
+		if req.identityUnresolved(condition) {
+			*unresolved = true
+		}
+		if trace != nil {
+			trace.Rules = append(trace.Rules, RuleTrace{
+				Policy:          cp.policy.Code,
+				ID:              rule.ID,
+				Order:           rule.Order,
+				Matched:         condition == "",
+				FailedCondition: condition,
+				Detail:          detail,
+			})
+		}
+		if condition == "" {
+			log.Printf("[POLICY] Rule matched! RuleID: %s, Permission: %s, ApplyKey: %v, Audit: %v", rule.ID, rule.Effect.Permission, rule.Effect.Option.ApplyKey, rule.Effect.Option.Audit)
+			return cr
+		}
+		log.Printf("[POLICY] Rule %s did not match", rule.ID)
+	}
+	return nil
+}
 
-	for i := range e.config.GuardPoints {
-		gp := &e.config.GuardPoints[i]
-		if e.pathMatches(path, gp.ProtectedPath) {
-			depth := strings.Count(gp.ProtectedPath, "/")
-			if depth > maxDepth {
-				maxDepth = depth
-				bestMatch = gp
-			}
+// ruleResult is the decision of rule cr of policy cp.
+func (snap *snapshot) ruleResult(req *AccessRequest, relPath string, cgp *compiledGuardPoint, cp *compiledPolicy, cr *compiledRule) *AccessResult {
+	rule := &cr.rule
+	result := &AccessResult{
+		Permission: rule.Effect.Permission,
+		ApplyKey:   rule.Effect.Option.ApplyKey,
+		Audit:      rule.Effect.Option.Audit,
+		RuleID:     rule.ID,
+		Policy:     cp.policy.Code,
+		GuardPoint: &cgp.guardPoint,
+		Reason:     ReasonRulePermitted,
+	}
+	if result.Permission == "permit" {
+		// The key is applied exactly when the view needs the
+		// plaintext
+		result.View = rule.Effect.Option.ReadView()
+		result.ApplyKey = result.View == config.ViewPlaintext || result.View == config.ViewMasked
+	} else {
+		result.Reason = ReasonRuleDenied
+	}
+	if result.Permission == "permit" && cr.hasProcessSet {
+		if code := snap.rwpExemption(req, relPath, cr.processSets); code != "" {
+			log.Printf("[POLICY] Process %s is exempted for resource set %s, granting raw access", req.Binary, code)
+			result.ApplyKey = false
+			result.Audit = true
+			result.RawAccess = true
+			result.Exemption = code
+			result.View = config.ViewCiphertext
 		}
 	}
+	log.Printf("[POLICY] Policy %s decides %s by rule %s", cp.policy.Code, result.Permission, rule.ID)
+	return result
+}
 
-	return bestMatch
+func (cp *compiledPolicy) learning() bool {
+	return cp.policy.Mode == config.PolicyModeLearn
 }
 
-func (e *Engine) pathMatches(filePath, guardPath string) bool {
-	absFilePath, err := filepath.Abs(filePath)
-	if err != nil {
-		return false
+func (cgp *compiledGuardPoint) learning() bool {
+	return cgp.guardPoint.Mode == config.PolicyModeLearn
+}
+
+// policyLearning reports whether the guard point's policy code is in learn
+// mode.
+func (cgp *compiledGuardPoint) policyLearning(code string) bool {
+	for _, cp := range cgp.policies {
+		if cp.policy.Code == code {
+			return cp.learning()
+		}
 	}
+	return false
+}
 
-	absGuardPath, err := filepath.Abs(guardPath)
-	if err != nil {
-		return false
+// learn turns a denial into an audited permit when the guard point, or the
+// policy the denying rule belongs to, is in learn mode. A default deny is
+// learned if any of the guard point's policies is. The key is applied so
+// the caller sees the same data a permit rule would give it.
+func (cgp *compiledGuardPoint) learn(result *AccessResult) *AccessResult {
+	if result.Permission != "deny" {
+		return result
 	}
+	learning := cgp.learning()
+	for _, cp := range cgp.policies {
+		if learning {
+			break
+		}
+		if cp.learning() && (result.Policy == "" || result.Policy == cp.policy.Code) {
+			learning = true
+			// Attribute a default deny to the policy being learned
+			result.Policy = cp.policy.Code
+		}
+	}
+	if !learning {
+		return result
+	}
+	log.Printf("[POLICY] Learn mode: permitting access rule %s would deny", result.RuleID)
+	result.Permission = "permit"
+	result.ApplyKey = true
+	result.View = config.ViewPlaintext
+	result.Audit = true
+	result.Learned = true
+	result.LearnedRule = result.RuleID
+	result.LearnedPolicy = result.Policy
+	return result
+}
 
-	rel, err := filepath.Rel(absGuardPath, absFilePath)
-	if err != nil {
-		return false
+func (s *snapshot) findGuardPoint(path string) *compiledGuardPoint {
+	cgp, _ := s.guardPoints.longestPrefix(absPath(path))
+	return cgp
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
 	}
+	return filepath.Clean(path)
+}
 
-	return !strings.HasPrefix(rel, "..")
+// Conditions reported by ruleMismatch.
+const (
+	ConditionSchedule    = "schedule"
+	ConditionBrowsing    = "browsing"
+	ConditionAction      = "action"
+	ConditionUserSet     = "user_set"
+	ConditionProcessSet  = "process_set"
+	ConditionResourceSet = "resource_set"
+)
+
+func (s *snapshot) matchesRule(req *AccessRequest, relPath string, cr *compiledRule) bool {
+	condition, _ := s.ruleMismatch(req, relPath, cr)
+	return condition == ""
 }
 
-func (e *Engine) matchesRule(req *AccessRequest, rule *config.SecurityRule) bool {
+// ruleMismatch checks the conditions of a rule in order and returns the
+// first one that fails with a description, or "" if the rule matches.
+func (s *snapshot) ruleMismatch(req *AccessRequest, relPath string, cr *compiledRule) (condition, detail string) {
+	rule := &cr.rule
+
+	if cr.invalidSchedule {
+		log.Printf("[POLICY] Rule %s has an invalid schedule and never applies", rule.ID)
+		return ConditionSchedule, "schedule is invalid"
+	}
+	if cr.schedule != nil && !cr.schedule.Active(req.Time) {
+		log.Printf("[POLICY] Rule %s is outside its schedule at %s", rule.ID, req.Time.Format(time.RFC3339))
+		return ConditionSchedule, fmt.Sprintf("outside schedule at %s", req.Time.Format(time.RFC3339))
+	}
+
 	// Handle browsing (directory listing) separately
 	if req.Action == "browse" {
 		log.Printf("[POLICY] Checking browsing permission: req.Action=%s, rule.Browsing=%v", req.Action, rule.Browsing)
 		if !rule.Browsing {
 			log.Printf("[POLICY] Browsing not allowed")
-			return false
+			return ConditionBrowsing, "rule does not allow browsing"
 		}
 		log.Printf("[POLICY] Browsing allowed")
 	} else {
 		// Handle other actions (read, write, etc.)
 		log.Printf("[POLICY] Checking action match: req.Action=%s, rule.Action=%v", req.Action, rule.Action)
-		if !e.matchesAction(req.Action, rule.Action) {
+		if !matchesAction(req.Action, rule.Action) {
 			log.Printf("[POLICY] Action does not match")
-			return false
+			return ConditionAction, fmt.Sprintf("action %s not in %v", req.Action, rule.Action)
 		}
 		log.Printf("[POLICY] Action matches")
 	}
 
-	if len(rule.UserSet) > 0 {
-		log.Printf("[POLICY] Checking user set match: req.UID=%d, rule.UserSet=%v", req.UID, rule.UserSet)
-		if !e.matchesUserSet(req, rule.UserSet) {
+	if cr.hasUserSet {
+		log.Printf("[POLICY] Checking user set match: req.UID=%d, req.GID=%d, req.Groups=%v, rule.UserSet=%v", req.UID, req.GID, req.Groups, rule.UserSet)
+		if !s.matchesUserSet(req, cr.userSets) {
 			log.Printf("[POLICY] User set does not match")
-			return false
+			return ConditionUserSet, fmt.Sprintf("uid %d gid %d groups %v not in user sets %v", req.UID, req.GID, req.Groups, rule.UserSet)
 		}
 		log.Printf("[POLICY] User set matches")
 	}
 
 	// Only check process set for non-browsing operations, or when browsing is not explicitly allowed
-	if len(rule.ProcessSet) > 0 && !(req.Action == "browse" && rule.Browsing) {
+	if cr.hasProcessSet && !(req.Action == "browse" && rule.Browsing) {
 		log.Printf("[POLICY] Checking process set match: req.Binary=%s, rule.ProcessSet=%v", req.Binary, rule.ProcessSet)
-		if !e.matchesProcessSet(req, rule.ProcessSet) {
+		if !s.matchesProcessSet(req, cr.processSets) {
 			log.Printf("[POLICY] Process set does not match")
-			return false
+			return ConditionProcessSet, fmt.Sprintf("binary %s not in process sets %v", req.Binary, rule.ProcessSet)
 		}
 		log.Printf("[POLICY] Process set matches")
 	} else if req.Action == "browse" && rule.Browsing {
 		log.Printf("[POLICY] Skipping process set check for browsing operation (browsing=true)")
 	}
 
-	if len(rule.ResourceSet) > 0 {
-		log.Printf("[POLICY] Checking resource set match: req.Path=%s, rule.ResourceSet=%v", req.Path, rule.ResourceSet)
-		if !e.matchesResourceSet(req, rule.ResourceSet) {
+	if cr.hasResourceSet {
+		log.Printf("[POLICY] Checking resource set match: req.Path=%s, relPath=%s, rule.ResourceSet=%v", req.Path, relPath, rule.ResourceSet)
+		if !matchesResources(relPath, req.File, req.Action == "browse", cr.resources) {
 			log.Printf("[POLICY] Resource set does not match")
-			return false
+			return ConditionResourceSet, fmt.Sprintf("%s not in resource sets %v", relPath, rule.ResourceSet)
 		}
 		log.Printf("[POLICY] Resource set matches")
 	}
 
 	log.Printf("[POLICY] All conditions match for rule %s", rule.ID)
-	return true
+	return "", ""
 }
 
-func (e *Engine) matchesAction(reqAction string, ruleActions []string) bool {
+func matchesAction(reqAction string, ruleActions []string) bool {
 	for _, action := range ruleActions {
 		if action == "all_ops" || action == reqAction {
 			return true
@@ -232,119 +632,107 @@ func (e *Engine) matchesAction(reqAction string, ruleActions []string) bool {
 	return false
 }
 
-func (e *Engine) matchesUserSet(req *AccessRequest, userSets []string) bool {
-	for _, userSetCode := range userSets {
-		userSet := e.userSetMap[userSetCode]
-		if userSet != nil {
-			for _, user := range userSet.Users {
-				if user.UID == req.UID {
-					return true
-				}
+func (s *snapshot) matchesUserSet(req *AccessRequest, userSets []*config.UserSet) bool {
+	for _, userSet := range userSets {
+		for i := range userSet.Users {
+			if matchesUser(req, &userSet.Users[i], s.accounts) {
+				return true
 			}
 		}
 	}
 	return false
 }
 
-func (e *Engine) matchesProcessSet(req *AccessRequest, processSets []string) bool {
-	for _, processSetCode := range processSets {
-		processSet := e.processSetMap[processSetCode]
-		if processSet != nil {
-			for _, resource := range processSet.ResourceSetList {
-				if e.matchesProcessResource(req, &resource) {
-					return true
-				}
+func (s *snapshot) matchesProcessSet(req *AccessRequest, processSets []*config.ProcessSet) bool {
+	return s.matchesProcessSetAt(req, processSets, 0)
+}
+
+// matchesProcessSetAt is matchesProcessSet at a given depth of ancestor
+// checks, which bounds recursion between process sets naming each other
+// as ancestors.
+func (s *snapshot) matchesProcessSetAt(req *AccessRequest, processSets []*config.ProcessSet, depth int) bool {
+	for _, processSet := range processSets {
+		for i := range processSet.ResourceSetList {
+			resource := &processSet.ResourceSetList[i]
+			if matchesProcessResource(req, resource) && s.matchesProcessTree(req, resource, depth) {
+				return true
 			}
 		}
 	}
 	return false
 }
 
-func (e *Engine) matchesProcessResource(req *AccessRequest, resource *config.ProcessSetResource) bool {
-	binaryPath := filepath.Join(resource.Directory, resource.File)
-	return req.Binary == binaryPath || filepath.Base(req.Binary) == resource.File
-}
-
-func (e *Engine) matchesResourceSet(req *AccessRequest, resourceSets []string) bool {
-	for _, resourceSetCode := range resourceSets {
-		resourceSet := e.resourceSetMap[resourceSetCode]
-		if resourceSet != nil {
-			for _, resource := range resourceSet.ResourceList {
-				if e.matchesResource(req.Path, &resource) {
-					return true
+// rwpExemption returns the code of a resource set covering relPath that a
+// matching process set entry lists in rwp_exempted_resources, or "".
+func (s *snapshot) rwpExemption(req *AccessRequest, relPath string, processSets []*config.ProcessSet) string {
+	for _, processSet := range processSets {
+		for i := range processSet.ResourceSetList {
+			resource := &processSet.ResourceSetList[i]
+			if len(resource.RWPExemptedResources) == 0 {
+				continue
+			}
+			if !matchesProcessResource(req, resource) || !s.matchesProcessTree(req, resource, 0) {
+				continue
+			}
+			for _, code := range resource.RWPExemptedResources {
+				index := s.exemptResources[code]
+				if index == nil {
+					continue
+				}
+				if index.candidates(relPath, func(r *compiledResource) bool { return r.matches(relPath, req.File, req.Action == "browse") }) {
+					return code
 				}
 			}
 		}
 	}
-	return false
+	return ""
 }
 
-func (e *Engine) matchesResource(path string, resource *config.Resource) bool {
-	// Get guard point for this path
-	guardPoint := e.findGuardPoint(path)
-	if guardPoint == nil {
-		log.Printf("[POLICY] No guard point found for resource matching: %s", path)
+// matchesProcessResource checks the requesting binary against a process
+// set entry. Without signatures the entry matches by path or, for legacy
+// configurations, by base name alone. With signatures the path must match
+// exactly (base name only if no directory is given) and the executable
+// must satisfy at least one signature.
+func matchesProcessResource(req *AccessRequest, resource *config.ProcessSetResource) bool {
+	if resource.Container != nil && !matchesContainer(req.Container, resource.Container) {
 		return false
 	}
 
-	// Get relative path within the guard point
-	relPath, err := filepath.Rel(guardPoint.ProtectedPath, path)
-	if err != nil {
-		log.Printf("[POLICY] Failed to get relative path: %s relative to %s", path, guardPoint.ProtectedPath)
-		return false
+	binaryPath := filepath.Join(resource.Directory, resource.File)
+	if len(resource.Signature) == 0 {
+		return req.Binary == binaryPath || filepath.Base(req.Binary) == resource.File
 	}
 
-	log.Printf("[POLICY] Resource matching: path=%s, guardPoint=%s, relPath=%s, resource.Directory=%s", path, guardPoint.ProtectedPath, relPath, resource.Directory)
-
-	// Check directory match
-	resourceDir := strings.TrimPrefix(resource.Directory, "/")
-	log.Printf("[POLICY] Checking directory match: relPath=%s, resourceDir=%s, subfolder=%v", relPath, resourceDir, resource.Subfolder)
-	
-	// Handle guard point root directory listing
-	if relPath == "." {
-		// For directory listing of guard point root, allow if any resource in this directory
-		log.Printf("[POLICY] Guard point root directory listing - allowing access")
-		return true
+	if resource.Directory != "" && req.Binary != binaryPath {
+		return false
 	}
-	
-	if resource.Directory != "" {
-		// Resource is in a subdirectory
-		if resource.Subfolder {
-			// Check if path is under this directory
-			if !strings.HasPrefix(relPath, resourceDir) {
-				log.Printf("[POLICY] Path not under resource directory")
-				return false
-			}
-		} else {
-			// Check if path is directly in this directory
-			pathDir := filepath.Dir(relPath)
-			if pathDir != resourceDir {
-				log.Printf("[POLICY] Path not in resource directory")
-				return false
-			}
-		}
-	} else {
-		// Resource is in guard point root (resource.Directory == "")
-		log.Printf("[POLICY] Resource is in guard point root")
-		if !resource.Subfolder {
-			// File must be directly in guard point root
-			pathDir := filepath.Dir(relPath)
-			if pathDir != "." {
-				log.Printf("[POLICY] File not in guard point root")
-				return false
-			}
+	if resource.Directory == "" && filepath.Base(req.Binary) != resource.File {
+		return false
+	}
+	for _, signature := range resource.Signature {
+		if verifiesSignature(req, signature) {
+			return true
 		}
-		// If subfolder=true, allow files anywhere in guard point
 	}
+	log.Printf("[POLICY] Binary %s matches %s by path but fails signature verification", req.Binary, binaryPath)
+	return false
+}
 
-	// Check file pattern match
-	if resource.File != "*" {
-		filename := filepath.Base(relPath)
-		matched, _ := filepath.Match(resource.File, filename)
-		return matched
+// matchesResources reports whether any resource of the rule covers relPath,
+// the request path relative to the guard point root, with attributes file;
+// browse is set for directory listings.
+func matchesResources(relPath string, file *FileAttributes, browse bool, resources *resourceIndex) bool {
+	// Handle guard point root directory listing. With component matching
+	// the root is only covered by resources for the root.
+	if relPath == "." && resources.count > 0 && !resources.components {
+		// For directory listing of guard point root, allow if any resource in this directory
+		log.Printf("[POLICY] Guard point root directory listing - allowing access")
+		return true
 	}
 
-	return true
+	return resources.candidates(relPath, func(resource *compiledResource) bool {
+		return resource.matches(relPath, file, browse)
+	})
 }
 
 func (e *Engine) GetProcessInfo(pid int) (string, error) {
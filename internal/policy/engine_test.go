@@ -0,0 +1,242 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// combinedConfig is a guard point over /data combining policies a and b.
+// Each policy has a single rule, "<policy>-<permission>", matching every
+// request, or no rule if its permission is empty. Permits in a give the
+// ciphertext view and permits in b the plaintext.
+func combinedConfig(algorithm, gpMode, aPermission, aMode, bPermission, bMode string) *config.Config {
+	policy := func(code, permission, mode string, view string) config.Policy {
+		p := config.Policy{Code: code, Mode: mode}
+		if permission == "" {
+			return p
+		}
+		effect := config.RuleEffect{Permission: permission}
+		if permission == "permit" {
+			effect.Option = config.EffectOption{ApplyKey: view == config.ViewPlaintext, View: view}
+		}
+		p.SecurityRules = []config.SecurityRule{{ID: code + "-" + permission, Order: 1, Action: []string{"all_ops"}, Effect: effect}}
+		return p
+	}
+	return &config.Config{
+		GuardPoints: []config.GuardPoint{{
+			Code:               "gp",
+			ProtectedPath:      "/data",
+			SecureStoragePath:  "/secure/data",
+			Policies:           []string{"a", "b"},
+			CombiningAlgorithm: algorithm,
+			Mode:               gpMode,
+			Enabled:            true,
+		}},
+		Policies: []config.Policy{
+			policy("a", aPermission, aMode, config.ViewCiphertext),
+			policy("b", bPermission, bMode, config.ViewPlaintext),
+		},
+	}
+}
+
+func TestCombiningAlgorithms(t *testing.T) {
+	const (
+		first  = config.CombineFirstApplicable
+		deny   = config.CombineDenyOverrides
+		permit = config.CombinePermitOverrides
+		learn  = config.PolicyModeLearn
+	)
+	tests := []struct {
+		name        string
+		algorithm   string
+		gpMode      string
+		a, aMode    string
+		b, bMode    string
+		permission  string
+		rule        string
+		view        string
+		learnedRule string
+	}{
+		{name: "first-applicable takes the first policy", algorithm: first, a: "permit", b: "deny", permission: "permit", rule: "a-permit", view: config.ViewCiphertext},
+		{name: "first-applicable deny", algorithm: first, a: "deny", b: "permit", permission: "deny", rule: "a-deny"},
+		{name: "first-applicable skips policies without a match", algorithm: first, b: "permit", permission: "permit", rule: "b-permit", view: config.ViewPlaintext},
+		{name: "first-applicable learns a deny", algorithm: first, a: "deny", aMode: learn, b: "permit", permission: "permit", rule: "a-deny", view: config.ViewPlaintext, learnedRule: "a-deny"},
+		{name: "first-applicable enforces a deny outside learn mode", algorithm: first, a: "deny", b: "permit", bMode: learn, permission: "deny", rule: "a-deny"},
+		{name: "first-applicable learns the default deny", algorithm: first, aMode: learn, permission: "permit", rule: "default-deny", view: config.ViewPlaintext, learnedRule: "default-deny"},
+		{name: "default deny", algorithm: first, permission: "deny", rule: "default-deny"},
+
+		{name: "deny-overrides deny wins", algorithm: deny, a: "permit", b: "deny", permission: "deny", rule: "b-deny"},
+		{name: "deny-overrides permit", algorithm: deny, a: "permit", b: "permit", permission: "permit", rule: "a-permit", view: config.ViewCiphertext},
+		{name: "deny-overrides learn deny keeps the permit's view", algorithm: deny, a: "permit", b: "deny", bMode: learn, permission: "permit", rule: "a-permit", view: config.ViewCiphertext, learnedRule: "b-deny"},
+		{name: "deny-overrides learn deny keeps a later permit", algorithm: deny, a: "deny", aMode: learn, b: "permit", permission: "permit", rule: "b-permit", view: config.ViewPlaintext, learnedRule: "a-deny"},
+		{name: "deny-overrides enforced deny beats a learn deny", algorithm: deny, a: "deny", aMode: learn, b: "deny", permission: "deny", rule: "b-deny"},
+		{name: "deny-overrides learns a deny nothing permits", algorithm: deny, a: "deny", aMode: learn, permission: "permit", rule: "a-deny", view: config.ViewPlaintext, learnedRule: "a-deny"},
+		{name: "deny-overrides guard point learn keeps the permit's view", algorithm: deny, gpMode: learn, a: "permit", b: "deny", permission: "permit", rule: "a-permit", view: config.ViewCiphertext, learnedRule: "b-deny"},
+		{name: "deny-overrides guard point learn without permit", algorithm: deny, gpMode: learn, a: "deny", b: "deny", permission: "permit", rule: "a-deny", view: config.ViewPlaintext, learnedRule: "a-deny"},
+
+		{name: "permit-overrides permit wins", algorithm: permit, a: "deny", b: "permit", permission: "permit", rule: "b-permit", view: config.ViewPlaintext},
+		{name: "permit-overrides first deny", algorithm: permit, a: "deny", b: "deny", permission: "deny", rule: "a-deny"},
+		{name: "permit-overrides permit beats a learn deny", algorithm: permit, a: "deny", aMode: learn, b: "permit", permission: "permit", rule: "b-permit", view: config.ViewPlaintext},
+		{name: "permit-overrides enforced deny beats a learn deny", algorithm: permit, a: "deny", aMode: learn, b: "deny", permission: "deny", rule: "b-deny"},
+		{name: "permit-overrides learns a deny", algorithm: permit, a: "deny", aMode: learn, b: "deny", bMode: learn, permission: "permit", rule: "a-deny", view: config.ViewPlaintext, learnedRule: "a-deny"},
+		{name: "permit-overrides guard point learn", algorithm: permit, gpMode: learn, a: "deny", b: "deny", permission: "permit", rule: "a-deny", view: config.ViewPlaintext, learnedRule: "a-deny"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(combinedConfig(tt.algorithm, tt.gpMode, tt.a, tt.aMode, tt.b, tt.bMode))
+			req := &AccessRequest{Path: "/data/f", Action: "read", UID: 1000, GID: 1000}
+			result, err := engine.EvaluateAccess(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Permission != tt.permission || result.RuleID != tt.rule || result.View != tt.view {
+				t.Errorf("decision = %s by %s with view %q, want %s by %s with view %q", result.Permission, result.RuleID, result.View, tt.permission, tt.rule, tt.view)
+			}
+			if result.Learned != (tt.learnedRule != "") || result.LearnedRule != tt.learnedRule {
+				t.Errorf("learned = %v by %q, want %q", result.Learned, result.LearnedRule, tt.learnedRule)
+			}
+			if result.Learned && !result.Audit {
+				t.Error("learned decision is not audited")
+			}
+			if wantKey := tt.view == config.ViewPlaintext; result.ApplyKey != wantKey {
+				t.Errorf("apply key = %v, want %v", result.ApplyKey, wantKey)
+			}
+
+			// Explain must reach the same decision
+			exp, err := engine.Explain(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := exp.Decision; d.Permission != result.Permission || d.RuleID != result.RuleID || d.View != result.View || d.LearnedRule != result.LearnedRule {
+				t.Errorf("Explain decision = %+v, EvaluateAccess = %+v", d, result)
+			}
+		})
+	}
+}
+
+func TestLearnMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		gpMode        string
+		policyMode    string
+		action        string
+		permission    string
+		rule          string
+		learnedPolicy string
+	}{
+		{name: "enforced deny", action: "write", permission: "deny", rule: "no-writes"},
+		{name: "enforced default deny", action: "read", permission: "deny", rule: "default-deny"},
+		{name: "policy learns a deny", policyMode: config.PolicyModeLearn, action: "write", permission: "permit", rule: "no-writes", learnedPolicy: "p"},
+		{name: "policy learns the default deny", policyMode: config.PolicyModeLearn, action: "read", permission: "permit", rule: "default-deny", learnedPolicy: "p"},
+		{name: "guard point learns a deny", gpMode: config.PolicyModeLearn, action: "write", permission: "permit", rule: "no-writes", learnedPolicy: "p"},
+		{name: "guard point learns the default deny", gpMode: config.PolicyModeLearn, action: "read", permission: "permit", rule: "default-deny"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(&config.Config{
+				GuardPoints: []config.GuardPoint{{
+					Code:              "gp",
+					ProtectedPath:     "/data",
+					SecureStoragePath: "/secure/data",
+					Policy:            "p",
+					Mode:              tt.gpMode,
+					Enabled:           true,
+				}},
+				Policies: []config.Policy{{
+					Code: "p",
+					Mode: tt.policyMode,
+					SecurityRules: []config.SecurityRule{{
+						ID: "no-writes", Order: 1, Action: []string{"write"}, Effect: config.RuleEffect{Permission: "deny"},
+					}},
+				}},
+			})
+			// Twice, so that the second decision comes from the cache
+			for n := 0; n < 2; n++ {
+				result, err := engine.EvaluateAccess(&AccessRequest{Path: "/data/f", Action: tt.action, UID: 1000, GID: 1000})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Permission != tt.permission || result.RuleID != tt.rule {
+					t.Fatalf("decision = %s by %s, want %s by %s", result.Permission, result.RuleID, tt.permission, tt.rule)
+				}
+				learned := tt.permission == "permit"
+				if result.Learned != learned {
+					t.Fatalf("learned = %v, want %v", result.Learned, learned)
+				}
+				if !learned {
+					continue
+				}
+				// The workload keeps seeing plaintext, and the event names
+				// the rule and policy that would have denied
+				if !result.ApplyKey || result.View != config.ViewPlaintext || !result.Audit {
+					t.Errorf("learned permit = %+v, want the plaintext view, audited", result)
+				}
+				if result.LearnedRule != tt.rule || result.LearnedPolicy != tt.learnedPolicy {
+					t.Errorf("learned from %s of %q, want %s of %q", result.LearnedRule, result.LearnedPolicy, tt.rule, tt.learnedPolicy)
+				}
+			}
+		})
+	}
+}
+
+func TestRWPExemption(t *testing.T) {
+	engine := NewEngine(&config.Config{
+		GuardPoints: []config.GuardPoint{{
+			Code:              "gp",
+			ProtectedPath:     "/data",
+			SecureStoragePath: "/secure/data",
+			Policy:            "p",
+			Enabled:           true,
+		}},
+		ProcessSets: []config.ProcessSet{
+			{Code: "db", ResourceSetList: []config.ProcessSetResource{{
+				Directory: "/usr/sbin/", File: "mysqld", RWPExemptedResources: []string{"tablespaces", "missing"},
+			}}},
+			{Code: "backup", ResourceSetList: []config.ProcessSetResource{{Directory: "/usr/bin/", File: "backup"}}},
+		},
+		ResourceSets: []config.ResourceSet{{
+			Code:         "tablespaces",
+			ResourceList: []config.Resource{{Directory: "", File: "*.ibd"}},
+		}},
+		Policies: []config.Policy{{
+			Code: "p",
+			SecurityRules: []config.SecurityRule{
+				{ID: "db-no-writes", Order: 1, Action: []string{"write"}, ProcessSet: []string{"db"}, ResourceSet: []string{"tablespaces"}, Effect: config.RuleEffect{Permission: "deny"}},
+				{ID: "db", Order: 2, Action: []string{"all_ops"}, ProcessSet: []string{"db"}, Effect: config.RuleEffect{Permission: "permit", Option: config.EffectOption{ApplyKey: true}}},
+				{ID: "backup", Order: 3, Action: []string{"all_ops"}, ProcessSet: []string{"backup"}, Effect: config.RuleEffect{Permission: "permit", Option: config.EffectOption{ApplyKey: true}}},
+			},
+		}},
+	})
+
+	tests := []struct {
+		name      string
+		binary    string
+		path      string
+		action    string
+		rule      string
+		exemption string
+	}{
+		{"exempted process on exempted resource", "/usr/sbin/mysqld", "/data/users.ibd", "read", "db", "tablespaces"},
+		{"exempted process elsewhere", "/usr/sbin/mysqld", "/data/my.cnf", "read", "db", ""},
+		{"exemption does not turn a deny into access", "/usr/sbin/mysqld", "/data/users.ibd", "write", "db-no-writes", ""},
+		{"other process on exempted resource", "/usr/bin/backup", "/data/users.ibd", "read", "backup", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.EvaluateAccess(&AccessRequest{Path: tt.path, Action: tt.action, UID: 27, GID: 27, Binary: tt.binary})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.RuleID != tt.rule || result.Exemption != tt.exemption || result.RawAccess != (tt.exemption != "") {
+				t.Fatalf("decision by %s, exemption %q (raw %v), want %s, exemption %q", result.RuleID, result.Exemption, result.RawAccess, tt.rule, tt.exemption)
+			}
+			if result.RawAccess && (result.ApplyKey || result.View != config.ViewCiphertext || !result.Audit) {
+				t.Errorf("exempted decision = %+v, want the ciphertext view without the key, audited", result)
+			}
+			if !result.RawAccess && result.Permission == "permit" && result.View != config.ViewPlaintext {
+				t.Errorf("view = %q, want plaintext", result.View)
+			}
+		})
+	}
+}
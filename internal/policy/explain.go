@@ -0,0 +1,103 @@
+package policy
+
+import "time"
+
+// Explanation describes how a request was decided: the guard point and
+// policies it fell under, every rule considered in order and the outcome.
+type Explanation struct {
+	Path         string          `json:"path"`
+	Action       string          `json:"action"`
+	UID          int             `json:"uid"`
+	GID          int             `json:"gid"`
+	Groups       []int           `json:"groups,omitempty"`
+	Binary       string          `json:"binary,omitempty"`
+	ProcessID    int             `json:"pid,omitempty"`
+	Container    string          `json:"container,omitempty"`
+	File         *FileAttributes `json:"file,omitempty"`
+	Time         time.Time       `json:"time"`
+	GuardPoint   string          `json:"guard_point,omitempty"`
+	ProtectedDir string          `json:"protected_path,omitempty"`
+	Policies     []string        `json:"policies,omitempty"`
+	Algorithm    string          `json:"combining_algorithm,omitempty"`
+	RelativePath string          `json:"relative_path,omitempty"`
+
+	Rules    []RuleTrace `json:"rules"`
+	Decision Decision    `json:"decision"`
+}
+
+// RuleTrace is the outcome of checking one rule. FailedCondition names the
+// first condition that did not hold and is empty for the matching rule.
+type RuleTrace struct {
+	Policy          string `json:"policy"`
+	ID              string `json:"id"`
+	Order           int    `json:"order"`
+	Matched         bool   `json:"matched"`
+	FailedCondition string `json:"failed_condition,omitempty"`
+	Detail          string `json:"detail,omitempty"`
+}
+
+// Decision is the final result of an explained request.
+type Decision struct {
+	Permission string `json:"permission"`
+	RuleID     string `json:"rule_id,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+	Reason     string `json:"reason"`
+	ApplyKey   bool   `json:"apply_key"`
+	View       string `json:"view,omitempty"`
+	Audit      bool   `json:"audit"`
+	RawAccess  bool   `json:"raw_access,omitempty"`
+	Exemption  string `json:"exemption,omitempty"`
+	Learned    bool   `json:"learned,omitempty"`
+	// LearnedRule is the rule in learn mode that would have denied
+	LearnedRule string `json:"learned_rule,omitempty"`
+}
+
+// Explain evaluates req like EvaluateAccess, bypassing the decision cache,
+// and records why each rule did or did not apply.
+func (e *Engine) Explain(req *AccessRequest) (*Explanation, error) {
+	snap := e.current.Load()
+	cgp := snap.findGuardPoint(req.Path)
+
+	e.complete(snap, cgp, req)
+
+	exp := &Explanation{
+		Path:      absPath(req.Path),
+		Action:    req.Action,
+		UID:       req.UID,
+		GID:       req.GID,
+		Groups:    req.Groups,
+		Binary:    req.Binary,
+		ProcessID: req.ProcessID,
+		File:      req.File,
+		Time:      req.Time,
+		Rules:     []RuleTrace{},
+	}
+	if req.Container != nil && req.Container.InContainer() {
+		exp.Container = req.Container.Key()
+	}
+	if cgp != nil {
+		exp.GuardPoint = cgp.guardPoint.Code
+		exp.ProtectedDir = cgp.guardPoint.ProtectedPath
+		exp.Policies = append([]string(nil), cgp.guardPoint.PolicyCodes()...)
+		exp.Algorithm = cgp.algorithm
+	}
+
+	result, err := snap.evaluate(req, cgp, exp)
+	if err != nil {
+		return exp, err
+	}
+	exp.Decision = Decision{
+		Permission:  result.Permission,
+		RuleID:      result.RuleID,
+		Policy:      result.Policy,
+		Reason:      result.Reason,
+		ApplyKey:    result.ApplyKey,
+		View:        result.View,
+		Audit:       result.Audit,
+		RawAccess:   result.RawAccess,
+		Exemption:   result.Exemption,
+		Learned:     result.Learned,
+		LearnedRule: result.LearnedRule,
+	}
+	return exp, nil
+}
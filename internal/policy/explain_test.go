@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// TestExplainMatchesEvaluate checks that Explain reports the decision
+// EvaluateAccess makes, fresh and cached, for every reason a decision can
+// have.
+func TestExplainMatchesEvaluate(t *testing.T) {
+	cfg := &config.Config{
+		GuardPoints: []config.GuardPoint{
+			{Code: "gp", ProtectedPath: "/data", SecureStoragePath: "/secure/data", Policy: "p", Enabled: true},
+			{Code: "learn", ProtectedPath: "/learn", SecureStoragePath: "/secure/learn", Policies: []string{"p", "learning"}, Enabled: true},
+			{Code: "off", ProtectedPath: "/off", SecureStoragePath: "/secure/off", Policy: "p"},
+			{Code: "broken", ProtectedPath: "/broken", SecureStoragePath: "/secure/broken", Policy: "missing", Enabled: true},
+		},
+		UserSets: []config.UserSet{
+			{Code: "staff", Users: []config.User{{GID: 100, MatchBy: config.MatchByGID}}},
+			{Code: "admins", Users: []config.User{{UID: 0}}},
+		},
+		ProcessSets: []config.ProcessSet{{Code: "db", ResourceSetList: []config.ProcessSetResource{{
+			Directory: "/usr/sbin/", File: "mysqld", RWPExemptedResources: []string{"tablespaces"},
+		}}}},
+		ResourceSets: []config.ResourceSet{
+			{Code: "tablespaces", ResourceList: []config.Resource{{Directory: "", File: "*.ibd"}}},
+			{Code: "public", ResourceList: []config.Resource{{Directory: "public", File: "*", Subfolder: true}}},
+		},
+		Policies: []config.Policy{
+			{Code: "p", SecurityRules: []config.SecurityRule{
+				{ID: "db", Order: 1, Action: []string{"all_ops"}, ProcessSet: []string{"db"}, Effect: config.RuleEffect{Permission: "permit", Option: config.EffectOption{ApplyKey: true}}},
+				{ID: "public", Order: 2, Action: []string{"read"}, ResourceSet: []string{"public"}, Effect: config.RuleEffect{Permission: "permit", Option: config.EffectOption{View: config.ViewMasked, ApplyKey: true, Audit: true}}},
+				{ID: "staff-read", Order: 3, Action: []string{"read"}, UserSet: []string{"staff"}, Effect: config.RuleEffect{Permission: "permit", Option: config.EffectOption{ApplyKey: true}}},
+				{ID: "staff-no-writes", Order: 4, Action: []string{"write"}, UserSet: []string{"staff"}, Effect: config.RuleEffect{Permission: "deny"}},
+			}},
+			{Code: "learning", Mode: config.PolicyModeLearn, SecurityRules: []config.SecurityRule{
+				{ID: "admins-only", Order: 1, Action: []string{"all_ops"}, UserSet: []string{"admins"}, Effect: config.RuleEffect{Permission: "permit"}},
+				{ID: "learn-deny", Order: 2, Action: []string{"all_ops"}, Effect: config.RuleEffect{Permission: "deny"}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		req    AccessRequest
+		reason string
+	}{
+		{"outside guard points", AccessRequest{Path: "/etc/passwd", Action: "read", UID: 1000, GID: 100}, ReasonNoGuardPoint},
+		{"disabled guard point", AccessRequest{Path: "/off/a", Action: "read", UID: 1000, GID: 100}, ReasonGuardPointDisabled},
+		{"permit", AccessRequest{Path: "/data/a", Action: "read", UID: 1000, GID: 100}, ReasonRulePermitted},
+		{"permit by supplementary group", AccessRequest{Path: "/data/a", Action: "read", UID: 1000, GID: 1000, Groups: []int{100}}, ReasonRulePermitted},
+		{"masked view", AccessRequest{Path: "/data/public/a", Action: "read", UID: 1000, GID: 1000, Groups: []int{}}, ReasonRulePermitted},
+		{"deny", AccessRequest{Path: "/data/a", Action: "write", UID: 1000, GID: 100}, ReasonRuleDenied},
+		{"no rule", AccessRequest{Path: "/data/a", Action: "write", UID: 1000, GID: 1000, Groups: []int{}}, ReasonNoRuleMatched},
+		{"unresolved groups", AccessRequest{Path: "/data/a", Action: "read", UID: 1000, GID: 1000, ProcessID: 1 << 30}, ReasonIdentityUnresolved},
+		{"learned deny", AccessRequest{Path: "/learn/a", Action: "write", UID: 1000, GID: 1000, Groups: []int{}}, ReasonRuleDenied},
+		{"learn mode permit", AccessRequest{Path: "/learn/a", Action: "write", UID: 0, GID: 0, Groups: []int{}}, ReasonRulePermitted},
+		{"exemption", AccessRequest{Path: "/data/users.ibd", Action: "read", UID: 27, GID: 27, Groups: []int{}, Binary: "/usr/sbin/mysqld"}, ReasonRulePermitted},
+	}
+	for _, cached := range []bool{false, true} {
+		engine := NewEngine(cfg)
+		now := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+		engine.SetClock(func() time.Time { return now })
+		if cached {
+			engine.SetDecisionCache(NewDecisionCache(64))
+		}
+		for _, tt := range tests {
+			name := tt.name
+			if cached {
+				name += " cached"
+			}
+			t.Run(name, func(t *testing.T) {
+				// Evaluated twice, so that a cache serves the second
+				var result *AccessResult
+				for i := 0; i < 2; i++ {
+					req := tt.req
+					var err error
+					if result, err = engine.EvaluateAccess(&req); err != nil {
+						t.Fatal(err)
+					}
+				}
+				req := tt.req
+				exp, err := engine.Explain(&req)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if result.Reason != tt.reason {
+					t.Errorf("EvaluateAccess reason = %s, want %s", result.Reason, tt.reason)
+				}
+				want := Decision{
+					Permission:  result.Permission,
+					RuleID:      result.RuleID,
+					Policy:      result.Policy,
+					Reason:      result.Reason,
+					ApplyKey:    result.ApplyKey,
+					View:        result.View,
+					Audit:       result.Audit,
+					RawAccess:   result.RawAccess,
+					Exemption:   result.Exemption,
+					Learned:     result.Learned,
+					LearnedRule: result.LearnedRule,
+				}
+				if exp.Decision != want {
+					t.Errorf("Explain decision = %+v, EvaluateAccess = %+v", exp.Decision, want)
+				}
+				// The trace ends at the deciding rule
+				if want.RuleID != "" && want.RuleID != "default-deny" {
+					found := false
+					for _, rule := range exp.Rules {
+						if rule.ID == want.RuleID && rule.Policy == want.Policy && rule.Matched {
+							found = true
+						}
+					}
+					if !found {
+						t.Errorf("trace %+v does not show rule %s of policy %s matching", exp.Rules, want.RuleID, want.Policy)
+					}
+				}
+			})
+		}
+	}
+
+	// Both fail the same way on a guard point whose policy is missing
+	engine := NewEngine(cfg)
+	_, evalErr := engine.EvaluateAccess(&AccessRequest{Path: "/broken/a", Action: "read"})
+	_, explainErr := engine.Explain(&AccessRequest{Path: "/broken/a", Action: "read"})
+	if evalErr == nil || explainErr == nil || evalErr.Error() != explainErr.Error() {
+		t.Errorf("EvaluateAccess error %v, Explain error %v; want the same error", evalErr, explainErr)
+	}
+}
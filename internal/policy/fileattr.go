@@ -0,0 +1,293 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+	"github.com/takakrypt/transparent-encryption/internal/crypto"
+)
+
+// FileAttributes is the metadata of the file a request is for, as found
+// in the guard point's secure storage.
+type FileAttributes struct {
+	// Exists is false for a file that is not stored yet, such as one being
+	// created; it matches no metadata condition
+	Exists   bool  `json:"exists"`
+	Size     int64 `json:"size"` // plaintext size
+	OwnerUID int   `json:"owner_uid"`
+	// Xattrs holds the extended attributes named by resource conditions
+	// that the file has
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+}
+
+// compiledResource is a resource set entry with its patterns prepared.
+type compiledResource struct {
+	*config.Resource
+	regex *regexp.Regexp
+	// extensions in lower case with the leading dot
+	extensions []string
+
+	// components is set for configurations matching directories by path
+	// component; directory is then the directory pattern split into
+	// components, ending in "**" for entries including subfolders
+	components bool
+	directory  []string
+}
+
+func compileResource(resource *config.Resource, components bool) *compiledResource {
+	cr := &compiledResource{Resource: resource, components: components}
+	if components {
+		cr.directory = directoryPattern(resource)
+	}
+	if resource.Regex != "" {
+		// Load rejects invalid expressions; one that slips through
+		// matches nothing
+		regex, err := regexp.Compile(resource.Regex)
+		if err != nil {
+			regex = regexp.MustCompile(`[^\s\S]`)
+		}
+		cr.regex = regex
+	}
+	for _, ext := range resource.Extensions {
+		cr.extensions = append(cr.extensions, "."+strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+	return cr
+}
+
+// matches checks the entry against relPath, the path relative to the
+// guard point, and the file's metadata; browse is set for directory
+// listings. With component matching the directory is checked here;
+// otherwise the index already has.
+func (r *compiledResource) matches(relPath string, file *FileAttributes, browse bool) bool {
+	if r.components {
+		components := splitPath(relPath)
+		// The entry's own directory matches whatever its name, and so do
+		// the subfolders it covers when they are listed. Anything else
+		// below it is checked as a file.
+		if matchComponents(r.ownDirectory(), components) || (browse && matchComponents(r.directory, components)) {
+			return r.matchesFile(relPath, file)
+		}
+		if len(components) == 0 || !matchComponents(r.directory, components[:len(components)-1]) {
+			return false
+		}
+	}
+
+	filename := filepath.Base(relPath)
+	if r.File != "*" {
+		if matched, _ := filepath.Match(r.File, filename); !matched {
+			return false
+		}
+	}
+	if len(r.extensions) > 0 {
+		lower := strings.ToLower(filename)
+		found := false
+		for _, ext := range r.extensions {
+			if strings.HasSuffix(lower, ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.matchesFile(relPath, file)
+}
+
+// ownDirectory is the directory pattern without a trailing "**", such as
+// the one subfolder adds.
+func (r *compiledResource) ownDirectory() []string {
+	if len(r.directory) > 0 && r.directory[len(r.directory)-1] == "**" {
+		return r.directory[:len(r.directory)-1]
+	}
+	return r.directory
+}
+
+// matchesFile checks the conditions that do not depend on the file name:
+// the regex and the file's metadata.
+func (r *compiledResource) matchesFile(relPath string, file *FileAttributes) bool {
+	if r.regex != nil && !r.regex.MatchString(relPath) {
+		return false
+	}
+	if !r.InspectsFile() {
+		return true
+	}
+
+	if file == nil || !file.Exists {
+		return false
+	}
+	if r.MinSize != nil && file.Size < *r.MinSize {
+		return false
+	}
+	if r.MaxSize != nil && file.Size > *r.MaxSize {
+		return false
+	}
+	if r.OwnerUID != nil && file.OwnerUID != *r.OwnerUID {
+		return false
+	}
+	for name, want := range r.Xattrs {
+		value, ok := file.Xattrs[name]
+		if !ok || (want != "*" && value != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// directoryPattern splits the entry's directory into components, "" and
+// "/" being the guard point root, and appends "**" for subfolders.
+func directoryPattern(resource *config.Resource) []string {
+	var pattern []string
+	for _, component := range strings.Split(resource.Directory, "/") {
+		if component == "" || component == "." {
+			continue
+		}
+		pattern = appendComponent(pattern, component)
+	}
+	if resource.Subfolder {
+		pattern = appendComponent(pattern, "**")
+	}
+	return pattern
+}
+
+// appendComponent appends a pattern component, folding repeated "**".
+func appendComponent(pattern []string, component string) []string {
+	if component == "**" && len(pattern) > 0 && pattern[len(pattern)-1] == "**" {
+		return pattern
+	}
+	return append(pattern, component)
+}
+
+// literalPrefix returns the leading components of pattern that contain no
+// glob, joined, for indexing.
+func literalPrefix(pattern []string) string {
+	var literal []string
+	for _, component := range pattern {
+		if strings.ContainsAny(component, `*?[\`) {
+			break
+		}
+		literal = append(literal, component)
+	}
+	return strings.Join(literal, "/")
+}
+
+// matchComponents reports whether path matches pattern component by
+// component. Components are path.Match globs, and "**" matches any number
+// of components, none included.
+func matchComponents(pattern, components []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(components); i++ {
+				if matchComponents(pattern[1:], components[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(components) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], components[0]); !matched {
+			return false
+		}
+		pattern, components = pattern[1:], components[1:]
+	}
+	return len(components) == 0
+}
+
+// readFileAttributes reads the metadata of the stored file at path and
+// the extended attributes in names. A missing file is not an error.
+func readFileAttributes(path string, names []string) (*FileAttributes, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+			return &FileAttributes{}, nil
+		}
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+
+	file := &FileAttributes{
+		Exists:   true,
+		Size:     st.Size,
+		OwnerUID: int(st.Uid),
+	}
+	if st.Mode&syscall.S_IFMT == syscall.S_IFREG && st.Size >= crypto.Overhead {
+		file.Size -= crypto.Overhead
+	}
+
+	for _, name := range names {
+		value, err := getxattr(path, name)
+		if errors.Is(err, syscall.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xattr %s of %s: %w", name, path, err)
+		}
+		if file.Xattrs == nil {
+			file.Xattrs = make(map[string]string)
+		}
+		file.Xattrs[name] = value
+	}
+	return file, nil
+}
+
+func getxattr(path, name string) (string, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Getxattr(path, name, buf)
+		// The value grew between the two calls
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+// resolveFile reads the attributes of the file req is for from the
+// secure storage of cgp. On failure the file is treated as missing.
+func resolveFile(req *AccessRequest, cgp *compiledGuardPoint, names []string) *FileAttributes {
+	relPath, err := filepath.Rel(cgp.path, absPath(req.Path))
+	if err != nil {
+		return &FileAttributes{}
+	}
+	file, err := readFileAttributes(filepath.Join(cgp.guardPoint.SecureStoragePath, relPath), names)
+	if err != nil {
+		log.Printf("[POLICY] Failed to read attributes of %s: %v", req.Path, err)
+		return &FileAttributes{}
+	}
+	return file
+}
+
+// key identifies the attributes for the decision cache.
+func (f *FileAttributes) key() string {
+	if !f.Exists {
+		return "-"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:%d", f.Size, f.OwnerUID)
+	names := make([]string, 0, len(f.Xattrs))
+	for name := range f.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, ":%q=%q", name, f.Xattrs[name])
+	}
+	return b.String()
+}
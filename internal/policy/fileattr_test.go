@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func TestMatchComponents(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"hr", "hr", true},
+		{"hr", "hrx", false},
+		{"hr", "hr/a", false},
+		{"hr/**", "hr", true},
+		{"hr/**", "hr/a", true},
+		{"hr/**", "hr/a/b/c", true},
+		{"hr/**", "hrx/a", false},
+		{"**", "", true},
+		{"**", "a/b", true},
+		{"projects/*/secret", "projects/alpha/secret", true},
+		{"projects/*/secret", "projects/alpha/beta/secret", false},
+		{"projects/*/secret", "projects/secret", false},
+		{"logs/**/archive", "logs/archive", true},
+		{"logs/**/archive", "logs/2026/01/archive", true},
+		{"logs/**/archive", "logs/2026/archived", false},
+		{"logs/**/archive/**", "logs/x/archive/y/z", true},
+		{"a?c/[0-9]", "abc/7", true},
+		{"a?c/[0-9]", "abc/x", false},
+	}
+	for _, tt := range tests {
+		var pattern []string
+		for _, component := range strings.Split(tt.pattern, "/") {
+			if component != "" {
+				pattern = appendComponent(pattern, component)
+			}
+		}
+		if got := matchComponents(pattern, splitPath(tt.path)); got != tt.want {
+			t.Errorf("matchComponents(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResourceMatches(t *testing.T) {
+	csv := config.Resource{Directory: "finance", File: "*.csv", Extensions: []string{"csv"}, Subfolder: true}
+	size := int64(100)
+
+	tests := []struct {
+		name       string
+		resource   config.Resource
+		components bool
+		path       string
+		browse     bool
+		file       *FileAttributes
+		want       bool
+	}{
+		{name: "file in directory", resource: csv, components: true, path: "finance/a.csv", want: true},
+		{name: "file in subfolder", resource: csv, components: true, path: "finance/2026/q1/a.csv", want: true},
+		{name: "other name in directory", resource: csv, components: true, path: "finance/a.txt", want: false},
+		{name: "other name in subfolder", resource: csv, components: true, path: "finance/2026/a.exe", want: false},
+		{name: "glob without extension", resource: config.Resource{Directory: "finance", File: "*", Extensions: []string{"csv"}, Subfolder: true}, components: true, path: "finance/2026/a.exe", want: false},
+		{name: "entry directory", resource: csv, components: true, path: "finance", want: true},
+		{name: "subfolder listed", resource: csv, components: true, path: "finance/2026", browse: true, want: true},
+		{name: "subfolder read as a file", resource: csv, components: true, path: "finance/2026", want: false},
+		{name: "sibling prefix", resource: csv, components: true, path: "financex/a.csv", want: false},
+		{name: "no subfolder", resource: config.Resource{Directory: "finance", File: "*.csv"}, components: true, path: "finance/2026/a.csv", want: false},
+		{name: "explicit double star", resource: config.Resource{Directory: "finance/**", File: "*.csv"}, components: true, path: "finance/2026/a.exe", want: false},
+		{name: "root entry", resource: config.Resource{Directory: "/", File: "*.txt"}, components: true, path: "a.txt", want: true},
+		{name: "root entry nested", resource: config.Resource{Directory: "/", File: "*.txt"}, components: true, path: "x/a.txt", want: false},
+		{name: "regex on directory", resource: config.Resource{Directory: "finance", File: "*", Subfolder: true, Regex: `\.csv$`}, components: true, path: "finance/2026", browse: true, want: false},
+		{name: "size condition", resource: config.Resource{Directory: "finance", File: "*", MaxSize: &size}, components: true, path: "finance/a", file: &FileAttributes{Exists: true, Size: 101}, want: false},
+		{name: "size condition holds", resource: config.Resource{Directory: "finance", File: "*", MaxSize: &size}, components: true, path: "finance/a", file: &FileAttributes{Exists: true, Size: 100}, want: true},
+		// Version 1 leaves the directory to the index
+		{name: "v1 file glob", resource: csv, path: "finance/2026/a.exe", want: false},
+		{name: "v1 file glob matches", resource: csv, path: "finance/2026/a.csv", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := compileResource(&tt.resource, tt.components)
+			if got := r.matches(tt.path, tt.file, tt.browse); got != tt.want {
+				t.Errorf("matches(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// matchesUser checks one user set entry against the requesting identity,
+// according to the entry's match mode.
+func matchesUser(req *AccessRequest, u *config.User, accounts *accountDB) bool {
+	if u.Container != nil {
+		if !matchesContainer(req.Container, u.Container) {
+			return false
+		}
+		req = namespacedRequest(req)
+	}
+
+	switch u.MatchMode() {
+	case config.MatchByContainer:
+		return u.Container != nil
+	case config.MatchByUID:
+		return u.UID == req.UID
+	case config.MatchByUName:
+		name, ok := lookupUserName(req.UID)
+		return ok && name == u.UName
+	case config.MatchByGID:
+		return inGroup(req, u.GID)
+	case config.MatchByGName:
+		gid, ok := accounts.groupID(u.GName)
+		return ok && inGroup(req, gid)
+	case config.MatchByUIDRange:
+		return (u.UIDMin == nil || req.UID >= *u.UIDMin) && (u.UIDMax == nil || req.UID <= *u.UIDMax)
+	case config.MatchByGroupMember:
+		gid, ok := accounts.groupID(u.GName)
+		return ok && accounts.memberOf(req.UID, gid)
+	}
+	return false
+}
+
+// inGroup reports whether gid is the request's primary or a supplementary
+// group.
+func inGroup(req *AccessRequest, gid int) bool {
+	if req.GID == gid {
+		return true
+	}
+	for _, g := range req.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupUserName resolves a UID through NSS.
+func lookupUserName(uid int) (string, bool) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", false
+	}
+	return u.Username, true
+}
+
+// lookupGroupID resolves a group name through NSS.
+func lookupGroupID(name string) (int, bool) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, false
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	return gid, err == nil
+}
+
+// GetProcessGroups returns the supplementary group IDs of a process, from
+// the Groups: line of /proc/<pid>/status.
+func GetProcessGroups(pid int) ([]int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process status: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Groups:"))
+		groups := make([]int, 0, len(fields))
+		for _, field := range fields {
+			gid, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid group %q in process status: %w", field, err)
+			}
+			groups = append(groups, gid)
+		}
+		return groups, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read process status: %w", err)
+	}
+	return nil, fmt.Errorf("groups not found in process status")
+}
+
+// groupsKey renders a group list in a canonical form for cache keys.
+func groupsKey(groups []int) string {
+	sorted := append([]int(nil), groups...)
+	sort.Ints(sorted)
+	parts := make([]string, len(sorted))
+	for i, gid := range sorted {
+		parts[i] = strconv.Itoa(gid)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,198 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// maxAncestryDepth bounds the walk up the process tree
+const maxAncestryDepth = 64
+
+// interactiveShells are the shells that count as interactive when they
+// have a controlling terminal.
+var interactiveShells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true,
+	"mksh": true, "csh": true, "tcsh": true, "fish": true, "ash": true,
+	"busybox": true,
+}
+
+// procInfo is what the process tree conditions need to know about one
+// process.
+type procInfo struct {
+	pid       int
+	ppid      int
+	startTime uint64 // clock ticks after boot; distinguishes reused PIDs
+	tty       int
+	binary    string
+}
+
+// readProcStat parses /proc/<pid>/stat. The command name may contain
+// spaces and parentheses, so fields are counted from the last ')'.
+func readProcStat(pid int) (procInfo, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procInfo{}, fmt.Errorf("failed to read process stat: %w", err)
+	}
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return procInfo{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	// Fields after the command: state(3) ppid(4) pgrp(5) session(6)
+	// tty_nr(7) ... starttime(22)
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return procInfo{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	info := procInfo{pid: pid}
+	if info.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return procInfo{}, fmt.Errorf("invalid ppid for pid %d: %w", pid, err)
+	}
+	if info.tty, err = strconv.Atoi(fields[4]); err != nil {
+		return procInfo{}, fmt.Errorf("invalid tty for pid %d: %w", pid, err)
+	}
+	if info.startTime, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return procInfo{}, fmt.Errorf("invalid start time for pid %d: %w", pid, err)
+	}
+	// Kernel threads and other users' processes may hide their executable
+	info.binary, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	return info, nil
+}
+
+// procKey identifies a process across PID reuse.
+type procKey struct {
+	pid       int
+	startTime uint64
+}
+
+// ancestryCache holds the ancestry of the processes read during one
+// evaluation, keyed by PID and start time. Reparenting, exec and setsid
+// change the ancestry of a running process without changing its start
+// time, so a cache is only valid for the evaluation it was made for. Within
+// it, the decision cache key, the rule conditions and ancestor_process_set
+// conditions on the ancestors all share a single walk up the tree.
+type ancestryCache struct {
+	// started is the start time of each PID read
+	started map[int]uint64
+	chains  map[procKey][]procInfo
+}
+
+func newAncestryCache() *ancestryCache {
+	return &ancestryCache{started: make(map[int]uint64), chains: make(map[procKey][]procInfo)}
+}
+
+// ancestors returns the ancestors of pid, nearest first, ending at the
+// process whose parent is 0 (init or a kernel thread). A nil cache reads
+// them from /proc every time.
+func (c *ancestryCache) ancestors(pid int) ([]procInfo, error) {
+	if c != nil {
+		if start, ok := c.started[pid]; ok {
+			return c.chains[procKey{pid: pid, startTime: start}], nil
+		}
+	}
+	self, err := readProcStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	chain := parents(&self)
+	if c != nil {
+		c.add(&self, chain)
+		// Each ancestor's own ancestry is the rest of the chain, unless
+		// the walk stopped at the depth limit
+		if len(chain) < maxAncestryDepth {
+			for i := range chain {
+				c.add(&chain[i], chain[i+1:])
+			}
+		}
+	}
+	return chain, nil
+}
+
+func (c *ancestryCache) add(p *procInfo, chain []procInfo) {
+	c.started[p.pid] = p.startTime
+	c.chains[procKey{pid: p.pid, startTime: p.startTime}] = chain
+}
+
+// ancestryKey identifies a process and its ancestry for cache keys. Every
+// ancestor is identified by its PID, start time, terminal and binary, not
+// just the process itself, as they change while the process runs.
+func (c *ancestryCache) ancestryKey(pid int) string {
+	if c == nil {
+		c = newAncestryCache()
+	}
+	chain, err := c.ancestors(pid)
+	if err != nil {
+		return ""
+	}
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d:%d", pid, c.started[pid])
+	for _, p := range chain {
+		fmt.Fprintf(&key, "/%d:%d:%d:%s", p.pid, p.startTime, p.tty, p.binary)
+	}
+	return key.String()
+}
+
+// parents walks up the process tree from p.
+func parents(p *procInfo) []procInfo {
+	var chain []procInfo
+	for ppid := p.ppid; ppid > 0 && len(chain) < maxAncestryDepth; {
+		parent, err := readProcStat(ppid)
+		if err != nil {
+			// The parent exited between reads; what we have is all there is
+			break
+		}
+		chain = append(chain, parent)
+		ppid = parent.ppid
+	}
+	return chain
+}
+
+func isInteractiveShell(p *procInfo) bool {
+	return p.tty != 0 && interactiveShells[filepath.Base(p.binary)]
+}
+
+// matchesProcessTree applies the ancestry conditions of a process set
+// entry. Requests without a PID cannot be checked and fail closed.
+func (s *snapshot) matchesProcessTree(req *AccessRequest, resource *config.ProcessSetResource, depth int) bool {
+	if resource.AncestorProcessSet == "" && !resource.DenyInteractiveShell {
+		return true
+	}
+	if req.ProcessID <= 0 || depth >= maxAncestryDepth {
+		return false
+	}
+
+	chain, err := req.procs.ancestors(req.ProcessID)
+	if err != nil {
+		return false
+	}
+
+	if resource.DenyInteractiveShell {
+		for i := range chain {
+			if isInteractiveShell(&chain[i]) {
+				return false
+			}
+		}
+	}
+
+	if resource.AncestorProcessSet != "" {
+		ancestorSet := s.processSets[resource.AncestorProcessSet]
+		if ancestorSet == nil {
+			return false
+		}
+		for _, p := range chain {
+			ancestorReq := &AccessRequest{ProcessID: p.pid, Binary: p.binary, procs: req.procs}
+			if s.matchesProcessSetAt(ancestorReq, []*config.ProcessSet{ancestorSet}, depth+1) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestAncestryFollowsReparenting(t *testing.T) {
+	// The shell starts a background sleep and exits when its stdin closes,
+	// leaving the sleep to be reparented
+	cmd := exec.Command("sh", "-c", "sleep 30 & echo $!; read line; exit 0")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sh: %v", err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	before, err := newAncestryCache().ancestors(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) == 0 || before[0].pid != cmd.Process.Pid {
+		t.Fatalf("ancestors(%d) = %v, want the shell %d first", pid, before, cmd.Process.Pid)
+	}
+	keyBefore := newAncestryCache().ancestryKey(pid)
+
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := newAncestryCache().ancestors(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range after {
+		if p.pid == cmd.Process.Pid {
+			t.Fatalf("ancestors(%d) = %v still contains the exited shell", pid, after)
+		}
+	}
+	if keyAfter := newAncestryCache().ancestryKey(pid); keyAfter == keyBefore {
+		t.Errorf("ancestryKey(%d) = %q unchanged after reparenting", pid, keyAfter)
+	}
+}
+
+func TestAncestryKeyMissingProcess(t *testing.T) {
+	var procs *ancestryCache
+	if key := procs.ancestryKey(-1); key != "" {
+		t.Errorf("ancestryKey(-1) = %q, want empty", key)
+	}
+}
+
+func TestAncestryCacheSharesWalk(t *testing.T) {
+	procs := newAncestryCache()
+	chain, err := procs.ancestors(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := len(procs.chains)
+	if read != len(chain)+1 {
+		t.Errorf("cached %d processes after one walk, want %d", read, len(chain)+1)
+	}
+	// The ancestry of every ancestor comes from the same walk
+	for i, p := range chain {
+		got, err := procs.ancestors(p.pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, chain[i+1:]) {
+			t.Errorf("ancestors(%d) = %v, want %v", p.pid, got, chain[i+1:])
+		}
+	}
+	if len(procs.chains) != read {
+		t.Errorf("cached %d processes after reading the ancestors, want %d", len(procs.chains), read)
+	}
+
+	var uncached *ancestryCache
+	fresh, err := uncached.ancestors(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fresh, chain) {
+		t.Errorf("uncached ancestors = %v, want %v", fresh, chain)
+	}
+	if key := procs.ancestryKey(os.Getpid()); key != uncached.ancestryKey(os.Getpid()) {
+		t.Errorf("cached ancestryKey = %q, want the uncached %q", key, uncached.ancestryKey(os.Getpid()))
+	}
+}
@@ -0,0 +1,344 @@
+package policy
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// snapshot is an immutable, pre-compiled view of one configuration. The
+// engine swaps whole snapshots on reload, so an evaluation only ever sees a
+// single consistent configuration and never needs a lock.
+type snapshot struct {
+	generation  uint64
+	config      *config.Config
+	guardPoints *pathTrie[*compiledGuardPoint]
+	policies    map[string]*compiledPolicy
+
+	// handed is the copy of config that Engine.Config returns, made on
+	// first use
+	handedOnce sync.Once
+	handed     *config.Config
+
+	// matchesGroups is set when any user set entry matches by group, so
+	// requests need their supplementary groups
+	matchesGroups bool
+	// matchesMembers is set when any user set entry looks a group up in
+	// the account database, by name or for its members, so cached
+	// decisions must be tied to its state
+	matchesMembers bool
+	// accounts is the engine's account database
+	accounts *accountDB
+	// verifiesBinaries is set when any process set entry has signatures,
+	// so cached decisions must be tied to the executable's identity
+	verifiesBinaries bool
+	// checksAncestry is set when any process set entry has process tree
+	// conditions, so cached decisions must be tied to the process and its
+	// ancestry
+	checksAncestry bool
+	// matchesContainers is set when any user or process set entry has a
+	// container condition, so requests need their container identity
+	matchesContainers bool
+	// ciphertextViews is set when some permit can give a caller the
+	// ciphertext view, which reports the stored file size
+	ciphertextViews bool
+	// inspectsFiles is set when any resource has conditions on file
+	// metadata, so requests need the attributes of the stored file;
+	// xattrNames are the extended attributes those conditions name
+	inspectsFiles bool
+	xattrNames    []string
+
+	processSets map[string]*config.ProcessSet
+	// exemptResources indexes the resource sets named by process set
+	// rwp_exempted_resources
+	exemptResources map[string]*resourceIndex
+}
+
+type compiledGuardPoint struct {
+	guardPoint config.GuardPoint
+	path       string
+	// policies in evaluation order. missing is the code of the first
+	// policy not in the configuration, which fails every evaluation.
+	policies  []*compiledPolicy
+	missing   string
+	algorithm string
+}
+
+type compiledPolicy struct {
+	policy config.Policy
+	rules  []*compiledRule // sorted by Order
+	// schedules of all rules, for working out how long a decision holds
+	schedules []*config.Schedule
+}
+
+// compiledRule is a security rule with its set references resolved. The
+// has* flags record whether the rule named any sets at all, because a rule
+// whose set codes all dangle must still fail to match.
+type compiledRule struct {
+	rule config.SecurityRule
+
+	hasUserSet     bool
+	userSets       []*config.UserSet
+	hasProcessSet  bool
+	processSets    []*config.ProcessSet
+	hasResourceSet bool
+	resources      *resourceIndex
+
+	// schedule is nil for rules that always apply. A rule whose schedule
+	// does not parse never applies.
+	schedule        *config.Schedule
+	invalidSchedule bool
+}
+
+// resourceIndex finds the resources of a rule that can apply to a path
+// relative to the guard point, by the resource's directory.
+type resourceIndex struct {
+	// Resources including subfolders, keyed by directory prefix. With
+	// component matching, every resource, keyed by the literal part of
+	// its directory pattern.
+	subfolders *prefixTrie[*compiledResource]
+	// Resources limited to one directory, keyed by that directory ("."
+	// for the guard point root)
+	direct map[string][]*compiledResource
+	count  int
+
+	components bool
+}
+
+// compile builds a snapshot of a copy of cfg, so that later changes to
+// cfg do not reach it.
+func compile(cfg *config.Config) *snapshot {
+	cfg = cfg.Copy()
+	userSets := make(map[string]*config.UserSet)
+	for i := range cfg.UserSets {
+		userSets[cfg.UserSets[i].Code] = &cfg.UserSets[i]
+	}
+	processSets := make(map[string]*config.ProcessSet)
+	for i := range cfg.ProcessSets {
+		processSets[cfg.ProcessSets[i].Code] = &cfg.ProcessSets[i]
+	}
+	resourceSets := make(map[string]*config.ResourceSet)
+	for i := range cfg.ResourceSets {
+		resourceSets[cfg.ResourceSets[i].Code] = &cfg.ResourceSets[i]
+	}
+
+	snap := &snapshot{
+		config:      cfg,
+		guardPoints: newPathTrie[*compiledGuardPoint](),
+		policies:    make(map[string]*compiledPolicy),
+		processSets: processSets,
+
+		exemptResources: make(map[string]*resourceIndex),
+	}
+
+	for _, us := range cfg.UserSets {
+		for i := range us.Users {
+			switch us.Users[i].MatchMode() {
+			case config.MatchByGID:
+				snap.matchesGroups = true
+			case config.MatchByGName:
+				snap.matchesGroups = true
+				snap.matchesMembers = true
+			case config.MatchByGroupMember:
+				snap.matchesMembers = true
+			}
+			if us.Users[i].Container != nil {
+				snap.matchesContainers = true
+			}
+		}
+	}
+
+	for _, ps := range cfg.ProcessSets {
+		for _, resource := range ps.ResourceSetList {
+			if len(resource.Signature) > 0 {
+				snap.verifiesBinaries = true
+			}
+			if resource.AncestorProcessSet != "" || resource.DenyInteractiveShell {
+				snap.checksAncestry = true
+			}
+			if resource.Container != nil {
+				snap.matchesContainers = true
+			}
+			for _, code := range resource.RWPExemptedResources {
+				if rs := resourceSets[code]; rs != nil && snap.exemptResources[code] == nil {
+					snap.exemptResources[code] = indexResourceSets([]*config.ResourceSet{rs}, cfg.ComponentPaths())
+				}
+			}
+		}
+	}
+
+	snap.ciphertextViews = len(snap.exemptResources) > 0
+
+	xattrNames := make(map[string]bool)
+	for _, rs := range cfg.ResourceSets {
+		for _, resource := range rs.ResourceList {
+			if resource.InspectsFile() {
+				snap.inspectsFiles = true
+			}
+			for name := range resource.Xattrs {
+				if !xattrNames[name] {
+					xattrNames[name] = true
+					snap.xattrNames = append(snap.xattrNames, name)
+				}
+			}
+		}
+	}
+	sort.Strings(snap.xattrNames)
+
+	for _, p := range cfg.Policies {
+		cp := &compiledPolicy{policy: p}
+		for _, rule := range p.SecurityRules {
+			if rule.Effect.Permission == "permit" && rule.Effect.Option.ReadView() == config.ViewCiphertext {
+				snap.ciphertextViews = true
+			}
+			cr := compileRule(rule, userSets, processSets, resourceSets, cfg.ComponentPaths())
+			if cr.schedule != nil {
+				cp.schedules = append(cp.schedules, cr.schedule)
+			}
+			cp.rules = append(cp.rules, cr)
+		}
+		sort.SliceStable(cp.rules, func(i, j int) bool {
+			return cp.rules[i].rule.Order < cp.rules[j].rule.Order
+		})
+		if _, exists := snap.policies[p.Code]; !exists {
+			snap.policies[p.Code] = cp
+		}
+	}
+
+	for _, gp := range cfg.GuardPoints {
+		path, err := filepath.Abs(gp.ProtectedPath)
+		if err != nil {
+			continue
+		}
+		cgp := &compiledGuardPoint{
+			guardPoint: gp,
+			path:       path,
+			algorithm:  gp.CombiningAlgorithm,
+		}
+		if cgp.algorithm == "" {
+			cgp.algorithm = config.CombineFirstApplicable
+		}
+		for _, code := range gp.PolicyCodes() {
+			cp := snap.policies[code]
+			if cp == nil {
+				cgp.missing = code
+				break
+			}
+			cgp.policies = append(cgp.policies, cp)
+		}
+		if len(gp.PolicyCodes()) == 0 {
+			cgp.missing = gp.Policy
+		}
+		snap.guardPoints.insert(path, cgp)
+	}
+
+	return snap
+}
+
+func compileRule(rule config.SecurityRule, userSets map[string]*config.UserSet, processSets map[string]*config.ProcessSet, resourceSets map[string]*config.ResourceSet, componentPaths bool) *compiledRule {
+	cr := &compiledRule{
+		rule:           rule,
+		hasUserSet:     len(rule.UserSet) > 0,
+		hasProcessSet:  len(rule.ProcessSet) > 0,
+		hasResourceSet: len(rule.ResourceSet) > 0,
+	}
+
+	for _, code := range rule.UserSet {
+		if us := userSets[code]; us != nil {
+			cr.userSets = append(cr.userSets, us)
+		}
+	}
+	for _, code := range rule.ProcessSet {
+		if ps := processSets[code]; ps != nil {
+			cr.processSets = append(cr.processSets, ps)
+		}
+	}
+	if rule.Schedule != nil {
+		schedule, err := config.ParseSchedule(rule.Schedule)
+		if err != nil {
+			cr.invalidSchedule = true
+		} else {
+			cr.schedule = schedule
+		}
+	}
+
+	var sets []*config.ResourceSet
+	for _, code := range rule.ResourceSet {
+		if rs := resourceSets[code]; rs != nil {
+			sets = append(sets, rs)
+		}
+	}
+	cr.resources = indexResourceSets(sets, componentPaths)
+
+	return cr
+}
+
+// scheduleChange returns when the schedules of the guard point's policies
+// may next change a decision made at now, or the zero time if never.
+func (cgp *compiledGuardPoint) scheduleChange(now time.Time) time.Time {
+	var next time.Time
+	for _, cp := range cgp.policies {
+		for _, schedule := range cp.schedules {
+			if c := schedule.NextChange(now); !c.IsZero() && (next.IsZero() || c.Before(next)) {
+				next = c
+			}
+		}
+	}
+	return next
+}
+
+func indexResourceSets(sets []*config.ResourceSet, components bool) *resourceIndex {
+	ri := &resourceIndex{
+		subfolders: newPrefixTrie[*compiledResource](),
+		direct:     make(map[string][]*compiledResource),
+		components: components,
+	}
+	for _, rs := range sets {
+		for i := range rs.ResourceList {
+			ri.add(&rs.ResourceList[i])
+		}
+	}
+	return ri
+}
+
+func (ri *resourceIndex) add(resource *config.Resource) {
+	// HDFS paths never name files under a local guard point
+	if resource.HSDFS {
+		return
+	}
+	ri.count++
+	cr := compileResource(resource, ri.components)
+	if ri.components {
+		// The byte-wise prefix only narrows the candidates; matches
+		// checks the directory by component
+		ri.subfolders.insert(literalPrefix(cr.directory), cr)
+		return
+	}
+	resourceDir := strings.TrimPrefix(resource.Directory, "/")
+	if resource.Subfolder {
+		ri.subfolders.insert(resourceDir, cr)
+		return
+	}
+	if resource.Directory == "" {
+		resourceDir = "."
+	}
+	ri.direct[resourceDir] = append(ri.direct[resourceDir], cr)
+}
+
+// candidates calls fn for each resource whose directory covers relPath,
+// stopping early if fn returns true.
+func (ri *resourceIndex) candidates(relPath string, fn func(*compiledResource) bool) bool {
+	if ri.subfolders.prefixesOf(relPath, fn) {
+		return true
+	}
+	for _, resource := range ri.direct[filepath.Dir(relPath)] {
+		if fn(resource) {
+			return true
+		}
+	}
+	return false
+}
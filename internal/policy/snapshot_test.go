@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	cfg := &config.Config{
+		GuardPoints: []config.GuardPoint{{
+			Code:              "gp",
+			ProtectedPath:     "/data/hr",
+			SecureStoragePath: "/secure/hr",
+			Policy:            "p",
+			Enabled:           true,
+		}},
+		UserSets: []config.UserSet{{Code: "hr", Users: []config.User{{UID: 1000}}}},
+		Policies: []config.Policy{{
+			Code: "p",
+			SecurityRules: []config.SecurityRule{
+				{ID: "hr", Order: 1, Action: []string{"read"}, UserSet: []string{"hr"}, Effect: config.RuleEffect{Permission: "permit"}},
+				{ID: "deny", Order: 2, Action: []string{"all_ops"}, Effect: config.RuleEffect{Permission: "deny"}},
+			},
+		}},
+	}
+	engine := NewEngine(cfg)
+	engine.SetDecisionCache(NewDecisionCache(16))
+	decide := func(uid int) string {
+		t.Helper()
+		result, err := engine.EvaluateAccess(&AccessRequest{Path: "/data/hr/salaries", Action: "read", UID: uid, GID: uid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RuleID
+	}
+
+	// Neither the configuration the engine was given nor the one it
+	// hands out reach the running snapshot
+	cfg.UserSets[0].Users[0].UID = 2000
+	cfg.GuardPoints[0].Enabled = false
+	handed := engine.Config()
+	handed.UserSets[0].Users[0].UID = 3000
+	handed.Policies[0].SecurityRules[0].Effect.Permission = "deny"
+
+	if got := decide(1000); got != "hr" {
+		t.Errorf("uid 1000: rule %s, want hr", got)
+	}
+	if got := decide(2000); got != "deny" {
+		t.Errorf("uid 2000: rule %s, want deny", got)
+	}
+	if got := decide(3000); got != "deny" {
+		t.Errorf("uid 3000: rule %s, want deny", got)
+	}
+	if gp := engine.FindGuardPoint("/data/hr/salaries"); gp == nil || !gp.Enabled {
+		t.Errorf("FindGuardPoint = %+v, want the enabled guard point", gp)
+	}
+
+	// Neither do the guard points it hands out
+	engine.FindGuardPoint("/data/hr/salaries").Enabled = false
+	result, err := engine.EvaluateAccess(&AccessRequest{Path: "/data/hr/salaries", Action: "read", UID: 1000, GID: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.GuardPoint.Enabled = false
+	result.GuardPoint.Policy = "other"
+	if gp := engine.FindGuardPoint("/data/hr/salaries"); gp == nil || !gp.Enabled || gp.Policy != "p" {
+		t.Errorf("FindGuardPoint = %+v, want the enabled guard point of policy p", gp)
+	}
+	// A cached decision is handed out again with a fresh guard point
+	result, err = engine.EvaluateAccess(&AccessRequest{Path: "/data/hr/salaries", Action: "read", UID: 1000, GID: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RuleID != "hr" || !result.GuardPoint.Enabled || result.GuardPoint.Policy != "p" {
+		t.Errorf("result = rule %s, guard point %+v; want rule hr and the enabled guard point of policy p", result.RuleID, result.GuardPoint)
+	}
+
+	// The configuration copy is made once per snapshot
+	if engine.Config() != handed {
+		t.Error("Config() copied the configuration again within a snapshot")
+	}
+	engine.Update(cfg)
+	if engine.Config() == handed {
+		t.Error("Config() kept the copy of the previous snapshot")
+	}
+}
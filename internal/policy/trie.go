@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// pathTrie maps absolute directory paths to values, keyed by path
+// component, and answers "which entry is the deepest ancestor of this
+// path" in time proportional to the path depth.
+type pathTrie[V any] struct {
+	children map[string]*pathTrie[V]
+	value    V
+	set      bool
+}
+
+func newPathTrie[V any]() *pathTrie[V] {
+	return &pathTrie[V]{}
+}
+
+// insert stores value at path unless the path already holds a value; the
+// first entry for a path wins, matching configuration order.
+func (t *pathTrie[V]) insert(path string, value V) {
+	node := t
+	for _, component := range splitPath(path) {
+		if node.children == nil {
+			node.children = make(map[string]*pathTrie[V])
+		}
+		child := node.children[component]
+		if child == nil {
+			child = &pathTrie[V]{}
+			node.children[component] = child
+		}
+		node = child
+	}
+	if !node.set {
+		node.value = value
+		node.set = true
+	}
+}
+
+// longestPrefix returns the value stored at the deepest ancestor of path
+// (path itself included).
+func (t *pathTrie[V]) longestPrefix(path string) (V, bool) {
+	var best V
+	found := false
+
+	node := t
+	if node.set {
+		best, found = node.value, true
+	}
+	for _, component := range splitPath(path) {
+		node = node.children[component]
+		if node == nil {
+			break
+		}
+		if node.set {
+			best, found = node.value, true
+		}
+	}
+	return best, found
+}
+
+func splitPath(path string) []string {
+	path = filepath.Clean(path)
+	if path == "/" || path == "." {
+		return nil
+	}
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// prefixTrie is a byte-wise trie used to find every stored key that is a
+// string prefix of a lookup key.
+type prefixTrie[V any] struct {
+	children map[byte]*prefixTrie[V]
+	values   []V
+}
+
+func newPrefixTrie[V any]() *prefixTrie[V] {
+	return &prefixTrie[V]{}
+}
+
+func (t *prefixTrie[V]) insert(key string, value V) {
+	node := t
+	for i := 0; i < len(key); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*prefixTrie[V])
+		}
+		child := node.children[key[i]]
+		if child == nil {
+			child = &prefixTrie[V]{}
+			node.children[key[i]] = child
+		}
+		node = child
+	}
+	node.values = append(node.values, value)
+}
+
+// prefixesOf calls fn for the values of every stored key that is a prefix
+// of key, shortest first, stopping early if fn returns true.
+func (t *prefixTrie[V]) prefixesOf(key string, fn func(V) bool) bool {
+	node := t
+	for i := 0; ; i++ {
+		for _, v := range node.values {
+			if fn(v) {
+				return true
+			}
+		}
+		if i == len(key) {
+			return false
+		}
+		node = node.children[key[i]]
+		if node == nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package quota
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+// Usage is the amount of secure storage consumed by a guard point or by the
+// files owned by one user.
+type Usage struct {
+	Bytes  int64
+	Inodes int64
+}
+
+// Limits is a byte and inode ceiling. A zero field means unlimited.
+type Limits struct {
+	Bytes  int64
+	Inodes int64
+}
+
+// ExceededError is returned by Check when an operation would push a scope
+// past its limit.
+type ExceededError struct {
+	Scope    string
+	Resource string
+	Limit    int64
+	Used     int64
+	Request  int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s %s quota exceeded: used %d + %d > limit %d", e.Scope, e.Resource, e.Used, e.Request, e.Limit)
+}
+
+type setLimit struct {
+	code   string
+	uids   map[int]bool
+	limits Limits
+}
+
+// Tracker accounts the bytes and inodes stored under one guard point's
+// secure storage, in total and per owning UID, and enforces the guard
+// point's quota. A nil *Tracker accepts everything, so callers need not
+// special-case guard points without a quota.
+type Tracker struct {
+	mu          sync.Mutex
+	guardPoint  string
+	storagePath string
+	total       Limits
+	uidLimits   map[int]Limits
+	setLimits   []setLimit
+	usage       Usage
+	byUID       map[int]*Usage
+}
+
+// NewTracker builds a tracker for gp, resolving user set quotas against
+// userSets. It returns nil when the guard point has no quota configured.
+func NewTracker(gp *config.GuardPoint, userSets []config.UserSet) *Tracker {
+	if gp.Quota == nil {
+		return nil
+	}
+
+	t := &Tracker{
+		guardPoint:  gp.Code,
+		storagePath: gp.SecureStoragePath,
+		total:       Limits{Bytes: gp.Quota.MaxBytes, Inodes: gp.Quota.MaxInodes},
+		uidLimits:   make(map[int]Limits),
+		byUID:       make(map[int]*Usage),
+	}
+
+	for _, uq := range gp.Quota.Users {
+		limits := Limits{Bytes: uq.MaxBytes, Inodes: uq.MaxInodes}
+		if uq.UID != nil {
+			t.uidLimits[*uq.UID] = limits
+		}
+		if uq.UserSet != "" {
+			sl := setLimit{code: uq.UserSet, uids: make(map[int]bool), limits: limits}
+			for _, us := range userSets {
+				if us.Code != uq.UserSet {
+					continue
+				}
+				for _, u := range us.Users {
+					// Usage is tracked per owner UID; group entries
+					// have no single UID to charge
+					switch u.MatchMode() {
+					case config.MatchByUID:
+						sl.uids[u.UID] = true
+					case config.MatchByUName:
+						if uid, ok := lookupUID(u.UName); ok {
+							sl.uids[uid] = true
+						}
+					}
+				}
+			}
+			t.setLimits = append(t.setLimits, sl)
+		}
+	}
+
+	return t
+}
+
+// Scan recomputes usage from scratch by walking the secure storage. Every
+// entry below the storage root counts as one inode; regular files also
+// count their on-disk size, encryption overhead included, against the
+// owning UID.
+func (t *Tracker) Scan() error {
+	if t == nil {
+		return nil
+	}
+
+	usage := Usage{}
+	byUID := make(map[int]*Usage)
+
+	err := filepath.Walk(t.storagePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[QUOTA] Scan: skipping %s: %v", path, err)
+			return nil
+		}
+		if path == t.storagePath {
+			return nil
+		}
+
+		uid := -1
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid = int(stat.Uid)
+		}
+		var size int64
+		if info.Mode().IsRegular() {
+			size = info.Size()
+		}
+
+		usage.Bytes += size
+		usage.Inodes++
+		u := byUID[uid]
+		if u == nil {
+			u = &Usage{}
+			byUID[uid] = u
+		}
+		u.Bytes += size
+		u.Inodes++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", t.storagePath, err)
+	}
+
+	t.mu.Lock()
+	t.usage = usage
+	t.byUID = byUID
+	t.mu.Unlock()
+
+	log.Printf("[QUOTA] Scan: guard point %s uses %d bytes, %d inodes", t.guardPoint, usage.Bytes, usage.Inodes)
+	return nil
+}
+
+// Check reports whether uid may grow its usage by the given deltas.
+// Shrinking is always allowed.
+func (t *Tracker) Check(uid int, bytes, inodes int64) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.check(uid, bytes, inodes)
+}
+
+// Reserve checks like Check and, if uid may grow, charges the deltas in
+// the same step, so that concurrent operations cannot all pass the check
+// against the same usage. What the operation did not use is given back
+// with Charge.
+func (t *Tracker) Reserve(uid int, bytes, inodes int64) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(uid, bytes, inodes); err != nil {
+		return err
+	}
+	t.charge(uid, bytes, inodes)
+	return nil
+}
+
+func (t *Tracker) check(uid int, bytes, inodes int64) error {
+	if err := exceeds("guard point "+t.guardPoint, t.total, t.usage, bytes, inodes); err != nil {
+		return err
+	}
+	if limits, ok := t.uidLimits[uid]; ok {
+		if err := exceeds(fmt.Sprintf("uid %d", uid), limits, t.uidUsage(uid), bytes, inodes); err != nil {
+			return err
+		}
+	}
+	for _, sl := range t.setLimits {
+		if !sl.uids[uid] {
+			continue
+		}
+		if err := exceeds("user set "+sl.code, sl.limits, t.setUsage(sl), bytes, inodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Charge records a change in usage for uid. Negative deltas release space.
+func (t *Tracker) Charge(uid int, bytes, inodes int64) {
+	if t == nil || (bytes == 0 && inodes == 0) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.charge(uid, bytes, inodes)
+}
+
+func (t *Tracker) charge(uid int, bytes, inodes int64) {
+	t.usage.Bytes = clampZero(t.usage.Bytes + bytes)
+	t.usage.Inodes = clampZero(t.usage.Inodes + inodes)
+	u := t.byUID[uid]
+	if u == nil {
+		u = &Usage{}
+		t.byUID[uid] = u
+	}
+	u.Bytes = clampZero(u.Bytes + bytes)
+	u.Inodes = clampZero(u.Inodes + inodes)
+}
+
+// Effective returns the limits and usage that bind uid most tightly, for
+// reporting through statfs. Unlimited dimensions are returned as zero.
+func (t *Tracker) Effective(uid int) (Limits, Usage) {
+	if t == nil {
+		return Limits{}, Usage{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limits, usage := Limits{}, Usage{}
+	consider := func(l Limits, u Usage) {
+		if l.Bytes > 0 && (limits.Bytes == 0 || l.Bytes-u.Bytes < limits.Bytes-usage.Bytes) {
+			limits.Bytes, usage.Bytes = l.Bytes, u.Bytes
+		}
+		if l.Inodes > 0 && (limits.Inodes == 0 || l.Inodes-u.Inodes < limits.Inodes-usage.Inodes) {
+			limits.Inodes, usage.Inodes = l.Inodes, u.Inodes
+		}
+	}
+
+	consider(t.total, t.usage)
+	if l, ok := t.uidLimits[uid]; ok {
+		consider(l, t.uidUsage(uid))
+	}
+	for _, sl := range t.setLimits {
+		if sl.uids[uid] {
+			consider(sl.limits, t.setUsage(sl))
+		}
+	}
+	if limits.Bytes == 0 {
+		usage.Bytes = t.usage.Bytes
+	}
+	if limits.Inodes == 0 {
+		usage.Inodes = t.usage.Inodes
+	}
+	return limits, usage
+}
+
+func (t *Tracker) uidUsage(uid int) Usage {
+	if u := t.byUID[uid]; u != nil {
+		return *u
+	}
+	return Usage{}
+}
+
+func (t *Tracker) setUsage(sl setLimit) Usage {
+	var usage Usage
+	for uid := range sl.uids {
+		u := t.uidUsage(uid)
+		usage.Bytes += u.Bytes
+		usage.Inodes += u.Inodes
+	}
+	return usage
+}
+
+func exceeds(scope string, limits Limits, usage Usage, bytes, inodes int64) error {
+	if limits.Bytes > 0 && bytes > 0 && usage.Bytes+bytes > limits.Bytes {
+		return &ExceededError{Scope: scope, Resource: "byte", Limit: limits.Bytes, Used: usage.Bytes, Request: bytes}
+	}
+	if limits.Inodes > 0 && inodes > 0 && usage.Inodes+inodes > limits.Inodes {
+		return &ExceededError{Scope: scope, Resource: "inode", Limit: limits.Inodes, Used: usage.Inodes, Request: inodes}
+	}
+	return nil
+}
+
+func clampZero(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+func lookupUID(name string) (int, bool) {
+	pw, err := user.Lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	uid, err := strconv.Atoi(pw.Uid)
+	return uid, err == nil
+}
@@ -0,0 +1,82 @@
+package quota
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/takakrypt/transparent-encryption/internal/config"
+)
+
+func TestReserveConcurrent(t *testing.T) {
+	uid := 1000
+	tracker := NewTracker(&config.GuardPoint{
+		Code: "gp",
+		Quota: &config.GuardPointQuota{
+			MaxInodes: 100,
+			Users:     []config.UserQuota{{UID: &uid, MaxBytes: 10}},
+		},
+	}, nil)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for n := 0; n < 50; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := tracker.Reserve(uid, 1, 1)
+			var exceeded *ExceededError
+			if err != nil && !errors.As(err, &exceeded) {
+				t.Errorf("Reserve: unexpected error %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if granted != 10 {
+		t.Fatalf("granted %d reservations, want 10", granted)
+	}
+	limits, usage := tracker.Effective(uid)
+	if limits.Bytes != 10 || usage.Bytes != 10 || usage.Inodes != 10 {
+		t.Errorf("Effective = %+v, %+v; want 10 bytes of 10 used, 10 inodes", limits, usage)
+	}
+}
+
+func TestReserveRelease(t *testing.T) {
+	tracker := NewTracker(&config.GuardPoint{
+		Code:  "gp",
+		Quota: &config.GuardPointQuota{MaxBytes: 100},
+	}, nil)
+
+	if err := tracker.Reserve(1, 80, 0); err != nil {
+		t.Fatalf("Reserve(80): %v", err)
+	}
+	if err := tracker.Reserve(2, 30, 0); err == nil {
+		t.Fatal("Reserve(30) over a reservation of 80 succeeded")
+	}
+	// The first operation used only half of what it reserved
+	tracker.Charge(1, -40, 0)
+	if err := tracker.Reserve(2, 30, 0); err != nil {
+		t.Fatalf("Reserve(30) after release: %v", err)
+	}
+	if err := tracker.Check(3, 31, 0); err == nil {
+		t.Error("Check(31) with 70 of 100 used succeeded")
+	}
+	if err := tracker.Reserve(1, -40, 0); err != nil {
+		t.Errorf("Reserve of a shrink: %v", err)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	if err := tracker.Reserve(0, 1<<40, 1<<20); err != nil {
+		t.Errorf("nil tracker Reserve: %v", err)
+	}
+	tracker.Charge(0, 1, 1)
+}